@@ -12,9 +12,16 @@ type Message struct {
 }
 
 type Conversation struct {
-	Messages  []Message
-	SessionID string
-	CreatedAt time.Time
+	Messages     []Message
+	SessionID    string
+	CreatedAt    time.Time
+	Tags         []string
+	BranchedFrom string // Session this one was forked from, if any
+
+	// Summary stands in for the first SummarizedCount messages in prompts,
+	// after /compact
+	Summary         string `json:",omitempty"`
+	SummarizedCount int    `json:",omitempty"`
 }
 
 type SessionManager struct {
@@ -34,7 +41,7 @@ type ReasoningStep struct {
 	Thought string `json:"thought"`
 	Action  string `json:"action"`
 	Result  string `json:"result"`
-	Status  string `json:"status"` // "pending", "in_progress", "completed", "failed"
+	Status  string `json:"status"` // "pending", "in_progress", "completed", "failed", "cancelled"
 }
 
 // MultiTurnReasoning manages step-by-step problem solving
@@ -42,6 +49,7 @@ type MultiTurnReasoning struct {
 	Steps       []ReasoningStep `json:"steps"`
 	CurrentStep int             `json:"current_step"`
 	IsComplete  bool            `json:"is_complete"`
+	Cancelled   bool            `json:"cancelled,omitempty"`
 	Problem     string          `json:"problem"`
 	Solution    string          `json:"solution"`
 	CreatedAt   time.Time       `json:"created_at"`
@@ -137,6 +145,28 @@ func (rm *ReasoningManager) CompleteReasoning(sessionID, solution string) error
 	return nil
 }
 
+// AbortReasoning stops a reasoning session before it finishes: unfinished
+// steps are marked cancelled, the session is marked complete, and it is
+// removed from the active sessions. The aborted session is returned.
+func (rm *ReasoningManager) AbortReasoning(sessionID string) (*MultiTurnReasoning, error) {
+	reasoning, exists := rm.ActiveReasoning[sessionID]
+	if !exists {
+		return nil, fmt.Errorf("no active reasoning session for session %s", sessionID)
+	}
+
+	for i := range reasoning.Steps {
+		if reasoning.Steps[i].Status == "pending" || reasoning.Steps[i].Status == "in_progress" {
+			reasoning.Steps[i].Status = "cancelled"
+		}
+	}
+	reasoning.IsComplete = true
+	reasoning.Cancelled = true
+	reasoning.UpdatedAt = time.Now()
+
+	delete(rm.ActiveReasoning, sessionID)
+	return reasoning, nil
+}
+
 // GetReasoning returns the current reasoning session
 func (rm *ReasoningManager) GetReasoning(sessionID string) (*MultiTurnReasoning, error) {
 	reasoning, exists := rm.ActiveReasoning[sessionID]
@@ -167,6 +197,8 @@ func (rm *ReasoningManager) GetReasoningSummary(sessionID string) (string, error
 			statusIcon = "❌"
 		case "in_progress":
 			statusIcon = "🔄"
+		case "cancelled":
+			statusIcon = "🚫"
 		}
 
 		summary.WriteString(fmt.Sprintf("%s Step %d: %s\n", statusIcon, i+1, step.Thought))
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// contextCacheEntry holds a project context built by LoadProjectContext along
+// with the modification times of the files it was built from
+type contextCacheEntry struct {
+	ProjectType string
+	ProjectInfo string
+	CodeContext string
+	ModTimes    map[string]time.Time
+}
+
+// Project context cache shared across turns, keyed by absolute project path
+var (
+	contextCache   = make(map[string]*contextCacheEntry)
+	contextCacheMu sync.Mutex
+)
+
+// fileSnapshot records the state of a file at the time it was read into context
+type fileSnapshot struct {
+	ModTime time.Time
+	Hash    string
+}
+
+// Snapshots of every file read into context, keyed by absolute file path
+var fileSnapshots = make(map[string]fileSnapshot)
+
+// contextCacheKey normalizes a project path into a cache key
+func contextCacheKey(projectPath string) string {
+	if abs, err := filepath.Abs(projectPath); err == nil {
+		return abs
+	}
+	return filepath.Clean(projectPath)
+}
+
+// statTrackedFiles returns the modification time of each tracked file.
+// Missing files are recorded with a zero time so that creating them later
+// also invalidates the cache.
+func statTrackedFiles(projectPath string, files []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(projectPath, file))
+		if err != nil {
+			modTimes[file] = time.Time{}
+			continue
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes
+}
+
+// lookupContextCache returns the cached context if the project type and every
+// tracked file's modification time are unchanged
+func lookupContextCache(projectPath, projectType string, modTimes map[string]time.Time) (*contextCacheEntry, bool) {
+	contextCacheMu.Lock()
+	defer contextCacheMu.Unlock()
+
+	entry, exists := contextCache[contextCacheKey(projectPath)]
+	if !exists || entry.ProjectType != projectType || len(entry.ModTimes) != len(modTimes) {
+		return nil, false
+	}
+
+	for file, modTime := range modTimes {
+		cached, ok := entry.ModTimes[file]
+		if !ok || !cached.Equal(modTime) {
+			return nil, false
+		}
+	}
+
+	return entry, true
+}
+
+// storeContextCache saves a freshly built context for reuse on later turns
+func storeContextCache(projectPath string, entry *contextCacheEntry) {
+	contextCacheMu.Lock()
+	defer contextCacheMu.Unlock()
+
+	contextCache[contextCacheKey(projectPath)] = entry
+}
+
+// InvalidateProjectContext drops the cached context for a project so the next
+// LoadProjectContext call re-reads every file from disk
+func InvalidateProjectContext(projectPath string) {
+	contextCacheMu.Lock()
+	defer contextCacheMu.Unlock()
+
+	delete(contextCache, contextCacheKey(projectPath))
+}
+
+// hashContent returns the hex-encoded SHA-256 of the given data
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordFileSnapshot remembers a file's modification time and content hash
+// as of the moment it was loaded into the prompt context
+func recordFileSnapshot(filePath string, data []byte) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+
+	contextCacheMu.Lock()
+	defer contextCacheMu.Unlock()
+
+	fileSnapshots[contextCacheKey(filePath)] = fileSnapshot{
+		ModTime: info.ModTime(),
+		Hash:    hashContent(data),
+	}
+}
+
+// RefreshFileSnapshot re-reads a file and records its current state as loaded
+func RefreshFileSnapshot(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	recordFileSnapshot(filePath, data)
+	return nil
+}
+
+// FileChangedSinceLoad reports whether a file that was loaded into context has
+// been modified on disk since. Files that were never loaded report false.
+func FileChangedSinceLoad(filePath string) bool {
+	contextCacheMu.Lock()
+	snapshot, exists := fileSnapshots[contextCacheKey(filePath)]
+	contextCacheMu.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		// The file was deleted after it was read
+		return true
+	}
+	if info.ModTime().Equal(snapshot.ModTime) {
+		return false
+	}
+
+	// The modification time moved; only report a change if the content did too
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return true
+	}
+	return hashContent(data) != snapshot.Hash
+}
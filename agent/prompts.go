@@ -1,25 +1,67 @@
 package agent
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+
+	"github.com/muratbekj/silent-code/config"
+	textfs "github.com/muratbekj/silent-code/fs"
+	"github.com/muratbekj/silent-code/ignore"
 )
 
 type PromptBuilder struct {
 	SystemPrompt string
+	Instructions string // The project's standing instructions, from one of instructionFiles
 	CodeContext  string
 	ProjectInfo  string
 }
 
+// instructionFiles are where a project keeps standing instructions for the
+// assistant, in order of preference; only the first one found is used
+var instructionFiles = []string{filepath.Join(".silent-code", "context.md"), "AGENTS.md", "CLAUDE.md"}
+
+// systemPromptOverride replaces the default system prompt when set
+var (
+	systemPromptOverride   string
+	systemPromptOverrideMu sync.RWMutex
+)
+
 // NewPromptBuilder creates a new prompt builder
 func NewPromptBuilder() *PromptBuilder {
-	return &PromptBuilder{
+	pb := &PromptBuilder{
 		SystemPrompt: getSystemPrompt(),
 		CodeContext:  "",
 		ProjectInfo:  "",
 	}
+	if override, ok := SystemPromptOverride(); ok {
+		pb.UpdateSystemPrompt(override)
+	}
+	return pb
+}
+
+// SetSystemPrompt makes every new prompt builder use prompt instead of the
+// default system prompt; an empty prompt restores the default
+func SetSystemPrompt(prompt string) {
+	systemPromptOverrideMu.Lock()
+	defer systemPromptOverrideMu.Unlock()
+	systemPromptOverride = prompt
+}
+
+// SystemPromptOverride returns the system prompt set with SetSystemPrompt
+func SystemPromptOverride() (string, bool) {
+	systemPromptOverrideMu.RLock()
+	defer systemPromptOverrideMu.RUnlock()
+	return systemPromptOverride, systemPromptOverride != ""
+}
+
+// DefaultSystemPrompt returns the built-in system prompt
+func DefaultSystemPrompt() string {
+	return getSystemPrompt()
 }
 
 // getSystemPrompt returns the base system prompt for coding assistance
@@ -53,40 +95,110 @@ You are running locally via Ollama and have access to the project files.`
 
 // LoadProjectContext loads relevant project information
 func (pb *PromptBuilder) LoadProjectContext(projectPath string) error {
+	pb.Instructions = loadInstructions(projectPath)
+
 	// Detect project type and load appropriate files
 	projectType := detectProjectType(projectPath)
 
+	// Drop anything excluded by .gitignore or .silent-codeignore
+	matcher := ignore.Load(projectPath)
+	configFiles := filterIgnored(matcher, getConfigFiles(projectType))
+	mainFiles := filterIgnored(matcher, expandFilePatterns(projectPath, getMainFiles(projectType)))
+
+	// Reuse the context built on a previous turn if none of its files changed
+	trackedFiles := append(append([]string{}, configFiles...), mainFiles...)
+	trackedFiles = append(trackedFiles, ignore.Files...)
+	modTimes := statTrackedFiles(projectPath, trackedFiles)
+	if entry, ok := lookupContextCache(projectPath, projectType, modTimes); ok {
+		pb.ProjectInfo += entry.ProjectInfo
+		pb.CodeContext = entry.CodeContext
+		return nil
+	}
+
 	// Load project-specific configuration files
-	configFiles := getConfigFiles(projectType)
+	var projectInfo string
 	for _, file := range configFiles {
 		filePath := filepath.Join(projectPath, file)
-		if data, err := os.ReadFile(filePath); err == nil {
-			ext := filepath.Ext(file)
-			language := getLanguageFromExtension(ext)
-			pb.ProjectInfo += fmt.Sprintf("Project Info (%s):\n```%s\n%s\n```\n", file, language, string(data))
+		if data, text, err := readContextFile(filePath); err == nil {
+			recordFileSnapshot(filePath, data)
+			language := detectFileLanguage(file, data)
+			projectInfo += fmt.Sprintf("Project Info (%s):\n```%s\n%s\n```\n", file, language, text)
 		}
 	}
 
 	// Load main files for context based on project type
-	mainFiles := getMainFiles(projectType)
 	var contextParts []string
 
 	for _, file := range mainFiles {
 		filePath := filepath.Join(projectPath, file)
-		if data, err := os.ReadFile(filePath); err == nil {
-			contextParts = append(contextParts, fmt.Sprintf("// %s\n%s", file, string(data)))
+		if data, text, err := readContextFile(filePath); err == nil {
+			recordFileSnapshot(filePath, data)
+			contextParts = append(contextParts, fmt.Sprintf("// %s\n%s", file, text))
 		}
 	}
 
+	var codeContext string
 	if len(contextParts) > 0 {
 		// Use the most common language in the project for code context
 		primaryLanguage := getPrimaryLanguage(projectPath)
-		pb.CodeContext = fmt.Sprintf("Current Project Files:\n```%s\n%s\n```\n", primaryLanguage, strings.Join(contextParts, "\n\n"))
+		codeContext = fmt.Sprintf("Current Project Files:\n```%s\n%s\n```\n", primaryLanguage, strings.Join(contextParts, "\n\n"))
+	}
+
+	storeContextCache(projectPath, &contextCacheEntry{
+		ProjectType: projectType,
+		ProjectInfo: projectInfo,
+		CodeContext: codeContext,
+		ModTimes:    modTimes,
+	})
+
+	pb.ProjectInfo += projectInfo
+	if codeContext != "" {
+		pb.CodeContext = codeContext
 	}
 
 	return nil
 }
 
+// loadInstructions returns the project's standing instructions, labelled with
+// the file they came from, or "" when the project has none
+func loadInstructions(projectPath string) string {
+	file, text := findInstructions(projectPath)
+	if file == "" {
+		return ""
+	}
+	return fmt.Sprintf("Project Instructions (%s) - follow these in every answer; they take priority over the project files below:\n%s", file, text)
+}
+
+// InstructionsFile returns which of instructionFiles the project's standing
+// instructions come from, or "" when it has none
+func InstructionsFile(projectPath string) string {
+	file, _ := findInstructions(projectPath)
+	return file
+}
+
+// findInstructions returns the first non-empty instructions file and its text
+func findInstructions(projectPath string) (string, string) {
+	for _, file := range instructionFiles {
+		_, text, err := readContextFile(filepath.Join(projectPath, file))
+		if err != nil || strings.TrimSpace(text) == "" {
+			continue
+		}
+		return file, strings.TrimSpace(text)
+	}
+	return "", ""
+}
+
+// filterIgnored removes files matched by the project's ignore rules
+func filterIgnored(matcher *ignore.Matcher, files []string) []string {
+	var kept []string
+	for _, file := range files {
+		if !matcher.Match(file, false) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
 // detectProjectType detects the type of project based on configuration files
 func detectProjectType(projectPath string) string {
 	configFiles := map[string]string{
@@ -135,35 +247,76 @@ func getConfigFiles(projectType string) []string {
 	return []string{}
 }
 
-// getMainFiles returns main files for a project type
+// getMainFiles returns the main file patterns for a project type from the config
 func getMainFiles(projectType string) []string {
-	mainFilesMap := map[string][]string{
-		"Go":                 {"main.go", "cmd/root.go", "README.md"},
-		"JavaScript/Node.js": {"index.js", "app.js", "server.js", "package.json", "README.md"},
-		"Python":             {"main.py", "app.py", "requirements.txt", "README.md"},
-		"Java":               {"src/main/java", "pom.xml", "README.md"},
-		"Java/Gradle":        {"src/main/java", "build.gradle", "README.md"},
-		"Rust":               {"src/main.rs", "Cargo.toml", "README.md"},
-		"PHP":                {"index.php", "composer.json", "README.md"},
-		"Ruby":               {"main.rb", "app.rb", "Gemfile", "README.md"},
-		"Swift/Objective-C":  {"main.swift", "AppDelegate.swift", "README.md"},
-		"Elixir":             {"lib", "mix.exs", "README.md"},
-		"Dart/Flutter":       {"lib/main.dart", "pubspec.yaml", "README.md"},
-	}
-
-	if files, exists := mainFilesMap[projectType]; exists {
+	mainFiles := config.Get().MainFiles
+	if files, exists := mainFiles[projectType]; exists {
+		return files
+	}
+	if files, exists := mainFiles["Unknown"]; exists {
 		return files
 	}
-
 	return []string{"README.md"}
 }
 
+// maxGlobMatches caps how many files a single glob pattern adds to the context
+const maxGlobMatches = 3
+
+// expandFilePatterns resolves file names and globs against projectPath,
+// returning the regular files that exist
+func expandFilePatterns(projectPath string, patterns []string) []string {
+	var files []string
+	seen := make(map[string]bool)
+
+	add := func(rel string) bool {
+		info, err := os.Stat(filepath.Join(projectPath, rel))
+		if err != nil || info.IsDir() || seen[rel] {
+			return false
+		}
+		seen[rel] = true
+		files = append(files, rel)
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(projectPath, pattern))
+		if err != nil {
+			continue
+		}
+		added := 0
+		for _, match := range matches {
+			if added >= maxGlobMatches {
+				break
+			}
+			rel, err := filepath.Rel(projectPath, match)
+			if err == nil && add(filepath.ToSlash(rel)) {
+				added++
+			}
+		}
+	}
+
+	return files
+}
+
+// LanguageForFile returns the language of a file from its extension, e.g.
+// "python" for main.py, or "text" when the extension isn't known
+func LanguageForFile(filePath string) string {
+	return getLanguageFromExtension(filepath.Ext(filePath))
+}
+
 // getLanguageFromExtension returns the language name for a file extension
 func getLanguageFromExtension(ext string) string {
 	languageMap := map[string]string{
 		".go":    "go",
 		".js":    "javascript",
 		".ts":    "typescript",
+		".tsx":   "typescript",
+		".jsx":   "javascript",
 		".py":    "python",
 		".java":  "java",
 		".cpp":   "cpp",
@@ -206,8 +359,97 @@ func getLanguageFromExtension(ext string) string {
 	return "text"
 }
 
+// knownFileLanguages maps extension-less file names to their language
+var knownFileLanguages = map[string]string{
+	"dockerfile":     "dockerfile",
+	"containerfile":  "dockerfile",
+	"makefile":       "makefile",
+	"gnumakefile":    "makefile",
+	"cmakelists.txt": "cmake",
+	"jenkinsfile":    "groovy",
+	"vagrantfile":    "ruby",
+	"gemfile":        "ruby",
+	"rakefile":       "ruby",
+	"procfile":       "yaml",
+	"go.mod":         "go",
+	".bashrc":        "bash",
+	".zshrc":         "bash",
+	".profile":       "bash",
+}
+
+// shebangLanguages maps script interpreters to their language
+var shebangLanguages = map[string]string{
+	"sh":      "bash",
+	"bash":    "bash",
+	"zsh":     "bash",
+	"ksh":     "bash",
+	"dash":    "bash",
+	"python":  "python",
+	"node":    "javascript",
+	"deno":    "typescript",
+	"ts-node": "typescript",
+	"ruby":    "ruby",
+	"perl":    "perl",
+	"php":     "php",
+	"lua":     "lua",
+	"Rscript": "r",
+}
+
+// detectFileLanguage returns the language of a file from its extension,
+// falling back to well-known file names and the shebang line when the
+// extension doesn't identify it
+func detectFileLanguage(filePath string, data []byte) string {
+	if lang, ok := knownFileLanguages[strings.ToLower(filepath.Base(filePath))]; ok {
+		return lang
+	}
+
+	if lang := getLanguageFromExtension(filepath.Ext(filePath)); lang != "text" {
+		return lang
+	}
+
+	if lang := languageFromShebang(data); lang != "" {
+		return lang
+	}
+
+	return "text"
+}
+
+// languageFromShebang reads a "#!" line such as "#!/usr/bin/env python3"
+func languageFromShebang(data []byte) string {
+	firstLine, _, _ := strings.Cut(string(data), "\n")
+	if !strings.HasPrefix(firstLine, "#!") {
+		return ""
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(firstLine, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	// With /usr/bin/env the interpreter is the first argument that isn't a flag
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") && !strings.Contains(field, "=") {
+				interpreter = field
+				break
+			}
+		}
+	}
+
+	// Strip version suffixes like python3.11
+	name := strings.TrimRight(interpreter, "0123456789.")
+	return shebangLanguages[name]
+}
+
 // getPrimaryLanguage determines the primary language of the project
 func getPrimaryLanguage(projectPath string) string {
+	if lang := dominantSourceLanguage(projectPath); lang != "" {
+		return lang
+	}
+
+	// No source files, or a tie: fall back to the project's config files
 	projectType := detectProjectType(projectPath)
 
 	languageMap := map[string]string{
@@ -231,6 +473,51 @@ func getPrimaryLanguage(projectPath string) string {
 	return "go" // Default fallback
 }
 
+// maxLanguageScanFiles bounds how many files dominantSourceLanguage looks at
+const maxLanguageScanFiles = 5000
+
+// nonSourceLanguages are counted out when deciding a project's main language
+var nonSourceLanguages = map[string]bool{
+	"text": true, "markdown": true, "json": true, "yaml": true, "toml": true, "ini": true,
+	"xml": true, "html": true, "css": true, "scss": true, "sass": true, "less": true, "sql": true,
+}
+
+// dominantSourceLanguage returns the language with the most source files in
+// the project, or "" when there are none or the top languages tie. Hidden,
+// ignored, and dependency directories are skipped so a vendored tool or a
+// stray go.mod doesn't decide the language.
+func dominantSourceLanguage(projectPath string) string {
+	matcher := ignore.Load(projectPath)
+	counts := make(map[string]int)
+	scanned := 0
+
+	matcher.Walk(projectPath, ignore.ConfiguredLimits(), func(path, rel string, d fs.DirEntry) error {
+		scanned++
+		if scanned > maxLanguageScanFiles {
+			return filepath.SkipAll
+		}
+
+		if lang := getLanguageFromExtension(filepath.Ext(path)); !nonSourceLanguages[lang] {
+			counts[lang]++
+		}
+		return nil
+	})
+
+	best, bestCount, tied := "", 0, false
+	for lang, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, tied = lang, count, false
+		case count == bestCount:
+			tied = true
+		}
+	}
+	if tied {
+		return ""
+	}
+	return best
+}
+
 // BuildPrompt constructs the full prompt with context
 func (pb *PromptBuilder) BuildPrompt(userInput string, conversationHistory []string) string {
 	var parts []string
@@ -238,6 +525,11 @@ func (pb *PromptBuilder) BuildPrompt(userInput string, conversationHistory []str
 	// Add system prompt
 	parts = append(parts, fmt.Sprintf("System: %s", pb.SystemPrompt))
 
+	// The project's own instructions come before anything loaded automatically
+	if pb.Instructions != "" {
+		parts = append(parts, pb.Instructions)
+	}
+
 	// Add project context if available
 	if pb.ProjectInfo != "" {
 		parts = append(parts, pb.ProjectInfo)
@@ -273,17 +565,37 @@ func (pb *PromptBuilder) UpdateSystemPrompt(newPrompt string) {
 	pb.SystemPrompt = newPrompt
 }
 
+// readContextFile reads a file for the prompt, returning the raw bytes for
+// snapshots and the decoded text; binary files return fs.ErrBinaryFile
+func readContextFile(filePath string) ([]byte, string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, "", err
+	}
+	text, err := textfs.DecodeText(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, text, nil
+}
+
 // AddFileContext adds a specific file to the context
 func (pb *PromptBuilder) AddFileContext(filePath string) error {
-	if data, err := os.ReadFile(filePath); err == nil {
+	data, text, err := readContextFile(filePath)
+	if errors.Is(err, textfs.ErrBinaryFile) {
+		return fmt.Errorf("%w: %s", err, filePath)
+	}
+	if err == nil {
+		recordFileSnapshot(filePath, data)
 		fileName := filepath.Base(filePath)
-		fileContext := fmt.Sprintf("// %s\n%s", fileName, string(data))
+		fileContext := fmt.Sprintf("// %s\n%s", fileName, text)
+		language := detectFileLanguage(filePath, data)
 
 		if pb.CodeContext == "" {
-			pb.CodeContext = fmt.Sprintf("Current Project Files:\n```go\n%s\n```\n", fileContext)
+			pb.CodeContext = fmt.Sprintf("Current Project Files:\n```%s\n%s\n```\n", language, fileContext)
 		} else {
-			// Append to existing context
-			pb.CodeContext = strings.TrimSuffix(pb.CodeContext, "```\n") + "\n\n" + fileContext + "\n```\n"
+			// Append to existing context in a block fenced for this file's language
+			pb.CodeContext += fmt.Sprintf("\n```%s\n%s\n```\n", language, fileContext)
 		}
 		return nil
 	}
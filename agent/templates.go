@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TemplatesDir holds project prompt templates, one <name>.txt file each
+const TemplatesDir = ".silent-code/templates"
+
+// templateFilePlaceholder is replaced with the target file's path
+const templateFilePlaceholder = "{{file}}"
+
+// builtinTemplates are available in every project; a file in TemplatesDir
+// with the same name overrides one
+var builtinTemplates = map[string]string{
+	"add-tests": "Add unit tests for {{file}}. Cover the main behavior and edge cases, " +
+		"using the testing conventions already used in this project.",
+	"add-docs": "Add documentation comments to the exported functions, types, and " +
+		"constants in {{file}}, following the language's doc-comment conventions. " +
+		"Do not change any code.",
+	"add-error-handling": "Add error handling to {{file}}: check errors that are " +
+		"currently ignored, return or report them with useful context, and avoid panics.",
+	"add-logging": "Add logging to {{file}} at the important steps and error paths, " +
+		"using the logging approach already used in this project. Do not log secrets.",
+}
+
+// Template is a named prompt for a common task
+type Template struct {
+	Name    string
+	Text    string
+	Builtin bool
+}
+
+// ListTemplates returns the built-in templates merged with the project's,
+// sorted by name
+func ListTemplates(projectPath string) []Template {
+	byName := make(map[string]Template)
+	for name, text := range builtinTemplates {
+		byName[name] = Template{Name: name, Text: text, Builtin: true}
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(projectPath, TemplatesDir))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".txt" {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".txt")
+		data, err := os.ReadFile(filepath.Join(projectPath, TemplatesDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		byName[name] = Template{Name: name, Text: strings.TrimSpace(string(data))}
+	}
+
+	templates := make([]Template, 0, len(byName))
+	for _, template := range byName {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates
+}
+
+// RenderTemplate loads the named template and substitutes filePath into it.
+// Templates without a {{file}} placeholder get the file appended.
+func RenderTemplate(projectPath, name, filePath string) (string, error) {
+	for _, template := range ListTemplates(projectPath) {
+		if template.Name != name {
+			continue
+		}
+		if !strings.Contains(template.Text, templateFilePlaceholder) {
+			return fmt.Sprintf("%s\n\nFile: %s", template.Text, filePath), nil
+		}
+		return strings.ReplaceAll(template.Text, templateFilePlaceholder, filePath), nil
+	}
+	return "", fmt.Errorf("no template named '%s'", name)
+}
@@ -0,0 +1,43 @@
+package agent
+
+import "unicode/utf8"
+
+// charsPerToken is the average number of characters per token for the
+// BPE-style tokenizers used by common local models
+const charsPerToken = 4
+
+// EstimateTokens approximates the number of tokens in text. Every Ollama model
+// ships its own tokenizer, so a character heuristic is used for budgeting.
+func EstimateTokens(text string) int {
+	runes := utf8.RuneCountInString(text)
+	if runes == 0 {
+		return 0
+	}
+	return (runes + charsPerToken - 1) / charsPerToken
+}
+
+// TruncateToTokens shortens text so its estimated token count fits within
+// maxTokens, cutting at a line boundary when one is available
+func TruncateToTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if EstimateTokens(text) <= maxTokens {
+		return text
+	}
+
+	runes := []rune(text)
+	cut := maxTokens * charsPerToken
+	if cut > len(runes) {
+		cut = len(runes)
+	}
+
+	// Prefer ending on a full line so code isn't cut mid-statement
+	for i := cut - 1; i > cut/2; i-- {
+		if runes[i] == '\n' {
+			return string(runes[:i+1])
+		}
+	}
+
+	return string(runes[:cut])
+}
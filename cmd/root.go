@@ -2,13 +2,29 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/muratbekj/silent-code/agent"
+	"github.com/muratbekj/silent-code/config"
+	"github.com/muratbekj/silent-code/fs"
 	"github.com/muratbekj/silent-code/history"
+	"github.com/muratbekj/silent-code/ignore"
+	"github.com/muratbekj/silent-code/index"
 	"github.com/muratbekj/silent-code/mcp"
 	"github.com/muratbekj/silent-code/ollama"
 
@@ -29,19 +45,240 @@ Core Features:
 It looks and feels like a terminal, but acts as an AI coding agent: you can ask it about 
 your project, edit files, create new ones, run tests, and reason about code — all powered 
 by local LLMs (via Ollama).`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		startBackgroundServer()
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if promptFlag != "" {
+			runOneShot(promptFlag)
+			return
+		}
 		startInteractiveMode()
 	},
 }
 
+// Whether general questions get the directory listing and files attached
+var autoContext = true
+
+// Whether the included-context line shows each item's size
+var verboseContext = false
+
+// How long shell commands may run; zero uses the MCP server's default
+var shellTimeout time.Duration
+
+// Environment variables applied to every shell command this session
+var sessionEnv = map[string]string{}
+
+// touchedFile is a file the AI created or edited this session
+type touchedFile struct {
+	Path   string
+	Action string
+	At     time.Time
+}
+
+// Files the AI created or edited this session, oldest first
+var recentFiles []touchedFile
+
+// Command-line flags
+var autoApplyFlag bool
+var promptFlag string
+var jsonFlag bool
+var historyDirFlag string
+var noStreamFlag bool
+var verboseFlag bool
+var servePort int
+var serveBind string
+var serveModel string
+
+// mcpServerURL is where the CLI reaches the MCP server started alongside it
+const mcpServerURL = "http://127.0.0.1:8080"
+
+// sharedMCPClient holds the connections to the MCP server that every command
+// reuses; startInteractiveMode creates it
+var sharedMCPClient *mcp.MCPClient
+
+// newMCPClient returns a copy of the shared MCP client, so a command can
+// change settings like ShowProgress without affecting the others while still
+// reusing the same connections. Its tool calls use the current model.
+func newMCPClient() *mcp.MCPClient {
+	if sharedMCPClient == nil {
+		// Subcommands like explain run without startInteractiveMode
+		sharedMCPClient = mcp.NewMCPClient(mcpServerURL)
+	}
+	client := *sharedMCPClient
+	client.Model = ollama.GetCurrentModel()
+	return &client
+}
+
+// syncServerModel switches the MCP server to the CLI's model, so /explain,
+// /edit, and other tools use the same model as chat
+func syncServerModel(model string) {
+	if err := newMCPClient().SetServerModel(model); err != nil {
+		fmt.Printf("⚠️  Could not switch the MCP server to %s: %v\n", model, err)
+	}
+}
+
+// startBackgroundServer starts the MCP server the CLI's commands call into
+func startBackgroundServer() {
+	go mcp.StartServer()
+
+	// Give the server time to start up
+	time.Sleep(2 * time.Second)
+}
+
+// runServe runs only the MCP server, in the foreground, for editors and
+// other external clients
+func runServe() {
+	if err := config.Load(); err != nil {
+		fmt.Printf("⚠️  %v (using defaults)\n", err)
+	}
+	mcp.SetVerbose(verboseFlag)
+
+	err := mcp.StartServerWithOptions(mcp.ServerOptions{Bind: serveBind, Port: servePort, Model: serveModel})
+	fmt.Printf("❌ Server error: %v\n", err)
+	os.Exit(1)
+}
+
+// stdoutIsTerminal reports whether output goes to an interactive terminal
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// applyStreamingMode disables streaming when asked to, or when output is
+// captured where carriage returns and typing delays would garble it
+func applyStreamingMode() {
+	if noStreamFlag || config.Get().NoStream || !stdoutIsTerminal() {
+		ollama.SetStreaming(false)
+	}
+}
+
+// oneShotResult is the --json output of a one-shot prompt
+type oneShotResult struct {
+	Response   string `json:"response"`
+	Model      string `json:"model"`
+	Tokens     int    `json:"tokens"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// oneShotError is the --json output when a one-shot prompt fails
+type oneShotError struct {
+	Error string `json:"error"`
+}
+
+// runOneShot answers a single prompt and exits, as decorated text or as JSON
+func runOneShot(prompt string) {
+	if jsonFlag {
+		ollama.SetQuiet(true)
+	}
+
+	fail := func(err error) {
+		if jsonFlag {
+			printJSON(oneShotError{Error: err.Error()})
+		} else {
+			fmt.Printf("❌ Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	if err := config.Load(); err != nil && !jsonFlag {
+		fmt.Printf("⚠️  %v (using defaults)\n", err)
+	}
+	applyStreamingMode()
+
+	if err := ollama.InitializeModelSelection(); err != nil {
+		fail(err)
+	}
+
+	start := time.Now()
+	response, err := ollama.TalkToOllamaWithResponse(prompt, "", nil)
+	if err != nil {
+		fail(err)
+	}
+
+	if jsonFlag {
+		printJSON(oneShotResult{
+			Response:   response.Content,
+			Model:      response.Model,
+			Tokens:     response.EvalCount,
+			DurationMs: time.Since(start).Milliseconds(),
+		})
+	}
+}
+
+// printJSON writes v to stdout as a single JSON document
+func printJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
 // Global session ID and history manager
 var currentSessionID string
 var historyManager *history.HistoryManager
 
+// historyDirEnv overrides where sessions are saved
+const historyDirEnv = "SILENT_CODE_HISTORY_DIR"
+
+// historyDir returns the session directory from the --history-dir flag, the
+// environment, or the config, in that order. Without any of them sessions
+// go to the user-level directory unless project-local history is enabled.
+func historyDir() string {
+	if historyDirFlag != "" {
+		return historyDirFlag
+	}
+	if dir := os.Getenv(historyDirEnv); dir != "" {
+		return dir
+	}
+	if dir := config.Get().HistoryDir; dir != "" {
+		return dir
+	}
+	if config.Get().LocalHistory {
+		return history.LocalDir
+	}
+	return history.DefaultDir()
+}
+
 // Interactive terminal mode
 func startInteractiveMode() {
-	// Initialize history
-	historyManager = history.NewHistoryManager("./history/sessions")
+	// Load user and project settings
+	if err := config.Load(); err != nil {
+		fmt.Printf("⚠️  %v (using defaults)\n", err)
+	}
+
+	// One client, and one pool of connections, for every call to the MCP server
+	sharedMCPClient = mcp.NewMCPClient(mcpServerURL)
+
+	// Initialize history, falling back to memory only if it can't be saved
+	historyManager = history.NewHistoryManager(historyDir())
+	if historyManager.CheckWritable() == nil {
+		// Bring along sessions saved in the project by older versions
+		if copied, err := historyManager.MigrateSessions(history.LocalDir); err != nil {
+			fmt.Printf("⚠️  Could not copy sessions from %s: %v\n", history.LocalDir, err)
+		} else if copied > 0 {
+			fmt.Printf("📦 Copied %d sessions from %s to %s\n", copied, history.LocalDir, historyManager.HistoryDir)
+		}
+	}
+
+	if autoApplyFlag || config.Get().AutoApply {
+		fs.SetAutoApply(true)
+	}
+	applyStreamingMode()
+	mcp.SetVerbose(verboseFlag)
+	agent.SetSystemPrompt(config.Get().SystemPrompt)
+	autoContext = config.Get().AutoContext
+	verboseContext = config.Get().VerboseContext
+	fs.SetPreviewLineLimit(config.Get().PreviewLines)
+	shellTimeout = time.Duration(config.Get().ShellTimeout) * time.Second
+	if maxSessions := config.Get().MaxSessions; maxSessions != 0 {
+		historyManager.MaxSessions = max(maxSessions, 0)
+	}
+
+	// Keep the MCP server generating with the model chosen here
+	ollama.SetModelListener(syncServerModel)
 
 	// Initialize model selection
 	fmt.Print("🔍 Detecting available models... ")
@@ -53,17 +290,47 @@ func startInteractiveMode() {
 		return
 	}
 	fmt.Printf("✅ Using model: %s\n", ollama.GetCurrentModel())
+	if config.Get().ModelHint {
+		if better, ok := ollama.BetterInstalledModel(); ok {
+			fmt.Printf("💡 %s is installed and recommended; run /config models %s\n", better, better)
+		}
+	}
+	buildAfterEdit = config.Get().BuildAfterEdit
+	warmup = config.Get().Warmup
+	if warmup {
+		startWarmup()
+	}
+
+	if embedModel := config.Get().EmbedModel; embedModel != "" {
+		if err := ollama.SetEmbedModel(embedModel); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		}
+	}
+
+	// Pick up a session cut short by a crash or kill, or start a new one
+	sessionID := recoverInterruptedSession()
+	if sessionID == "" {
+		sessionID = fmt.Sprintf("session_%d", time.Now().Unix())
+	}
+	activateSession(sessionID)
+	handleShutdownSignals()
 
-	// Create new session
-	currentSessionID = fmt.Sprintf("session_%d", time.Now().Unix())
+	ollama.SetContinuePrompt(func() bool {
+		confirm, err := fs.ConfirmAction("⏩ Response was truncated. Continue? (y/N): ")
+		return err == nil && confirm
+	})
 
 	fmt.Println("🤖 Silent Code - AI-Powered Development Assistant")
 	fmt.Printf("📝 Session: %s\n", currentSessionID)
+	if fs.AutoApply() {
+		fmt.Println("⚡ Auto-apply enabled: changes are applied without confirmation (backups are still created)")
+	}
 	fmt.Println("Type '/help' for commands, '/exit' to quit")
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	showHelp()
 
 	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // Pasted lines can be long
 
 	for {
 		fmt.Print("silent-code> ")
@@ -81,8 +348,127 @@ func startInteractiveMode() {
 			break
 		}
 
-		handleCommand(input)
+		// A trailing backslash or /paste collects several lines into one question
+		// (only the slash form, since paste is also a shell command)
+		if input == "/paste" {
+			fmt.Printf("📋 Paste your input, then end it with a line containing only '%s' (or Ctrl-D)\n", pasteTerminator)
+			input = readMultiline(scanner, "")
+		} else if strings.HasSuffix(input, "\\") {
+			input = readMultiline(scanner, strings.TrimSuffix(input, "\\"))
+		} else {
+			handleCommand(input)
+			continue
+		}
+
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+		handleGeneralQuestion(input)
+	}
+
+	saveSessionOnExit()
+}
+
+// activateSession makes sessionID the current session, keeping it in memory
+// and locking it so an unclean exit can be detected on the next start
+func activateSession(sessionID string) {
+	if currentSessionID != "" {
+		historyManager.UnlockSession(currentSessionID)
+	}
+	currentSessionID = sessionID
+	historyManager.PinSession(sessionID)
+	if err := historyManager.LockSession(sessionID); err != nil {
+		fmt.Printf("⚠️  Could not lock session: %v\n", err)
+	}
+}
+
+// recoverInterruptedSession looks for sessions left locked by a process that
+// died and offers to resume the most recent one, returning its ID if accepted
+func recoverInterruptedSession() string {
+	interrupted := historyManager.InterruptedSessions()
+	if len(interrupted) == 0 {
+		return ""
+	}
+
+	// Only the newest is offered; the rest stay available in /sessions
+	for _, sessionID := range interrupted[1:] {
+		historyManager.UnlockSession(sessionID)
+	}
+	sessionID := interrupted[0]
+	historyManager.UnlockSession(sessionID)
+
+	conversation, err := historyManager.LoadSession(sessionID)
+	if err != nil {
+		return ""
+	}
+	fmt.Printf("♻️  Session %s (%d messages) did not exit cleanly\n", sessionID, len(conversation.Messages))
+	answer, err := fs.PromptUser("Resume it? (y/N): ")
+	if err != nil || (strings.ToLower(answer) != "y" && strings.ToLower(answer) != "yes") {
+		fmt.Println("💡 It's still listed in /sessions")
+		return ""
+	}
+
+	if last := conversation.Messages[len(conversation.Messages)-1]; last.Role == "user" {
+		fmt.Println("💡 The last question was never answered; ask it again to continue")
+	}
+	return sessionID
+}
+
+// saveSessionOnExit saves any partly streamed answer and the current session,
+// then releases the session lock to mark a clean exit
+func saveSessionOnExit() {
+	ollama.FlushPendingResponse()
+	if err := historyManager.FlushSession(currentSessionID); err != nil {
+		fmt.Printf("⚠️  Could not save session: %v\n", err)
+	}
+	historyManager.UnlockSession(currentSessionID)
+}
+
+// handleShutdownSignals saves the session when the process is interrupted
+// or terminated, so a Ctrl-C mid-answer keeps what was said so far
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		fmt.Printf("\n💾 Saving session %s before exit...\n", currentSessionID)
+		saveSessionOnExit()
+
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
+}
+
+// pasteTerminator ends a multi-line input
+const pasteTerminator = "."
+
+// readMultiline reads lines after first until a line holding only
+// pasteTerminator or EOF, and joins them into a single input. Lines keep
+// their indentation so pasted code and stack traces stay intact.
+func readMultiline(scanner *bufio.Scanner, first string) string {
+	var lines []string
+	if strings.TrimSpace(first) != "" {
+		lines = append(lines, first)
+	}
+
+	for {
+		fmt.Print("... ")
+		if !scanner.Scan() {
+			fmt.Println()
+			break
+		}
+		line := scanner.Text()
+		if strings.TrimSpace(line) == pasteTerminator {
+			break
+		}
+		lines = append(lines, line)
 	}
+
+	return strings.Join(lines, "\n")
 }
 
 // List of app-specific commands that should NOT be treated as shell commands
@@ -90,13 +476,90 @@ var appCommands = map[string]bool{
 	"help": true, "explain": true, "generate": true, "test": true, "search": true,
 	"config": true, "status": true, "sessions": true, "context": true, "prompt": true,
 	"reason": true, "steps": true, "read": true, "edit": true, "new": true,
+	"rollback-session": true, "diff": true, "mode": true, "tool": true, "benchmark": true,
+	"refs": true, "env": true, "tag": true, "debug": true,
+	"branch": true, "jobs": true, "template": true, "cache": true, "summarize": true,
+	"retry": true, "regenerate": true, "edit-all": true,
+	"e": true, "g": true, "r": true, "with-output": true, "recent": true, "explain-cmd": true, "system": true, "vs": true, "cleanup": true, "tokens": true, "blame": true, "compact": true, "build": true, "fix": true,
 	"exit": true, "quit": true,
 }
 
+// builtinAliases are short forms of frequently used commands
+var builtinAliases = map[string]string{
+	"e": "explain",
+	"g": "generate",
+	"r": "read",
+}
+
+// expandAlias replaces an aliased command, with or without the slash, by what
+// it stands for. Aliases from the "aliases" config setting take precedence
+// over the built-in ones and may include arguments, e.g. "xd": "explain --deep".
+func expandAlias(parts []string) ([]string, bool) {
+	name := strings.TrimPrefix(parts[0], "/")
+
+	target, ok := config.Get().Aliases[name]
+	if !ok {
+		target, ok = builtinAliases[name]
+	}
+	if !ok {
+		return parts, false
+	}
+
+	expansion := strings.Fields(target)
+	if len(expansion) == 0 {
+		return parts, false
+	}
+	if isAppCommand(strings.TrimPrefix(expansion[0], "/")) {
+		expansion[0] = "/" + strings.TrimPrefix(expansion[0], "/")
+	}
+	return append(expansion, parts[1:]...), true
+}
+
 func isAppCommand(command string) bool {
 	return appCommands[command]
 }
 
+// maxCommandDistance is the most edits a typo can be from a command name
+// and still be suggested
+const maxCommandDistance = 2
+
+// suggestCommand returns the app command closest to a mistyped one
+func suggestCommand(command string) (string, bool) {
+	best, bestDistance := "", maxCommandDistance+1
+	for name := range appCommands {
+		// Single-letter shortcuts are within reach of almost anything
+		if len(name) < 2 {
+			continue
+		}
+		distance := levenshtein(command, name)
+		if distance < bestDistance || (distance == bestDistance && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best, best != ""
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
 // isGeneralQuestion checks if the input looks like a general question to the AI
 func isGeneralQuestion(input string) bool {
 	// Check for question words and patterns
@@ -160,6 +623,18 @@ func handleCommand(input string) {
 		return
 	}
 
+	// Aliases are expanded once, before anything else looks at the command
+	if expanded, ok := expandAlias(parts); ok {
+		parts = expanded
+		input = strings.Join(parts, " ")
+	}
+
+	// A trailing & runs the command as a background job
+	if parts[len(parts)-1] == "&" {
+		startJob(parts[:len(parts)-1])
+		return
+	}
+
 	command := parts[0]
 	args := parts[1:]
 
@@ -171,6 +646,14 @@ func handleCommand(input string) {
 
 	if isAppCommand(appCommand) {
 		// Handle app commands
+	} else if strings.HasPrefix(command, "/") && !strings.Contains(appCommand, "/") {
+		// A slash command that doesn't exist is a typo, not a path to run
+		if suggestion, ok := suggestCommand(strings.ToLower(appCommand)); ok {
+			fmt.Printf("❓ Unknown command %s - did you mean /%s?\n", command, suggestion)
+		} else {
+			fmt.Printf("❓ Unknown command %s. Type /help to see available commands\n", command)
+		}
+		return
 	} else {
 		// Check if it looks like a general question (not a shell command)
 		if isGeneralQuestion(input) {
@@ -199,9 +682,27 @@ func handleCommand(input string) {
 	case "status", "/status":
 		handleStatus()
 	case "sessions", "/sessions":
-		handleSessions()
+		handleSessions(args)
+	case "tag", "/tag":
+		handleTag(args)
+	case "debug", "/debug":
+		handleDebug(args)
+	case "branch", "/branch":
+		handleBranch()
+	case "jobs", "/jobs":
+		handleJobs(args)
+	case "template", "/template":
+		handleTemplate(args)
+	case "cache", "/cache":
+		handleCache(args)
+	case "summarize", "/summarize":
+		handleSummarize(args)
+	case "with-output", "/with-output":
+		handleWithOutput(args)
+	case "retry", "/retry", "regenerate", "/regenerate":
+		handleRetry(args)
 	case "context", "/context":
-		handleContext()
+		handleContext(args)
 	case "prompt", "/prompt":
 		handlePrompt(args)
 	case "reason", "/reason":
@@ -212,10 +713,50 @@ func handleCommand(input string) {
 		handleMCPRead(args)
 	case "edit", "/edit":
 		handleMCPEdit(args)
+	case "edit-all", "/edit-all":
+		handleEditAll(args)
 	case "new", "/new":
 		handleMCPCreate(args)
+	case "tool", "/tool":
+		handleTool(input)
+	case "mode", "/mode":
+		handleMode(args)
+	case "diff", "/diff":
+		handleDiff(args)
+	case "vs", "/vs":
+		handleVs(args)
+	case "benchmark", "/benchmark":
+		handleBenchmark(args)
+	case "refs", "/refs":
+		handleRefs(args)
+	case "explain-cmd", "/explain-cmd":
+		handleExplainCmd(args)
+	case "blame", "/blame":
+		handleBlame(args)
+	case "system", "/system":
+		handleSystem(args)
+	case "/env":
+		handleEnv(args)
+	case "env":
+		// Without the slash this is the shell's env
+		handleShellCommand(input)
+	case "rollback-session", "/rollback-session":
+		handleRollbackSession()
+	case "recent", "/recent":
+		handleRecent(args)
+	case "cleanup", "/cleanup":
+		handleCleanup(args)
+	case "tokens", "/tokens":
+		handleTokens(args)
+	case "compact", "/compact":
+		handleCompact()
+	case "build", "/build":
+		handleBuild(args)
+	case "fix", "/fix":
+		handleFix(args)
 	case "exit", "quit", "/exit", "/quit":
 		fmt.Println("👋 Goodbye!")
+		saveSessionOnExit()
 		os.Exit(0)
 	default:
 		// Treat as a general question
@@ -233,48 +774,123 @@ func showHelp() {
 	fmt.Println("  • Privacy-First Architecture - All processing on your infrastructure")
 	fmt.Println("\n📋 Available Commands:")
 	fmt.Println("  /explain <file>     - Explain a specific file or function")
+	fmt.Println("  /explain --deep <file> - Explain a file together with the project files it imports")
+	fmt.Println("  /explain --lines <file> - Explain a file with line numbers the AI can cite")
+	fmt.Println("  /summarize <path>   - Summarize a file, or each source file in a directory plus an overview")
+	fmt.Println("  /cache clear        - Delete cached explanations (/explain --no-cache skips the cache)")
 	fmt.Println("  /generate <what>    - Generate new code")
 	fmt.Println("  /refactor <file>    - Refactor existing code")
 	fmt.Println("  /test               - Run tests and analyze results")
+	fmt.Println("  /test explain       - Ask the AI to explain the last failing tests")
 	fmt.Println("  /search <query>     - Search through codebase semantically")
+	fmt.Println("  /search index       - Build or update the semantic index (reindex for a full rebuild)")
+	fmt.Println("  /search index-status - Show index size, age, and stale files (index-clean deletes it)")
+	fmt.Println("  /refs <symbol>      - Find where a symbol is defined and used (--summarize to ask the AI)")
+	fmt.Println("  /explain-cmd <command> - Explain what a shell command does, and what it could destroy, without running it")
+	fmt.Println("  /blame <file> [line] [--why] - Show the git history of a file or line; --why asks the AI why it was written that way")
+	fmt.Println("  /system show|set|reset - Show, replace (text or --file <path>), or restore the system prompt; --save keeps it")
 	fmt.Println("  /config             - Show locally installed Ollama models")
-	fmt.Println("  /sessions           - List and manage conversation sessions")
+	fmt.Println("  /mode <preset>      - Switch model/settings preset (e.g. fast, quality)")
+	fmt.Println("  /benchmark <prompt> - Run a prompt across models and compare speed")
+	fmt.Println("  /vs <modelA> <modelB> <question> - Ask two models the same question and compare their answers")
+	fmt.Println("  /sessions           - List and manage conversation sessions (--tag <tag> to filter)")
+	fmt.Println("  /sessions export-json <id> [file] - Export a session as chat-format JSON messages")
+	fmt.Println("  /tag <tag>          - Tag the current session (/tag -<tag> to remove)")
+	fmt.Println("  /branch             - Fork the conversation into a new session and switch to it")
 	fmt.Println("  /context            - Show current project context")
+	fmt.Println("  /context refresh    - Force a reload of the cached project context")
+	fmt.Println("  /context usage      - Show how much of the context window each part of the prompt uses")
 	fmt.Println("  /prompt <file>      - Add specific file to context")
 	fmt.Println("  /reason <problem>   - Start multi-turn reasoning for a problem")
+	fmt.Println("  /reason stop        - Cancel the current reasoning session")
 	fmt.Println("  /steps              - Show current reasoning steps")
 	fmt.Println("  /status             - Show current project status")
-	fmt.Println("  /read <file>        - View file contents")
+	fmt.Println("  /read <file>        - View file contents (--full to skip the line cap)")
 	fmt.Println("  /edit <file>        - Edit file with AI assistance")
-	fmt.Println("  /new <file>         - Create new file with AI assistance")
+	fmt.Println("  /edit-all <glob> <instruction> - Apply one instruction to every matching file (e.g. 'src/**/*.go')")
+	fmt.Println("  /template <name> <file> - Edit a file with a prompt template (/template list)")
+	fmt.Println("  /new <file>         - Create new file with AI assistance (--force to overwrite)")
+	fmt.Println("  /diff <a> <b>       - Show a unified diff between two files")
+	fmt.Println("  /diff session <id1> <id2> - Compare two conversation sessions")
+	fmt.Println("  /rollback-session   - Restore every file backed up this session")
+	fmt.Println("  /recent [open <n>]  - List files the AI created or edited this session, or view one")
+	fmt.Println("  /cleanup backups    - Remove the <file>.backup copies older versions left around the project")
+	fmt.Println("  /tokens [file|text] - Estimate the tokens of a file or text, or of the next prompt")
+	fmt.Println("  /compact           - Summarize older messages so they take less of the prompt")
+	fmt.Println("  /build [fix]       - Build the project and report errors; 'fix' asks the AI to fix the last build's errors")
+	fmt.Println("  /fix [error|log]   - Find the file and line an error points at and ask the AI for a fix as a diff; with no argument uses the last failed /build or /test")
+	fmt.Println("  /env KEY=VALUE      - Set an environment variable for shell commands (/env -KEY to unset)")
+	fmt.Println("  /debug prompt [text] - Show the full prompt for text (or the last query) without sending it")
+	fmt.Println("  <command> &         - Run explain or test in the background")
+	fmt.Println("  /jobs               - List background jobs (/jobs wait <id> to wait for one)")
+	fmt.Println("  /retry [--temp <t>] - Regenerate the last answer, optionally at a different temperature")
+	fmt.Println("  /paste              - Enter multi-line input, ended by a line with just '.'")
+	fmt.Println("  /with-output [-N] <question> - Ask about the last (or Nth most recent) shell command's output (also attached when you mention \"that error\")")
 	fmt.Println("  /help               - Show this help message")
 	fmt.Println("  /exit or /quit      - Exit the terminal")
-	fmt.Println("\n💡 You can also just type questions directly!")
+	fmt.Println("\n⌨️  Shortcuts: /e = /explain, /g = /generate, /r = /read (add your own under \"aliases\" in config.json)")
+	fmt.Println("\n💡 You can also just type questions directly! End a line with \\ to continue it on the next")
 	fmt.Println("   Example: 'How does authentication work in this project?'")
 }
 
+// deepExplainDepth is how many levels of imports /explain --deep follows
+const deepExplainDepth = 2
+
 func handleExplain(args []string) {
+	runExplain(os.Stdout, newMCPClient(), args)
+}
+
+// runExplain explains a file through client, writing the result to w
+func runExplain(w io.Writer, client *mcp.MCPClient, args []string) {
+	var opts mcp.CodeOptions
+	var remaining []string
+	for _, arg := range args {
+		switch arg {
+		case "--deep":
+			opts.Depth = deepExplainDepth
+			continue
+		case "--lines":
+			opts.LineNumbers = true
+			continue
+		case "--no-cache":
+			opts.NoCache = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	args = remaining
+
 	if len(args) == 0 {
-		fmt.Println("❌ Please specify a file or function to explain. Example: explain main.go")
+		fmt.Fprintln(w, "❌ Please specify a file or function to explain. Example: explain main.go or explain main.go:run")
 		return
 	}
 	target := args[0]
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-	result, err := client.ExplainCode(target)
+
+	// file.go:Name explains a single declaration
+	if file, symbol, ok := strings.Cut(target, ":"); ok && symbol != "" {
+		if _, err := os.Stat(file); err == nil {
+			target, opts.Symbol = file, symbol
+		}
+	}
+
+	result, err := client.ExplainCode(target, opts)
 	if err != nil {
-		fmt.Printf("❌ Error: %v\n", err)
+		fmt.Fprintf(w, "❌ Error: %v\n", err)
 		return
 	}
 
 	if !result.Success {
-		fmt.Printf("❌ Explanation failed: %s\n", result.Error)
+		fmt.Fprintf(w, "❌ Explanation failed: %s\n", result.Error)
 		return
 	}
 
-	fmt.Printf("\n🤖 Code Explanation:\n")
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println(result.Content)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	if result.Cached {
+		fmt.Fprintln(w, "⚡ Cached explanation (file unchanged); use --no-cache to regenerate")
+	}
+	fmt.Fprintf(w, "\n🤖 Code Explanation:\n")
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintln(w, result.Content)
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 }
 
 func handleGenerate(args []string) {
@@ -284,391 +900,3329 @@ func handleGenerate(args []string) {
 	}
 	what := strings.Join(args, " ")
 	fmt.Printf("⚡ Generating: %s\n", what)
-	ollama.TalkToOllama(fmt.Sprintf("Generate: %s", what), currentSessionID, historyManager)
+	ollama.TalkToOllamaFor(config.OpGenerate, fmt.Sprintf("Generate: %s", what), currentSessionID, historyManager)
 }
 
+// Report from the most recent /test run, used by /test explain
+// lastTestReport is guarded by lastTestReportMu since tests can run as a job
+var (
+	lastTestReport   *mcp.TestReport
+	lastTestReportMu sync.Mutex
+)
+
 func handleTest(args []string) {
-	fmt.Println("🧪 Running tests...")
-	ollama.TalkToOllama("Run tests and analyze the results", currentSessionID, historyManager)
+	if len(args) > 0 && args[0] == "explain" {
+		handleTestExplain()
+		return
+	}
+
+	runTests(os.Stdout, newMCPClient(), args)
 }
 
-func handleSearch(args []string) {
-	if len(args) == 0 {
-		fmt.Println("❌ Please provide a search query. Example: search 'authentication logic'")
+// runTests runs the project's tests through client, writing the report to w
+func runTests(w io.Writer, client *mcp.MCPClient, args []string) {
+	if len(args) > 0 && args[0] == "explain" {
+		fmt.Fprintln(w, "❌ '/test explain' can't run in the background")
 		return
 	}
-	query := strings.Join(args, " ")
-	fmt.Printf("🔍 Searching for: %s\n", query)
-	ollama.TalkToOllama(fmt.Sprintf("Search for: %s", query), currentSessionID, historyManager)
-}
 
-func handleSessions() {
-	fmt.Println("📝 Session Management:")
-	fmt.Printf("  Current Session: %s\n", currentSessionID)
-	fmt.Println("  💡 Sessions are automatically saved to ./sessions/")
-	fmt.Println("  💡 Each conversation maintains context across commands")
+	fmt.Fprintln(w, "🧪 Running tests...")
 
-	// List available sessions
-	sessions, err := historyManager.ListSessions()
+	result, err := client.RunTests(".")
 	if err != nil {
-		fmt.Printf("  ❌ Error listing sessions: %v\n", err)
+		fmt.Fprintf(w, "❌ Error: %v\n", err)
 		return
 	}
 
-	if len(sessions) > 0 {
-		fmt.Println("  📋 Available Sessions:")
-		for _, session := range sessions {
-			fmt.Printf("    • %s\n", session)
+	if result.Report == nil {
+		fmt.Fprintf(w, "❌ Tests could not be run: %s\n", result.Error)
+		if result.Stderr != "" {
+			fmt.Fprintf(w, "Error output: %s\n", result.Stderr)
 		}
-	} else {
-		fmt.Println("  📋 No previous sessions found")
+		return
 	}
-}
 
-func handleConfig(args []string) {
-	fmt.Println("🔧 Ollama Configuration:")
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	report := result.Report
+	lastTestReportMu.Lock()
+	lastTestReport = report
+	lastTestReportMu.Unlock()
 
-	// Show current model
-	fmt.Printf("🤖 Current Model: %s\n\n", ollama.GetCurrentModel())
+	fmt.Fprintf(w, "\n🧪 Test Results (%s):\n", report.Framework)
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Fprintf(w, "  ✅ Passed: %d   ❌ Failed: %d   ⚠️  Errors: %d   ⏭️  Skipped: %d\n",
+		report.Passed, report.Failed, report.Errors, report.Skipped)
 
-	// Handle model switching if requested
-	if len(args) >= 2 && args[0] == "models" {
-		modelName := args[1]
-		err := ollama.SetModel(modelName)
-		if err != nil {
-			fmt.Printf("❌ Error switching model: %v\n", err)
-			return
+	if len(report.FailingTests) > 0 {
+		fmt.Fprintln(w, "\n  Failing tests:")
+		for _, failure := range report.FailingTests {
+			fmt.Fprintf(w, "    • %s\n", failure.Name)
 		}
-		fmt.Printf("✅ Model switched to: %s\n\n", modelName)
 	}
+	fmt.Fprintln(w, "━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-	models, err := ollama.ListOllamaModels()
-	if err != nil {
-		fmt.Printf("❌ Error connecting to Ollama: %v\n", err)
-		fmt.Println("💡 Make sure Ollama is running: ollama serve")
-		return
+	if report.HasFailures() {
+		fmt.Fprintln(w, "💡 Use '/test explain' to have the AI analyze the failures")
+	} else if result.Success {
+		fmt.Fprintln(w, "🎉 All tests passed")
+	} else {
+		fmt.Fprintf(w, "⚠️  %s\n", result.Message)
 	}
+}
 
-	if len(models) == 0 {
-		fmt.Println("📋 No models installed")
-		fmt.Println("💡 Install a model: ollama pull codellama:13b")
+// handleTestExplain sends only the failing tests and their output to the model
+func handleTestExplain() {
+	lastTestReportMu.Lock()
+	lastTestReport := lastTestReport
+	lastTestReportMu.Unlock()
+
+	if lastTestReport == nil {
+		fmt.Println("❌ No test results yet. Run '/test' first.")
 		return
 	}
 
-	fmt.Printf("📋 Installed Models (%d):\n", len(models))
-	for i, model := range models {
-		// Mark current model with an arrow
-		currentIndicator := ""
-		if model.Name == ollama.GetCurrentModel() {
-			currentIndicator = " ← Current"
-		}
+	if !lastTestReport.HasFailures() {
+		fmt.Println("✅ The last test run had no failures to explain")
+		return
+	}
 
-		fmt.Printf("  %d. %s%s\n", i+1, model.Name, currentIndicator)
-		fmt.Printf("     Size: %.2f GB\n", float64(model.Size)/1024/1024/1024)
-		fmt.Printf("     Modified: %s\n", model.ModifiedAt.Format("2006-01-02 15:04:05"))
-		if model.Details.Family != "" {
-			fmt.Printf("     Family: %s\n", model.Details.Family)
-		}
-		if model.Details.ParameterSize != "" {
-			fmt.Printf("     Parameters: %s\n", model.Details.ParameterSize)
-		}
-		fmt.Println()
+	var failures []string
+	for _, failure := range lastTestReport.FailingTests {
+		failures = append(failures, fmt.Sprintf("=== %s ===\n%s", failure.Name, failure.Output))
 	}
 
-	fmt.Println("💡 Usage: /config models <modelname> to switch models")
+	prompt := fmt.Sprintf("The following %s tests are failing. Explain the likely cause of each failure and suggest fixes.\n\n%s",
+		lastTestReport.Framework, strings.Join(failures, "\n\n"))
+	ollama.TalkToOllama(prompt, currentSessionID, historyManager)
 }
 
-func handleStatus() {
-	fmt.Println("📊 Project Status:")
-	fmt.Printf("  • AI Model: Ollama (%s)\n", ollama.GetCurrentModel())
-	fmt.Println("  • Project: silent-code")
-	fmt.Println("  • Language: Go")
-	fmt.Println("  • Session: Active")
-	fmt.Printf("  • History: %s\n", currentSessionID)
-}
+// buildAfterEdit runs /build after every accepted AI edit
+var buildAfterEdit = false
 
-func handleContext() {
-	fmt.Println("📁 Project Context:")
-	fmt.Println("  • Current directory: .")
+// buildTimeout is the least time a build may take before it is killed;
+// a longer shell timeout takes precedence
+const buildTimeout = 5 * time.Minute
 
-	// Detect project type dynamically
-	projectType := detectProjectType(".")
-	fmt.Printf("  • Project type: %s\n", projectType)
+// maxBuildFixFiles caps how many files /build fix edits at once
+const maxBuildFixFiles = 5
 
-	// Get actual files in the directory
-	actualFiles := getActualFiles(".")
-	if len(actualFiles) > 0 {
-		fmt.Printf("  • Main files: %s\n", strings.Join(actualFiles, ", "))
+// lastBuildFailure is the output of the last /build, kept for /build fix
+// until a build succeeds
+var lastBuildFailure *shellOutput
+
+// buildErrorFileRegex matches the "file:line" at the start of a compiler
+// error, as printed by go, tsc, gcc, rustc, and most other compilers
+var buildErrorFileRegex = regexp.MustCompile(`([\w./\\-]+\.\w+)[:(](\d+)`)
+
+// detectBuildCommand returns the command that builds the project in dir
+func detectBuildCommand(dir string) (string, error) {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
 	}
 
-	// Get dependencies based on project type
-	dependencies := getDependencies(projectType)
+	switch {
+	case exists("go.mod"):
+		return "go build ./...", nil
+	case exists("package.json"):
+		if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+			var pkg struct {
+				Scripts map[string]string `json:"scripts"`
+			}
+			if json.Unmarshal(data, &pkg) == nil && pkg.Scripts["build"] != "" {
+				return "npm run build", nil
+			}
+		}
+		if exists("tsconfig.json") {
+			return "npx tsc --noEmit", nil
+		}
+	case exists("Cargo.toml"):
+		return "cargo build", nil
+	case exists("pom.xml"):
+		return "mvn -q compile", nil
+	case exists("build.gradle"), exists("build.gradle.kts"):
+		if exists("gradlew") {
+			return "./gradlew build -x test", nil
+		}
+		return "gradle build -x test", nil
+	case exists("Makefile"):
+		return "make", nil
+	}
+
+	return "", fmt.Errorf("could not detect how to build the project in %s", dir)
+}
+
+// handleBuild builds the project, or asks the AI to fix the last build's errors
+func handleBuild(args []string) {
+	if len(args) > 0 && args[0] == "fix" {
+		handleBuildFix()
+		return
+	}
+	if len(args) > 0 {
+		fmt.Println("💡 Usage: /build [fix]")
+		return
+	}
+	runBuild()
+}
+
+// buildIfEnabled builds the project after an edit when build_after_edit is on
+func buildIfEnabled() {
+	if buildAfterEdit {
+		runBuild()
+	}
+}
+
+// runBuild builds the project through the shell tool, reports any errors,
+// and reports whether the build succeeded
+func runBuild() bool {
+	command, err := detectBuildCommand(".")
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return false
+	}
+
+	fmt.Printf("🔨 Building: %s\n", command)
+	client := newMCPClient()
+	result, err := client.ExecuteShellWithOptions(command, mcp.ShellOptions{
+		Timeout: max(shellTimeout, buildTimeout),
+		Env:     sessionEnv,
+	})
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return false
+	}
+	output := shellOutput{Command: command, Output: result.Output + result.Stderr, ExitCode: result.ExitCode}
+	recordShellOutput(output)
+
+	if result.Success {
+		lastBuildFailure = nil
+		fmt.Println("✅ Build succeeded")
+		return true
+	}
+
+	lastBuildFailure = &output
+	if result.TimedOut {
+		fmt.Printf("⏱️  %s\n", result.Error)
+	} else {
+		fmt.Printf("❌ Build failed (exit code %d):\n", result.ExitCode)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println(strings.TrimSpace(output.Output))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("💡 Use '/build fix' to have the AI fix these errors")
+	return false
+}
+
+// buildErrorsByFile groups the lines of build output by the project file
+// they report an error in, in the order the files first appear
+func buildErrorsByFile(output string) ([]string, map[string][]string) {
+	var files []string
+	errorsByFile := map[string][]string{}
+	for _, line := range strings.Split(output, "\n") {
+		match := buildErrorFileRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		file := filepath.Clean(match[1])
+		if info, err := os.Stat(file); err != nil || info.IsDir() {
+			continue
+		}
+		if _, seen := errorsByFile[file]; !seen {
+			files = append(files, file)
+		}
+		errorsByFile[file] = append(errorsByFile[file], strings.TrimSpace(line))
+	}
+	return files, errorsByFile
+}
+
+// handleBuildFix sends each file with build errors to the model with its
+// errors, then builds again
+func handleBuildFix() {
+	if lastBuildFailure == nil {
+		fmt.Println("❌ No failed build to fix. Run '/build' first.")
+		return
+	}
+
+	files, errorsByFile := buildErrorsByFile(lastBuildFailure.Output)
+	if len(files) == 0 {
+		// Nothing points at a file, so ask about the output as a whole
+		prompt := fmt.Sprintf("The build command `%s` failed with the output below. Explain the cause and how to fix it.\n\n%s",
+			lastBuildFailure.Command, lastBuildFailure.contextItem().Content)
+		ollama.TalkToOllama(prompt, currentSessionID, historyManager)
+		return
+	}
+	if len(files) > maxBuildFixFiles {
+		fmt.Printf("⚠️  Errors are reported in %d files; fixing the first %d\n", len(files), maxBuildFixFiles)
+		files = files[:maxBuildFixFiles]
+	}
+
+	fmt.Printf("📋 Build errors in %d file(s):\n", len(files))
+	for _, file := range files {
+		fmt.Printf("  • %s (%d error(s))\n", file, len(errorsByFile[file]))
+	}
+	confirm, err := fs.ConfirmAction("\n❓ Ask the AI to fix them? (y/N): ")
+	if err != nil || !confirm {
+		fmt.Println("❌ Fix cancelled")
+		return
+	}
+
+	edited := 0
+	for i, file := range files {
+		fmt.Printf("🔧 [%d/%d] %s\n", i+1, len(files), file)
+		request := fmt.Sprintf("Fix these errors reported by `%s`, changing only what is needed to make the file compile:\n%s",
+			lastBuildFailure.Command, strings.Join(errorsByFile[file], "\n"))
+		if runMCPEdit(file, request) {
+			edited++
+		}
+	}
+
+	if edited == 0 {
+		fmt.Println("❌ No files were changed")
+		return
+	}
+	runBuild()
+}
+
+// fixContextLines is how many lines on each side of the error line /fix shows
+const fixContextLines = 5
+
+// maxFixLogLines caps how much of a log file /fix reads, from its end,
+// where the error that stopped a run usually is
+const maxFixLogLines = 200
+
+// handleFix finds the file and line an error points at, shows the code
+// there, and asks the AI for a fix that goes through the diff preview
+func handleFix(args []string) {
+	errorText, source, err := fixErrorText(args)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("💡 Usage: /fix <error text | log file>, or /fix alone after a failed /build or /test")
+		return
+	}
+
+	files, errorsByFile := buildErrorsByFile(errorText)
+	if len(files) == 0 {
+		fmt.Printf("⚠️  No project file and line found in %s; asking the AI about the error as a whole\n", source)
+		ollama.TalkToOllama(fmt.Sprintf("Explain the cause of this error and how to fix it:\n\n%s", errorText), currentSessionID, historyManager)
+		return
+	}
+	filePath := files[0]
+	errorLines := errorsByFile[filePath]
+	line := 0
+	if match := buildErrorFileRegex.FindStringSubmatch(errorLines[0]); match != nil {
+		line, _ = strconv.Atoi(match[2])
+	}
+	if len(files) > 1 {
+		fmt.Printf("⚠️  The error mentions %d files; fixing %s first\n", len(files), filePath)
+	}
+
+	if !confirmFreshContext(filePath) {
+		fmt.Println("❌ Fix aborted")
+		return
+	}
+	result, err := newMCPClient().ReadFile(filePath)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	if !result.Success {
+		fmt.Printf("❌ Read failed: %s\n", result.Error)
+		return
+	}
+	content := result.Content
+
+	fmt.Printf("📍 %s:%d (from %s)\n", filePath, line, source)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, errorLine := range errorLines {
+		fmt.Printf("  %s\n", errorLine)
+	}
+	if line > 0 {
+		fmt.Println()
+		lines := strings.Split(content, "\n")
+		for i := max(line-fixContextLines, 1); i <= min(line+fixContextLines, len(lines)); i++ {
+			marker := " "
+			if i == line {
+				marker = ">"
+			}
+			fmt.Printf("%s %4d | %s\n", marker, i, lines[i-1])
+		}
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	editRequest := fmt.Sprintf("Fix this error, changing only what is needed:\n%s", strings.Join(errorLines, "\n"))
+	language := agent.LanguageForFile(filePath)
+
+	// The diff is shown by the preview, so don't stream it as well
+	type talkFunc func(op string, userInput string, sessionID string, historyManager *history.HistoryManager, stop []string) (ollama.ChatResponse, error)
+	ask := func(talk talkFunc, prompt string, stop []string) (string, error) {
+		ollama.SetQuiet(true)
+		defer ollama.SetQuiet(false)
+		response, err := talk(config.OpEdit, prompt, "", nil, stop)
+		return response.Content, err
+	}
+
+	fmt.Println("🔧 Asking the AI for a fix...")
+	diffPrompt := fs.GetEditPrompt(filePath, language, content, editRequest)
+	diff, err := ask(ollama.TalkToOllamaWithStop, diffPrompt, fs.DiffStopSequences)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	err = fs.ApplyDiffToFileWithFallback(filePath, diff, func() (string, error) {
+		return ask(ollama.TalkToOllamaWithHigherLimit, diffPrompt, fs.DiffStopSequences)
+	}, func() (string, error) {
+		return ask(ollama.TalkToOllamaWithStop, fs.GetFullFilePrompt(filePath, language, content, editRequest), nil)
+	})
+	if err != nil {
+		fmt.Printf("❌ Fix failed: %v\n", err)
+		return
+	}
+
+	if updated, err := fs.ReadFile(filePath); err == nil && updated != content {
+		recordRecentFile(filePath, "edited")
+		buildIfEnabled()
+	}
+}
+
+// fixErrorText returns the error /fix works on and where it came from: the
+// arguments as pasted text, a log file named by the only argument, or with
+// no arguments the output of the last failed /build or /test
+func fixErrorText(args []string) (string, string, error) {
+	if len(args) == 0 {
+		if lastBuildFailure != nil {
+			return lastBuildFailure.Output, "the last /build", nil
+		}
+
+		lastTestReportMu.Lock()
+		report := lastTestReport
+		lastTestReportMu.Unlock()
+		if report != nil && report.HasFailures() {
+			var outputs []string
+			for _, failure := range report.FailingTests {
+				outputs = append(outputs, testFailurePaths(failure))
+			}
+			return strings.Join(outputs, "\n"), "the last /test", nil
+		}
+		return "", "", fmt.Errorf("no error given and no failed /build or /test to take one from")
+	}
+
+	if len(args) == 1 {
+		if info, err := os.Stat(args[0]); err == nil && !info.IsDir() {
+			content, err := fs.ReadFile(args[0])
+			if err != nil {
+				return "", "", fmt.Errorf("failed to read %s: %w", args[0], err)
+			}
+			lines := strings.Split(strings.TrimRight(content, "\n"), "\n")
+			if len(lines) > maxFixLogLines {
+				lines = lines[len(lines)-maxFixLogLines:]
+			}
+			return strings.Join(lines, "\n"), args[0], nil
+		}
+	}
+
+	return strings.Join(args, " "), "the pasted error", nil
+}
+
+// testFailurePaths returns a failure's output with the file names go test
+// prints relative to the test's package resolved against its directory
+func testFailurePaths(failure mcp.TestFailure) string {
+	if failure.Dir == "" {
+		return failure.Output
+	}
+	return buildErrorFileRegex.ReplaceAllStringFunc(failure.Output, func(location string) string {
+		match := buildErrorFileRegex.FindStringSubmatch(location)
+		if filepath.IsAbs(match[1]) {
+			return location
+		}
+		file := filepath.Join(failure.Dir, match[1])
+		if info, err := os.Stat(file); err != nil || info.IsDir() {
+			return location
+		}
+		return file + strings.TrimPrefix(location, match[1])
+	})
+}
+
+// maxRefsPromptTokens caps how many call sites /refs --summarize sends to the model
+const maxRefsPromptTokens = 3000
+
+// handleRefs lists every occurrence of a symbol grouped by file, and can ask
+// the model to summarize how it is used
+func handleRefs(args []string) {
+	summarize := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "--summarize" {
+			summarize = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) != 1 {
+		fmt.Println("💡 Usage: /refs <symbol> [--summarize]")
+		return
+	}
+	symbol := rest[0]
+
+	client := newMCPClient()
+	result, err := client.SearchCode(symbol, ".", true)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	if !result.Success {
+		fmt.Printf("❌ Search failed: %s\n", result.Error)
+		return
+	}
+	if len(result.Matches) == 0 {
+		fmt.Printf("🔍 No references to %s found\n", symbol)
+		return
+	}
+
+	// Group by file, keeping the order the search walked them in
+	var files []string
+	byFile := make(map[string][]mcp.SearchMatch)
+	for _, match := range result.Matches {
+		if _, seen := byFile[match.File]; !seen {
+			files = append(files, match.File)
+		}
+		byFile[match.File] = append(byFile[match.File], match)
+	}
+
+	fmt.Printf("\n🔍 References to %s (%s):\n", symbol, result.Message)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	var callSites []string
+	for _, file := range files {
+		fmt.Printf("\n📄 %s\n", file)
+		for _, match := range byFile[file] {
+			marker := "  "
+			if match.Kind == "definition" {
+				marker = "📌"
+			}
+			fmt.Printf("  %s %4d│ %s\n", marker, match.Line, match.Text)
+			callSites = append(callSites, fmt.Sprintf("%s:%d: %s", file, match.Line, match.Text))
+		}
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("📌 = definition")
+
+	if !summarize {
+		return
+	}
+
+	sites := agent.TruncateToTokens(strings.Join(callSites, "\n"), maxRefsPromptTokens)
+	prompt := fmt.Sprintf("Here are the places where `%s` appears in this project (file:line: code). Summarize what it is, where it is defined, and the common usage patterns at its call sites.\n\n%s",
+		symbol, sites)
+	ollama.TalkToOllama(prompt, currentSessionID, historyManager)
+}
+
+func handleSearch(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Please provide a search query. Example: search 'authentication logic'")
+		return
+	}
+
+	switch args[0] {
+	case "index":
+		handleBuildIndex(false)
+		return
+	case "reindex":
+		handleBuildIndex(true)
+		return
+	case "index-status":
+		handleIndexStatus()
+		return
+	case "index-clean":
+		handleIndexClean()
+		return
+	}
+
+	query := strings.Join(args, " ")
+	fmt.Printf("🔍 Searching for: %s\n", query)
+
+	if passages := semanticMatches(query); passages != "" {
+		prompt := fmt.Sprintf("Search for: %s\n\nThese are the most relevant passages from the project's semantic index. Point to where the answer lives and explain it.\n\n%s", query, passages)
+		ollama.TalkToOllama(prompt, currentSessionID, historyManager)
+		return
+	}
+
+	ollama.TalkToOllama(fmt.Sprintf("Search for: %s", query), currentSessionID, historyManager)
+}
+
+// semanticSearchResults is how many index chunks a search passes to the model
+const semanticSearchResults = 5
+
+// semanticMatches returns the index passages closest to query, or "" when
+// there is no usable index
+func semanticMatches(query string) string {
+	idx, err := index.Load(".")
+	if err != nil || idx.EmbedModel != ollama.GetEmbedModel() {
+		return ""
+	}
+
+	vectors, err := ollama.Embed([]string{query})
+	if err != nil {
+		fmt.Printf("⚠️  Semantic search unavailable: %v\n", err)
+		return ""
+	}
+
+	var passages []string
+	for _, result := range idx.Query(vectors[0], semanticSearchResults) {
+		content, err := fs.ReadFile(result.File)
+		if err != nil {
+			continue
+		}
+		lines := strings.Split(content, "\n")
+		end := min(result.EndLine, len(lines))
+		if result.StartLine > end {
+			continue
+		}
+		fmt.Printf("  📄 %s:%d-%d (score %.2f)\n", result.File, result.StartLine, end, result.Score)
+		passages = append(passages, fmt.Sprintf("=== %s (lines %d-%d) ===\n%s", result.File, result.StartLine, end, strings.Join(lines[result.StartLine-1:end], "\n")))
+	}
+
+	return strings.Join(passages, "\n\n")
+}
+
+// handleBuildIndex embeds the project into the semantic index, either from
+// scratch or only for files changed since the last build
+func handleBuildIndex(full bool) {
+	if err := ollama.EnsureEmbedModel(); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Println("💡 Choose another model with: /config embed-model <name>")
+		return
+	}
+
+	action := "Updating"
+	if full {
+		action = "Rebuilding"
+	}
+	fmt.Printf("🧭 %s semantic index with %s...\n", action, ollama.GetEmbedModel())
+
+	start := time.Now()
+	_, stats, err := index.Build(".", ollama.GetEmbedModel(), full, ollama.Embed, func(rel string) {
+		fmt.Printf("  • %s\n", rel)
+	})
+	if err != nil {
+		fmt.Printf("❌ Error building index: %v\n", err)
+		return
+	}
+
+	fmt.Printf("✅ Index saved to %s in %v: %d embedded, %d unchanged, %d removed\n",
+		index.Path, time.Since(start).Round(time.Millisecond), stats.Embedded, stats.Unchanged, stats.Removed)
+	if stats.Truncated {
+		fmt.Println(walkLimitNote)
+	}
+}
+
+// walkLimitNote explains results cut short by the max_depth/max_files limits
+const walkLimitNote = "⚠️  Results truncated: the project is larger than the max_depth/max_files limits in config.json"
+
+// handleIndexStatus shows the index size, age, and which files are out of date
+func handleIndexStatus() {
+	status, err := index.GetStatus(".")
+	if os.IsNotExist(err) {
+		fmt.Println("📋 No semantic index yet")
+		fmt.Println("💡 Build one with: /search index")
+		return
+	}
+	if err != nil {
+		fmt.Printf("❌ Error reading index: %v\n", err)
+		return
+	}
+
+	fmt.Println("🧭 Semantic Index:")
+	fmt.Printf("  • Files: %d\n", status.Files)
+	fmt.Printf("  • Size: %.1f KB\n", float64(status.Size)/1024)
+	fmt.Printf("  • Built: %s (%s ago)\n", status.BuiltAt.Format("2006-01-02 15:04:05"), time.Since(status.BuiltAt).Round(time.Second))
+	fmt.Printf("  • Embedding model: %s\n", status.Model)
+	if status.Model != ollama.GetEmbedModel() {
+		fmt.Printf("  ⚠️  Configured embedding model is %s; the next update rebuilds everything\n", ollama.GetEmbedModel())
+	}
+
+	printFileList := func(label string, files []string) {
+		if len(files) == 0 {
+			return
+		}
+		fmt.Printf("  • %s (%d):\n", label, len(files))
+		for _, file := range files {
+			fmt.Printf("      %s\n", file)
+		}
+	}
+	printFileList("Stale", status.Stale)
+	printFileList("Deleted", status.Deleted)
+	printFileList("Not indexed", status.Unindexed)
+
+	if len(status.Stale)+len(status.Deleted)+len(status.Unindexed) > 0 {
+		fmt.Println("💡 Update with: /search index")
+	} else {
+		fmt.Println("✅ Index is up to date")
+	}
+}
+
+// handleIndexClean deletes the semantic index
+func handleIndexClean() {
+	if err := index.Clean("."); err != nil {
+		fmt.Printf("❌ Error deleting index: %v\n", err)
+		return
+	}
+	fmt.Printf("🗑️  Deleted %s\n", index.Path)
+}
+
+func handleSessions(args []string) {
+	if len(args) > 0 && args[0] == "export-json" {
+		handleExportJSON(args[1:])
+		return
+	}
+
+	filterTag := ""
+	if len(args) >= 2 && args[0] == "--tag" {
+		filterTag = args[1]
+	}
+
+	fmt.Println("📝 Session Management:")
+	fmt.Printf("  Current Session: %s\n", currentSessionID)
+	fmt.Println("  💡 Sessions are automatically saved to ./sessions/")
+	fmt.Println("  💡 Each conversation maintains context across commands")
+
+	// List available sessions
+	var sessions []string
+	var err error
+	if filterTag != "" {
+		sessions, err = historyManager.ListSessionsByTag(filterTag)
+	} else {
+		sessions, err = historyManager.ListSessions()
+	}
+	if err != nil {
+		fmt.Printf("  ❌ Error listing sessions: %v\n", err)
+		return
+	}
+
+	if len(sessions) > 0 {
+		if filterTag != "" {
+			fmt.Printf("  📋 Sessions tagged %q:\n", filterTag)
+		} else {
+			fmt.Println("  📋 Available Sessions:")
+		}
+		for _, session := range sessions {
+			tags := ""
+			if conversation, err := historyManager.LoadSession(session); err == nil && len(conversation.Tags) > 0 {
+				tags = " 🏷️  " + strings.Join(conversation.Tags, ", ")
+			}
+			fmt.Printf("    • %s%s\n", session, tags)
+		}
+	} else if filterTag != "" {
+		fmt.Printf("  📋 No sessions tagged %q\n", filterTag)
+	} else {
+		fmt.Println("  📋 No previous sessions found")
+	}
+}
+
+// handleExportJSON writes a session's messages as a chat-format JSON array
+func handleExportJSON(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Println("💡 Usage: /sessions export-json <id> [file]")
+		return
+	}
+
+	sessionID := args[0]
+	outPath := fmt.Sprintf("session_%s.messages.json", sessionID)
+	if len(args) == 2 {
+		outPath = args[1]
+	}
+
+	if err := historyManager.ExportMessages(sessionID, outPath); err != nil {
+		fmt.Printf("❌ Error exporting session %s: %v\n", sessionID, err)
+		return
+	}
+	fmt.Printf("📤 Exported session %s to %s\n", sessionID, outPath)
+}
+
+// handleDebug dispatches debugging subcommands
+func handleDebug(args []string) {
+	if len(args) == 0 || args[0] != "prompt" {
+		fmt.Println("💡 Usage: /debug prompt [text]")
+		return
+	}
+	handleDebugPrompt(strings.Join(args[1:], " "))
+}
+
+// handleDebugPrompt prints every message BuildPrompt produces for an input,
+// with token estimates, without sending anything to the model
+func handleDebugPrompt(input string) {
+	label := "next query"
+	if input == "" {
+		input = ollama.LastUserInput()
+		label = "last query"
+		if input == "" {
+			fmt.Println("❌ No query sent yet. Use: /debug prompt <text>")
+			return
+		}
+	}
+
+	messages := ollama.PreviewPrompt(input, currentSessionID, historyManager)
+
+	fmt.Printf("\n🐛 Prompt for the %s (not sent):\n", label)
+	total := 0
+	for _, msg := range messages {
+		tokens := agent.EstimateTokens(msg.Content)
+		total += tokens
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Printf("📨 %s (~%d tokens)\n", msg.Role, tokens)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Println(msg.Content)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("🔢 Total: ~%d tokens (estimated)\n", total)
+
+	if numCtx := ollama.GetContextWindow(); numCtx > 0 && total > numCtx {
+		fmt.Printf("⚠️  The prompt exceeds the %d-token context window; the model will not see all of it\n", numCtx)
+	}
+}
+
+// handleBranch forks the current conversation into a new session and
+// continues there, leaving the original thread as it was
+func handleBranch() {
+	// Session IDs are second-resolution timestamps, so wait out a collision
+	branchID := fmt.Sprintf("session_%d", time.Now().Unix())
+	for branchID == currentSessionID {
+		time.Sleep(100 * time.Millisecond)
+		branchID = fmt.Sprintf("session_%d", time.Now().Unix())
+	}
+
+	branch, err := historyManager.BranchSession(currentSessionID, branchID)
+	if err != nil {
+		fmt.Printf("❌ Error branching session: %v\n", err)
+		return
+	}
+
+	parentID := currentSessionID
+	activateSession(branchID)
+
+	fmt.Printf("🌿 Branched %s → %s (%d messages copied)\n", parentID, branchID, len(branch.Messages))
+	fmt.Println("💡 The original session is unchanged; it's listed in /sessions")
+}
+
+// maxConcurrentJobs caps how many background jobs call the MCP server at once;
+// further jobs wait for a free slot
+const maxConcurrentJobs = 3
+
+// job is a command running in the background
+type job struct {
+	ID       int
+	Command  string
+	Started  time.Time
+	Finished time.Time
+	output   bytes.Buffer
+	done     chan struct{}
+}
+
+var (
+	jobs     []*job
+	jobsMu   sync.Mutex
+	jobSlots = make(chan struct{}, maxConcurrentJobs)
+)
+
+// backgroundCommands are the commands that can run as jobs. They write to the
+// job's buffer instead of the terminal and never prompt for input.
+var backgroundCommands = map[string]func(w io.Writer, client *mcp.MCPClient, args []string){
+	"explain": runExplain,
+	"test":    runTests,
+}
+
+// startJob runs a command in a goroutine and returns to the prompt immediately
+func startJob(parts []string) {
+	if len(parts) == 0 {
+		fmt.Println("❌ Usage: <command> &")
+		return
+	}
+
+	run, ok := backgroundCommands[strings.TrimPrefix(parts[0], "/")]
+	if !ok {
+		fmt.Println("❌ Only explain and test can run in the background")
+		return
+	}
+
+	jobsMu.Lock()
+	j := &job{
+		ID:      len(jobs) + 1,
+		Command: strings.Join(parts, " "),
+		Started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	jobs = append(jobs, j)
+	jobsMu.Unlock()
+
+	// The spinner would draw over the prompt
+	client := newMCPClient()
+	client.ShowProgress = false
+
+	go func() {
+		jobSlots <- struct{}{}
+		run(&j.output, client, parts[1:])
+		<-jobSlots
+
+		jobsMu.Lock()
+		j.Finished = time.Now()
+		jobsMu.Unlock()
+		close(j.done)
+
+		fmt.Printf("\n✅ Job %d finished: %s (use '/jobs wait %d' to see the output)\n", j.ID, j.Command, j.ID)
+	}()
+
+	fmt.Printf("🔄 Started job %d: %s\n", j.ID, j.Command)
+}
+
+// handleJobs lists background jobs, or waits for one and prints its output
+func handleJobs(args []string) {
+	if len(args) > 0 && args[0] == "wait" {
+		if len(args) < 2 {
+			fmt.Println("❌ Usage: /jobs wait <id>")
+			return
+		}
+		handleJobWait(args[1])
+		return
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+
+	if len(jobs) == 0 {
+		fmt.Println("📋 No background jobs. End a command with ' &' to run it in the background")
+		return
+	}
+
+	fmt.Println("\n📋 Background Jobs:")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for _, j := range jobs {
+		if j.Finished.IsZero() {
+			fmt.Printf("  %d  🔄 running  %6s  %s\n", j.ID, time.Since(j.Started).Round(time.Second), j.Command)
+		} else {
+			fmt.Printf("  %d  ✅ done     %6s  %s\n", j.ID, j.Finished.Sub(j.Started).Round(time.Second), j.Command)
+		}
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+}
+
+// handleJobWait blocks until a job finishes and prints what it wrote
+func handleJobWait(idArg string) {
+	id, err := strconv.Atoi(idArg)
+	if err != nil {
+		fmt.Printf("❌ Invalid job ID: %s\n", idArg)
+		return
+	}
+
+	jobsMu.Lock()
+	var j *job
+	if id >= 1 && id <= len(jobs) {
+		j = jobs[id-1]
+	}
+	jobsMu.Unlock()
+
+	if j == nil {
+		fmt.Printf("❌ No job with ID %d\n", id)
+		return
+	}
+
+	select {
+	case <-j.done:
+	default:
+		fmt.Printf("⏳ Waiting for job %d: %s\n", j.ID, j.Command)
+		<-j.done
+	}
+
+	fmt.Print(j.output.String())
+}
+
+// handleTag adds tags to the current session, removes them with a leading
+// "-", or lists them when called without arguments
+func handleTag(args []string) {
+	if len(args) == 0 {
+		conversation, err := historyManager.LoadSession(currentSessionID)
+		if err != nil || len(conversation.Tags) == 0 {
+			fmt.Println("🏷️  This session has no tags")
+			fmt.Println("💡 Usage: /tag <tag>, /tag -<tag> to remove")
+			return
+		}
+		fmt.Printf("🏷️  Tags: %s\n", strings.Join(conversation.Tags, ", "))
+		return
+	}
+
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			tag := strings.TrimPrefix(arg, "-")
+			if err := historyManager.RemoveTag(currentSessionID, tag); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				continue
+			}
+			fmt.Printf("✅ Removed tag %q\n", tag)
+			continue
+		}
+
+		if err := historyManager.AddTag(currentSessionID, arg); err != nil {
+			fmt.Printf("❌ Error tagging session: %v\n", err)
+			continue
+		}
+		fmt.Printf("✅ Tagged session %q\n", arg)
+	}
+}
+
+func handleConfig(args []string) {
+	// Settings that don't need the model listing
+	if len(args) > 0 {
+		switch args[0] {
+		case "max-tokens":
+			handleMaxTokens(args[1:])
+			return
+		case "auto-context":
+			handleAutoContext(args[1:])
+			return
+		case "verbose-context":
+			handleVerboseContext(args[1:])
+			return
+		case "shell-timeout":
+			handleShellTimeout(args[1:])
+			return
+		case "embed-model":
+			handleEmbedModel(args[1:])
+			return
+		case "temperature":
+			handleTemperature(args[1:])
+			return
+		case "priorities":
+			handleModelPriorities()
+			return
+		case "warmup":
+			handleWarmup(args[1:])
+			return
+		case "verbose":
+			handleVerbose(args[1:])
+			return
+		case "build-after-edit":
+			handleBuildAfterEdit(args[1:])
+			return
+		}
+	}
+
+	fmt.Println("🔧 Ollama Configuration:")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	// Show current model
+	fmt.Printf("🤖 Current Model: %s\n\n", ollama.GetCurrentModel())
+
+	// Let the user pick a model by index when no name is given
+	if len(args) == 1 && args[0] == "models" {
+		handleModelPicker()
+		return
+	}
+
+	// Handle model switching if requested
+	if len(args) >= 2 && args[0] == "models" {
+		err := ollama.SetModel(args[1])
+		var ambiguous *ollama.AmbiguousModelError
+		if errors.As(err, &ambiguous) {
+			if !pickAmbiguousModel(ambiguous) {
+				return
+			}
+		} else if err != nil {
+			fmt.Printf("❌ Error switching model: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Model switched to: %s\n\n", ollama.GetCurrentModel())
+	}
+
+	// Listing is the one place users look for newly pulled models
+	models, err := ollama.ListOllamaModelsWithOptions(ollama.ListOptions{ForceRefresh: true})
+	if err != nil {
+		fmt.Printf("❌ Error connecting to Ollama: %v\n", err)
+		fmt.Println("💡 Make sure Ollama is running: ollama serve")
+		return
+	}
+
+	if len(models) == 0 {
+		fmt.Println("📋 No models installed")
+		fmt.Println("💡 Install a model: ollama pull codellama:13b")
+		return
+	}
+
+	fmt.Printf("📋 Installed Models (%d):\n", len(models))
+	for i, model := range models {
+		// Mark current model with an arrow
+		currentIndicator := ""
+		if model.Name == ollama.GetCurrentModel() {
+			currentIndicator = " ← Current"
+		}
+
+		fmt.Printf("  %d. %s%s\n", i+1, model.Name, currentIndicator)
+		fmt.Printf("     Size: %.2f GB\n", float64(model.Size)/1024/1024/1024)
+		fmt.Printf("     Modified: %s\n", model.ModifiedAt.Format("2006-01-02 15:04:05"))
+		if model.Details.Family != "" {
+			fmt.Printf("     Family: %s\n", model.Details.Family)
+		}
+		if model.Details.ParameterSize != "" {
+			fmt.Printf("     Parameters: %s\n", model.Details.ParameterSize)
+		}
+		fmt.Println()
+	}
+
+	fmt.Println("💡 Usage: /config models <modelname> to switch models")
+	fmt.Println("💡 Usage: /config models to pick a model from a list")
+	fmt.Println("💡 Usage: /config max-tokens <n> to cap response length (-1 for unlimited)")
+	fmt.Println("💡 Usage: /config auto-context on|off to attach project files to project questions")
+	fmt.Println("💡 Usage: /config verbose-context on|off to show the size of each attached item")
+	fmt.Println("💡 Usage: /config shell-timeout <seconds> to let shell commands run longer (0 for the default)")
+	fmt.Println("💡 Usage: /config embed-model <name> to choose the model used for semantic search")
+	fmt.Println("💡 Usage: /config temperature <operation> <value> to change an operation's temperature")
+	fmt.Println("💡 Usage: /config priorities to see how installed models are ranked")
+	fmt.Println("💡 Usage: /config warmup on|off to load the model at startup (uses memory right away)")
+	fmt.Println("💡 Usage: /config verbose on|off to show each MCP tool call and its raw result")
+	fmt.Println("💡 Usage: /config build-after-edit on|off to build the project after every accepted edit")
+}
+
+// handleModelPriorities shows the installed models in the order automatic
+// model selection ranks them
+func handleModelPriorities() {
+	models, err := ollama.ListOllamaModels()
+	if err != nil {
+		fmt.Printf("❌ Error connecting to Ollama: %v\n", err)
+		fmt.Println("💡 Make sure Ollama is running: ollama serve")
+		return
+	}
+	if len(models) == 0 {
+		fmt.Println("📋 No models installed")
+		return
+	}
+
+	fmt.Println("🏆 Model ranking for coding tasks:")
+	for i, ranked := range ollama.RankModels(models) {
+		source := "fallback"
+		if ranked.Listed {
+			source = "priority list"
+		}
+		currentIndicator := ""
+		if ranked.Model.Name == ollama.GetCurrentModel() {
+			currentIndicator = " ← Current"
+		}
+		fmt.Printf("  %d. %-28s %4d  (%s)%s\n", i+1, ranked.Model.Name, ranked.Score, source, currentIndicator)
+	}
+	fmt.Println("💡 Set \"model_priorities\" or \"fallback_scoring\" in config.json to change the ranking")
+}
+
+// handleTemperature shows or sets the temperature used for each kind of operation
+func handleTemperature(args []string) {
+	if len(args) == 0 {
+		fmt.Println("🌡️  Temperature by operation:")
+		for _, op := range config.Operations {
+			if temperature, ok := config.OperationTemperature(op); ok {
+				fmt.Printf("  %-10s %.2f\n", op, temperature)
+			} else {
+				fmt.Printf("  %-10s model default\n", op)
+			}
+		}
+		if preset := ollama.GetActivePreset(); preset != "" {
+			if p, ok := config.Get().Presets[preset]; ok && p.Temperature != nil {
+				fmt.Printf("⚠️  Preset '%s' sets temperature %.2f, which overrides these\n", preset, *p.Temperature)
+			}
+		}
+		return
+	}
+
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: /config temperature <operation> <value>")
+		return
+	}
+
+	op := args[0]
+	if !slices.Contains(config.Operations, op) {
+		fmt.Printf("❌ Unknown operation '%s'. Choose one of: %s\n", op, strings.Join(config.Operations, ", "))
+		return
+	}
+
+	temperature, err := strconv.ParseFloat(args[1], 64)
+	if err != nil || temperature < 0 || temperature > 2 {
+		fmt.Printf("❌ Invalid temperature: %s (use a number from 0 to 2)\n", args[1])
+		return
+	}
+
+	config.SetOperationTemperature(op, temperature)
+	fmt.Printf("✅ %s temperature set to %.2f\n", op, temperature)
+}
+
+// handleMaxTokens shows or sets the cap on generated tokens per response
+func handleMaxTokens(args []string) {
+	if len(args) == 0 {
+		if maxTokens := ollama.GetMaxTokens(); maxTokens == -1 {
+			fmt.Println("🔢 Max output tokens: unlimited")
+		} else {
+			fmt.Printf("🔢 Max output tokens: %d\n", maxTokens)
+		}
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Printf("❌ Invalid number: %s\n", args[0])
+		return
+	}
+
+	if err := ollama.SetMaxTokens(n); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	if n == -1 {
+		fmt.Println("✅ Max output tokens: unlimited")
+	} else {
+		fmt.Printf("✅ Max output tokens set to %d\n", n)
+	}
+}
+
+// handleAutoContext shows or toggles project context injection for general questions
+func handleAutoContext(args []string) {
+	if len(args) == 0 {
+		state := "off"
+		if autoContext {
+			state = "on"
+		}
+		fmt.Printf("📁 Auto-context: %s\n", state)
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		autoContext = true
+		fmt.Println("✅ Auto-context enabled: project questions include the directory listing and key files")
+	case "off":
+		autoContext = false
+		fmt.Println("✅ Auto-context disabled: questions are sent without project files")
+	default:
+		fmt.Println("💡 Usage: /config auto-context on|off")
+	}
+}
+
+// warmup loads the model at startup so the first question doesn't wait for it
+var warmup = false
+
+// startWarmup loads the current model in the background while the user
+// reads the banner; failures only mean the first question loads it instead
+func startWarmup() {
+	model := ollama.GetCurrentModel()
+	fmt.Printf("🔥 Loading %s in the background...\n", model)
+	go ollama.WarmUp(model)
+}
+
+// handleWarmup shows or toggles loading the model at startup
+func handleWarmup(args []string) {
+	if len(args) == 0 {
+		state := "off"
+		if warmup {
+			state = "on"
+		}
+		fmt.Printf("🔥 Warm-up: %s\n", state)
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		warmup = true
+		fmt.Println("✅ Warm-up enabled for this run; set \"warmup\": true in config.json to keep it")
+		startWarmup()
+	case "off":
+		warmup = false
+		fmt.Println("✅ Warm-up disabled: the model loads on the first question")
+	default:
+		fmt.Println("💡 Usage: /config warmup on|off")
+	}
+}
+
+// handleVerbose shows or toggles printing of MCP tool calls
+func handleVerbose(args []string) {
+	if len(args) == 0 {
+		state := "off"
+		if mcp.Verbose() {
+			state = "on"
+		}
+		fmt.Printf("🔌 Verbose tool calls: %s\n", state)
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		mcp.SetVerbose(true)
+		fmt.Println("✅ Verbose enabled: each tool call is shown with its arguments and raw result")
+	case "off":
+		mcp.SetVerbose(false)
+		fmt.Println("✅ Verbose disabled")
+	default:
+		fmt.Println("💡 Usage: /config verbose on|off")
+	}
+}
+
+// handleBuildAfterEdit shows or toggles building the project after edits
+func handleBuildAfterEdit(args []string) {
+	if len(args) == 0 {
+		state := "off"
+		if buildAfterEdit {
+			state = "on"
+		}
+		fmt.Printf("🔨 Build after edit: %s\n", state)
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		buildAfterEdit = true
+		fmt.Println("✅ The project is built after every accepted edit; set \"build_after_edit\": true in config.json to keep it")
+	case "off":
+		buildAfterEdit = false
+		fmt.Println("✅ Build after edit disabled")
+	default:
+		fmt.Println("💡 Usage: /config build-after-edit on|off")
+	}
+}
+
+// handleVerboseContext shows or toggles sizes in the included-context line
+func handleVerboseContext(args []string) {
+	if len(args) == 0 {
+		state := "off"
+		if verboseContext {
+			state = "on"
+		}
+		fmt.Printf("📎 Verbose context: %s\n", state)
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		verboseContext = true
+		fmt.Println("✅ Verbose context enabled: attached items are listed with their size")
+	case "off":
+		verboseContext = false
+		fmt.Println("✅ Verbose context disabled: attached items are listed by name")
+	default:
+		fmt.Println("💡 Usage: /config verbose-context on|off")
+	}
+}
+
+// contextItem is something attached to a question, named for the user
+type contextItem struct {
+	Name    string
+	Content string
+}
+
+// printIncludedContext shows which items were attached to a question, so it
+// is clear what the answer was based on
+func printIncludedContext(items []contextItem) {
+	var names []string
+	total := 0
+	for _, item := range items {
+		tokens := agent.EstimateTokens(item.Content)
+		total += tokens
+		if verboseContext {
+			names = append(names, fmt.Sprintf("%s (%d lines, ~%d tokens)", item.Name, strings.Count(item.Content, "\n")+1, tokens))
+		} else {
+			names = append(names, item.Name)
+		}
+	}
+
+	fmt.Printf("📎 included context: %s\n", strings.Join(names, ", "))
+	if verboseContext {
+		fmt.Printf("   ~%d tokens in total\n", total)
+	}
+}
+
+// handleShellTimeout shows or sets how long shell commands may run
+func handleShellTimeout(args []string) {
+	if len(args) == 0 {
+		if shellTimeout == 0 {
+			fmt.Println("⏱️  Shell timeout: server default (30s)")
+		} else {
+			fmt.Printf("⏱️  Shell timeout: %v\n", shellTimeout)
+		}
+		return
+	}
+
+	seconds, err := strconv.Atoi(args[0])
+	if err != nil || seconds < 0 {
+		fmt.Printf("❌ Invalid number of seconds: %s\n", args[0])
+		return
+	}
+
+	shellTimeout = time.Duration(seconds) * time.Second
+	if seconds == 0 {
+		fmt.Println("✅ Shell timeout reset to the server default")
+	} else {
+		fmt.Printf("✅ Shell timeout set to %v\n", shellTimeout)
+	}
+}
+
+// handleEmbedModel shows or sets the model used for embeddings
+func handleEmbedModel(args []string) {
+	if len(args) == 0 {
+		fmt.Printf("🧭 Embedding model: %s\n", ollama.GetEmbedModel())
+		return
+	}
+
+	if err := ollama.SetEmbedModel(args[0]); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		fmt.Printf("💡 Install it with: ollama pull %s\n", args[0])
+		return
+	}
+	fmt.Printf("✅ Embedding model set to %s\n", ollama.GetEmbedModel())
+	if !ollama.IsEmbedModel(args[0]) {
+		fmt.Println("⚠️  This doesn't look like an embedding model; search quality may suffer")
+	}
+}
+
+// handleModelPicker lists installed models by index and switches to the chosen one
+func handleModelPicker() {
+	models, err := ollama.ListOllamaModels()
+	if err != nil {
+		fmt.Printf("❌ Error connecting to Ollama: %v\n", err)
+		fmt.Println("💡 Make sure Ollama is running: ollama serve")
+		return
+	}
+
+	if len(models) == 0 {
+		fmt.Println("📋 No models installed")
+		fmt.Println("💡 Install a model: ollama pull codellama:13b")
+		return
+	}
+
+	recommended := ollama.RecommendedModel(models)
+
+	fmt.Println("📋 Select a model (⭐ = recommended):")
+	for i, model := range models {
+		marker := "  "
+		if model.Name == recommended.Name {
+			marker = "⭐"
+		}
+		currentIndicator := ""
+		if model.Name == ollama.GetCurrentModel() {
+			currentIndicator = " ← Current"
+		}
+		fmt.Printf("  %s %d. %s (%.2f GB)%s\n", marker, i+1, model.Name, float64(model.Size)/1024/1024/1024, currentIndicator)
+	}
+
+	choice, err := fs.PromptUser(fmt.Sprintf("\n❓ Enter a number (1-%d), or press Enter to cancel: ", len(models)))
+	if err != nil {
+		fmt.Printf("❌ Error reading selection: %v\n", err)
+		return
+	}
+	if choice == "" {
+		fmt.Println("❌ Model not changed")
+		return
+	}
+
+	index, err := strconv.Atoi(choice)
+	if err != nil || index < 1 || index > len(models) {
+		fmt.Printf("❌ Invalid selection: %s\n", choice)
+		return
+	}
+
+	modelName := models[index-1].Name
+	if err := ollama.SetModel(modelName); err != nil {
+		fmt.Printf("❌ Error switching model: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Model switched to: %s\n", modelName)
+}
+
+// pickAmbiguousModel asks which of the models matching a partial name to
+// switch to. It reports whether the model was switched.
+func pickAmbiguousModel(ambiguous *ollama.AmbiguousModelError) bool {
+	fmt.Printf("❓ '%s' matches several models:\n", ambiguous.Name)
+	for i, name := range ambiguous.Matches {
+		fmt.Printf("  %d. %s\n", i+1, name)
+	}
+
+	choice, err := fs.PromptUser(fmt.Sprintf("\n❓ Enter a number (1-%d), or press Enter to cancel: ", len(ambiguous.Matches)))
+	if err != nil || choice == "" {
+		fmt.Println("❌ Model not changed")
+		return false
+	}
+	index, err := strconv.Atoi(choice)
+	if err != nil || index < 1 || index > len(ambiguous.Matches) {
+		fmt.Printf("❌ Invalid selection: %s\n", choice)
+		return false
+	}
+
+	if err := ollama.SetModel(ambiguous.Matches[index-1]); err != nil {
+		fmt.Printf("❌ Error switching model: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// benchmarkResult holds the outcome of running the benchmark prompt on one model
+type benchmarkResult struct {
+	Model     string
+	Latency   time.Duration
+	TokensSec float64
+	Response  string
+	Err       error
+}
+
+// handleBenchmark runs the same prompt on several models and compares them
+func handleBenchmark(args []string) {
+	if len(args) == 0 {
+		fmt.Println("💡 Usage: /benchmark <prompt>")
+		return
+	}
+	prompt := strings.Join(args, " ")
+
+	models, err := ollama.ListOllamaModels()
+	if err != nil {
+		fmt.Printf("❌ Error connecting to Ollama: %v\n", err)
+		fmt.Println("💡 Make sure Ollama is running: ollama serve")
+		return
+	}
+	if len(models) == 0 {
+		fmt.Println("📋 No models installed")
+		return
+	}
+
+	fmt.Println("📋 Installed models:")
+	for i, model := range models {
+		fmt.Printf("  %d. %s\n", i+1, model.Name)
+	}
+
+	choice, err := fs.PromptUser("\n❓ Models to benchmark (e.g. 1,3), or press Enter for all: ")
+	if err != nil {
+		fmt.Printf("❌ Error reading selection: %v\n", err)
+		return
+	}
+
+	var selected []string
+	if choice == "" {
+		for _, model := range models {
+			selected = append(selected, model.Name)
+		}
+	} else {
+		for _, part := range strings.Split(choice, ",") {
+			index, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || index < 1 || index > len(models) {
+				fmt.Printf("❌ Invalid selection: %s\n", strings.TrimSpace(part))
+				return
+			}
+			selected = append(selected, models[index-1].Name)
+		}
+	}
+
+	// Restore the user's model no matter how the runs go
+	originalModel := ollama.GetCurrentModel()
+	defer func() {
+		if originalModel != "" {
+			ollama.SetModel(originalModel)
+		}
+	}()
+
+	var results []benchmarkResult
+	for _, modelName := range selected {
+		fmt.Printf("\n🔧 Benchmarking %s\n", modelName)
+		result := benchmarkResult{Model: modelName}
+
+		if err := ollama.SetModel(modelName); err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+
+		// Runs don't share a session so earlier answers can't influence later ones
+		start := time.Now()
+		response, err := ollama.TalkToOllamaWithResponse(prompt, "", nil)
+		result.Latency = time.Since(start)
+		result.Err = err
+		result.Response = response.Content
+		result.TokensSec = response.TokensPerSecond()
+		results = append(results, result)
+	}
+
+	fmt.Println("\n📊 Benchmark Results:")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("%-28s %10s %10s  %s\n", "MODEL", "LATENCY", "TOKENS/S", "RESPONSE")
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%-28s %10s %10s  ❌ %v\n", result.Model, "-", "-", result.Err)
+			continue
+		}
+		fmt.Printf("%-28s %9.1fs %10.1f  %s\n", result.Model, result.Latency.Seconds(), result.TokensSec, truncateForTable(result.Response, 60))
+	}
+}
+
+// handleVs asks two models the same question and shows their answers one
+// after the other, then restores the model that was in use
+func handleVs(args []string) {
+	if len(args) < 3 {
+		fmt.Println("💡 Usage: /vs <modelA> <modelB> <question>")
+		fmt.Println("💡 Example: /vs qwen2.5-coder:1.5b qwen2.5-coder:7b how do I reverse a slice in Go?")
+		return
+	}
+	contenders := args[:2]
+	question := strings.Join(args[2:], " ")
+
+	// Restore the user's model no matter how the runs go
+	originalModel := ollama.GetCurrentModel()
+	defer func() {
+		if originalModel != "" {
+			ollama.SetModel(originalModel)
+		}
+	}()
+
+	labels := []string{"🅰️ ", "🅱️ "}
+	for i, modelName := range contenders {
+		fmt.Printf("\n%s %s\n", labels[i], modelName)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		if err := ollama.SetModel(modelName); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+
+		// Neither answer goes into the session, so the second can't see the first
+		start := time.Now()
+		response, err := ollama.TalkToOllamaWithResponse(question, "", nil)
+		if err != nil {
+			fmt.Printf("\n❌ Error: %v\n", err)
+			continue
+		}
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Printf("⏱️  %.1fs, %d tokens, %.1f tokens/s\n", time.Since(start).Seconds(), response.EvalCount, response.TokensPerSecond())
+	}
+}
+
+// truncateForTable flattens text onto one line and shortens it to max runes
+func truncateForTable(text string, max int) string {
+	flat := strings.Join(strings.Fields(text), " ")
+	runes := []rune(flat)
+	if len(runes) <= max {
+		return flat
+	}
+	return string(runes[:max-3]) + "..."
+}
+
+// handleMode applies a named preset, or lists the available presets
+func handleMode(args []string) {
+	if len(args) == 0 {
+		presets := config.Get().Presets
+		names := make([]string, 0, len(presets))
+		for name := range presets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Println("🎛️  Available presets:")
+		for _, name := range names {
+			preset := presets[name]
+			currentIndicator := ""
+			if name == ollama.GetActivePreset() {
+				currentIndicator = " ← Current"
+			}
+
+			model := preset.Model
+			if model == "" && preset.PreferSmall {
+				model = "smallest installed"
+			} else if model == "" {
+				model = "best installed"
+			}
+
+			details := fmt.Sprintf("model: %s", model)
+			if preset.Temperature != nil {
+				details += fmt.Sprintf(", temperature: %.2f", *preset.Temperature)
+			}
+			if preset.NumPredict != 0 {
+				details += fmt.Sprintf(", num_predict: %d", preset.NumPredict)
+			}
+			if preset.NumCtx != 0 {
+				details += fmt.Sprintf(", num_ctx: %d", preset.NumCtx)
+			}
+			fmt.Printf("  • %s (%s)%s\n", name, details, currentIndicator)
+		}
+		fmt.Println("💡 Usage: /mode <preset>")
+		return
+	}
+
+	if err := ollama.ApplyPreset(args[0]); err != nil {
+		fmt.Printf("❌ Error applying preset: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Preset '%s' active (model: %s)\n", args[0], ollama.GetCurrentModel())
+}
+
+func handleStatus() {
+	fmt.Println("📊 Project Status:")
+	fmt.Printf("  • AI Model: Ollama (%s)\n", ollama.GetCurrentModel())
+	if preset := ollama.GetActivePreset(); preset != "" {
+		fmt.Printf("  • Preset: %s\n", preset)
+	}
+	if wd, err := os.Getwd(); err == nil {
+		fmt.Printf("  • Project: %s\n", filepath.Base(wd))
+	}
+	fmt.Printf("  • Project type: %s\n", detectProjectType("."))
+	fmt.Println("  • Session: Active")
+	fmt.Printf("  • History: %s\n", currentSessionID)
+	if historyManager.MemoryOnly() {
+		fmt.Printf("  • History storage: memory only (%s is not writable)\n", historyManager.HistoryDir)
+	} else {
+		fmt.Printf("  • History storage: %s\n", historyManager.HistoryDir)
+	}
+	fmt.Printf("  • Prompt size: ~%d tokens (estimated)\n", ollama.EstimatePromptTokens("", currentSessionID, historyManager))
+
+	client := newMCPClient()
+	info, err := client.ServerInfo()
+	if err != nil {
+		fmt.Printf("  • MCP server: unavailable (%v)\n", err)
+		return
+	}
+	fmt.Printf("  • MCP server: v%s on %s, up %s\n", info.Version, info.Address, time.Duration(info.UptimeSeconds)*time.Second)
+	fmt.Printf("  • MCP model: %s\n", info.Model)
+	fmt.Printf("  • MCP tools: %s\n", strings.Join(info.Tools, ", "))
+	if info.SafeMode {
+		fmt.Println("  • Safe mode: on")
+	}
+}
+
+// contextWarnPercent is the share of the context window at which /context
+// usage suggests trimming the prompt
+const contextWarnPercent = 80
+
+// handleContextUsage shows the estimated token cost of each part of the next
+// prompt against the model's context window
+func handleContextUsage() {
+	parts := ollama.ContextUsage(currentSessionID, historyManager)
+	window := ollama.EffectiveContextWindow()
+
+	total := 0
+	largest := parts[0]
+	for _, part := range parts {
+		total += part.Tokens
+		if part.Tokens > largest.Tokens {
+			largest = part
+		}
+	}
+
+	fmt.Printf("📏 Context usage for the next question (window: %d tokens):\n", window)
+	for _, part := range parts {
+		fmt.Printf("  • %-22s ~%6d tokens  %5.1f%%\n", part.Name, part.Tokens, 100*float64(part.Tokens)/float64(window))
+	}
+	percent := 100 * float64(total) / float64(window)
+	fmt.Printf("  %-24s ~%6d tokens  %5.1f%%\n", "Total", total, percent)
+
+	if percent < contextWarnPercent {
+		return
+	}
+	if total > window {
+		fmt.Println("⚠️  The prompt no longer fits; the model will not see all of it")
+	} else {
+		fmt.Println("⚠️  The prompt is close to the context window")
+	}
+	switch largest.Name {
+	case "Conversation history":
+		fmt.Println("💡 Most of it is conversation history; use /compact to summarize the older messages")
+	case "Code context", "Project info":
+		fmt.Println("💡 Most of it is project files; list fewer in \"main_files\" in config.json")
+	}
+	fmt.Println("💡 Or use a preset with a larger num_ctx, e.g. /mode quality")
+}
+
+// handleCompact summarizes the older messages of the current session; the
+// summary replaces them in later prompts
+func handleCompact() {
+	fmt.Print("🗜️  Summarizing older messages: ")
+	count, err := ollama.CompactSession(currentSessionID, historyManager)
+	if err != nil {
+		fmt.Printf("\n❌ Error: %v\n", err)
+		if count > 0 {
+			fmt.Printf("💡 The first %d messages were summarized; run /compact again for the rest\n", count)
+		}
+		return
+	}
+	if count == 0 {
+		fmt.Println("\n💡 Nothing to compact: the conversation is already short")
+		return
+	}
+	fmt.Printf("\n✅ Summarized %d messages; they stay in the session but only the summary is sent from now on\n", count)
+}
+
+// handleTokens estimates what a file, a piece of text, or (with no
+// argument) the next prompt would cost in tokens
+func handleTokens(args []string) {
+	window := ollama.EffectiveContextWindow()
+
+	if len(args) == 0 {
+		total := 0
+		for _, part := range ollama.ContextUsage(currentSessionID, historyManager) {
+			total += part.Tokens
+		}
+		fmt.Printf("🔢 Next prompt, before your question: ~%d tokens (%.1f%% of the %d-token window)\n",
+			total, 100*float64(total)/float64(window), window)
+		fmt.Println("💡 Use '/context usage' for a breakdown")
+		return
+	}
+
+	label, text := "Text", strings.Join(args, " ")
+	if len(args) == 1 && fs.FileExists(args[0]) {
+		content, err := fs.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("❌ Error reading %s: %v\n", args[0], err)
+			return
+		}
+		label, text = args[0], content
+	}
+
+	tokens := agent.EstimateTokens(text)
+	fmt.Printf("🔢 %s: ~%d tokens (%.1f%% of the %d-token window)\n", label, tokens, 100*float64(tokens)/float64(window), window)
+	if tokens > window {
+		fmt.Println("⚠️  This alone is larger than the context window; the model would not see all of it")
+	}
+}
+
+func handleContext(args []string) {
+	if len(args) > 0 && args[0] == "usage" {
+		handleContextUsage()
+		return
+	}
+	if len(args) > 0 && args[0] == "refresh" {
+		// Drop the cached context and rebuild it from disk
+		agent.InvalidateProjectContext(".")
+		promptBuilder := agent.NewPromptBuilder()
+		if err := promptBuilder.LoadProjectContext("."); err != nil {
+			fmt.Printf("❌ Error reloading project context: %v\n", err)
+			return
+		}
+		fmt.Println("🔄 Project context reloaded from disk")
+		return
+	}
+
+	fmt.Println("📁 Project Context:")
+	fmt.Println("  • Current directory: .")
+
+	// Detect project type dynamically
+	projectType := detectProjectType(".")
+	fmt.Printf("  • Project type: %s\n", projectType)
+	if file := agent.InstructionsFile("."); file != "" {
+		fmt.Printf("  • Instructions: %s (included with every question)\n", file)
+	}
+
+	// Get actual files in the directory
+	actualFiles := getActualFiles(".")
+	if len(actualFiles) > 0 {
+		fmt.Printf("  • Main files: %s\n", strings.Join(actualFiles, ", "))
+	}
+
+	// Get dependencies based on project type
+	dependencies := getDependencies(projectType)
 	if len(dependencies) > 0 {
 		fmt.Printf("  • Dependencies: %s\n", strings.Join(dependencies, ", "))
 	} else {
-		fmt.Println("  • Dependencies: None")
+		fmt.Println("  • Dependencies: None")
+	}
+
+	fmt.Println("  💡 Context is automatically loaded for better AI responses")
+}
+
+func handlePrompt(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Please specify a file to add to context. Example: prompt main.go")
+		return
+	}
+	file := args[0]
+	fmt.Printf("📄 Adding %s to context...\n", file)
+	fmt.Println("💡 This file will be included in AI responses for better context")
+}
+
+func handleReason(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Please specify a problem to reason about. Example: reason 'How to optimize this code?'")
+		return
+	}
+	if len(args) == 1 && args[0] == "stop" {
+		handleReasonStop()
+		return
+	}
+	problem := strings.Join(args, " ")
+	fmt.Printf("🧠 Starting multi-turn reasoning for: %s\n", problem)
+	fmt.Println("💡 Use 'steps' to see reasoning progress")
+
+	// Start reasoning session
+	ollama.InitializeReasoning()
+	ollama.StartReasoning(currentSessionID, problem)
+
+	// Add initial step
+	ollama.AddReasoningStep(currentSessionID, "Analyzing the problem", "Breaking down the problem into manageable steps")
+
+	fmt.Println("🔄 Reasoning session started. The AI will work through this step by step.")
+}
+
+// handleReasonStop cancels the current reasoning session
+func handleReasonStop() {
+	reasoning, err := ollama.AbortReasoning(currentSessionID)
+	if err != nil {
+		fmt.Println("❌ No active reasoning session to stop")
+		return
+	}
+
+	completed := 0
+	for _, step := range reasoning.Steps {
+		if step.Status == "completed" {
+			completed++
+		}
+	}
+	fmt.Printf("🛑 Stopped reasoning on: %s (%d of %d steps completed)\n", reasoning.Problem, completed, len(reasoning.Steps))
+}
+
+func handleSteps() {
+	fmt.Println("🧠 Current Reasoning Steps:")
+
+	// Get reasoning summary
+	summary, err := ollama.GetReasoningSummary(currentSessionID)
+	if err != nil {
+		fmt.Printf("❌ No active reasoning session. Use 'reason <problem>' to start one.\n")
+		return
+	}
+
+	fmt.Println(summary)
+}
+
+func handleGeneralQuestion(input string) {
+	var output *shellOutput
+	if referencesShellOutput(input) {
+		if last, ok := shellOutputAt(1); ok {
+			output = &last
+		}
+	}
+	askQuestion(input, output)
+}
+
+// askQuestion sends a question with whatever context it calls for, with the
+// given shell command output, if any, attached. The context goes to the
+// model with this question only; history keeps the question as typed.
+func askQuestion(input string, output *shellOutput) {
+	attachment := ""
+	var included []contextItem
+	if output != nil {
+		item := output.contextItem()
+		attachment += fmt.Sprintf("\n\nOutput of the command `%s` (exit code %d):\n%s", output.Command, output.ExitCode, item.Content)
+		included = append(included, item)
+	}
+
+	// Pure knowledge questions don't need anything from the project
+	if !autoContext || !shouldReadFiles(input) {
+		if len(included) > 0 {
+			printIncludedContext(included)
+		}
+		ollama.TalkToOllamaWithAttachment(input, attachment, currentSessionID, historyManager)
+		return
+	}
+
+	// Use MCP to analyze the project and answer the question
+	client := newMCPClient()
+
+	// First, get the current directory contents
+	result, err := client.ExecuteShell("ls -la")
+	if err != nil {
+		fmt.Printf("❌ Error getting directory contents: %v\n", err)
+		// Fallback to regular AI response
+		ollama.TalkToOllamaWithAttachment(input, attachment, currentSessionID, historyManager)
+		return
+	}
+
+	if !result.Success {
+		fmt.Printf("❌ Failed to get directory contents: %s\n", result.Error)
+		// Fallback to regular AI response
+		ollama.TalkToOllamaWithAttachment(input, attachment, currentSessionID, historyManager)
+		return
+	}
+
+	// Attach the directory contents
+	attachment += fmt.Sprintf("\n\nCurrent directory contents:\n%s", result.Output)
+	included = append(included, contextItem{Name: "ls output", Content: result.Output})
+
+	files := readRelevantFiles()
+	if len(files) > 0 {
+		var fileContents []string
+		for _, file := range files {
+			fileContents = append(fileContents, fmt.Sprintf("=== %s ===\n%s", file.Name, file.Content))
+		}
+		attachment += "\n\nFile contents:\n" + strings.Join(fileContents, "\n\n")
+		included = append(included, files...)
+	}
+
+	printIncludedContext(included)
+
+	// Send the question with its context to AI
+	ollama.TalkToOllamaWithAttachment(input, attachment, currentSessionID, historyManager)
+}
+
+// shouldReadFiles determines if the question is about this project rather
+// than general knowledge, so project files are worth attaching
+func shouldReadFiles(question string) bool {
+	questionLower := strings.ToLower(question)
+
+	// Phrases that point at the code in the current directory
+	projectKeywords := []string{
+		"this project", "the project", "my project", "our project",
+		"this code", "my code", "our code", "the code here",
+		"codebase", "this repo", "the repo", "repository",
+		"this app", "my app", "this program", "this script",
+		"this file", "these files", "folder", "directory",
+		"in here", "this package", "this module",
+	}
+
+	for _, keyword := range projectKeywords {
+		if strings.Contains(questionLower, keyword) {
+			return true
+		}
+	}
+
+	// Mentioning a file that exists here is a strong signal too
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if len(name) > 3 && !strings.HasPrefix(name, ".") && strings.Contains(questionLower, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readRelevantFiles reads the most relevant files in the directory
+func readRelevantFiles() []contextItem {
+	client := newMCPClient()
+
+	// Get list of files
+	result, err := client.ExecuteShell("ls -1")
+	if err != nil || !result.Success {
+		return nil
+	}
+
+	files := strings.Split(strings.TrimSpace(result.Output), "\n")
+	var included []contextItem
+	matcher := ignore.Load(".")
+
+	// Read up to 3 most relevant files
+	fileCount := 0
+	for _, file := range files {
+		if fileCount >= 3 {
+			break
+		}
+
+		// Skip directories and non-source files
+		if strings.Contains(file, "/") ||
+			strings.HasPrefix(file, ".") ||
+			file == "silent-code" ||
+			file == "go.sum" ||
+			file == "LICENSE" ||
+			matcher.Match(file, false) {
+			continue
+		}
+
+		// Try to read the file
+		readResult, err := client.ReadFile(file)
+		if err == nil && readResult.Success {
+			included = append(included, contextItem{Name: file, Content: readResult.Content})
+			fileCount++
+		} else if err == nil && strings.HasPrefix(readResult.Error, fs.ErrBinaryFile.Error()) {
+			fmt.Printf("⏭️  %s\n", readResult.Error)
+		}
+	}
+
+	return included
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&autoApplyFlag, "yes", "y", false, "Apply changes without confirmation prompts (backups are still created)")
+	rootCmd.Flags().StringVarP(&promptFlag, "prompt", "p", "", "Answer a single prompt and exit")
+	rootCmd.Flags().BoolVar(&jsonFlag, "json", false, "With --prompt, print the result as JSON without decoration")
+	rootCmd.PersistentFlags().StringVar(&historyDirFlag, "history-dir", "", "Directory to save conversation sessions in (default ~/.silent-code/sessions)")
+	rootCmd.PersistentFlags().BoolVar(&verboseFlag, "verbose", false, "Show each MCP tool call with its arguments and raw result")
+	rootCmd.PersistentFlags().BoolVar(&noStreamFlag, "no-stream", false, "Print responses whole instead of streaming them (automatic when output is not a terminal)")
+
+	// Add command handlers
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "explain [file]",
+		Short: "Explain a file or function",
+		Long:  "Get detailed explanations of code files or specific functions",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				fmt.Println("❌ Please specify a file or function")
+				return
+			}
+			handleExplain(args)
+		},
+	})
+
+	rootCmd.AddCommand(&cobra.Command{
+		Use:   "generate [what]",
+		Short: "Generate new code",
+		Long:  "Generate new code based on your specifications",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				fmt.Println("❌ Please specify what to generate")
+				return
+			}
+			handleGenerate(args)
+		},
+	})
+
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run only the MCP server",
+		Long:  "Run the MCP server in the foreground without the interactive CLI, for editors and other MCP clients",
+		// Replaces the root's hook, which starts a second server in the background
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+		Run: func(cmd *cobra.Command, args []string) {
+			runServe()
+		},
+	}
+	serveCmd.Flags().IntVar(&servePort, "port", mcp.DefaultServerPort, "Port to listen on")
+	serveCmd.Flags().StringVar(&serveBind, "bind", mcp.DefaultServerBind, "Host or IP to listen on (0.0.0.0 for every interface)")
+	serveCmd.Flags().StringVar(&serveModel, "model", mcp.DefaultServerModel, "Ollama model the server's tools generate with")
+	rootCmd.AddCommand(serveCmd)
+}
+
+// MCP Handler functions
+func handleMCPCreate(args []string) {
+	// --force replaces an existing file (after backing it up)
+	force := false
+	var remaining []string
+	for _, arg := range args {
+		if arg == "--force" {
+			force = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	args = remaining
+
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: mcp-create [--force] <file> <requirements>")
+		return
+	}
+
+	filePath := args[0]
+	requirements := strings.Join(args[1:], " ")
+
+	client := newMCPClient()
+
+	// Show the code as it is generated, then confirm before writing it
+	var onChunk func(string)
+	if ollama.StreamingEnabled() {
+		fmt.Printf("🛠️  Generating %s...\n", filePath)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		onChunk = func(chunk string) { fmt.Print(chunk) }
+	}
+	result, err := client.PreviewFile(filePath, requirements, force, onChunk)
+	if onChunk != nil {
+		fmt.Println("\n━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	}
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+
+	if !result.Success {
+		fmt.Printf("❌ Creation failed: %s\n", result.Error)
+		if result.Error == "File already exists" {
+			fmt.Println("💡 Use '/new --force <file> <requirements>' to overwrite it")
+		}
+		return
+	}
+
+	if result.NoCode {
+		fmt.Println("⚠️  No code found in the response, even after retrying with a stricter prompt")
+		fmt.Println("💡 Below is the raw output; save it as is, or cancel and run /new again")
+	}
+
+	// Continuations and retries aren't streamed, so show the assembled file
+	if onChunk == nil || result.Continuations > 0 || result.NoCode {
+		if result.Continuations > 0 {
+			fmt.Printf("✂️  The output was cut off and continued %d time(s)\n", result.Continuations)
+		}
+		fmt.Printf("📄 Generated %s:\n", filePath)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Println(result.Content)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	}
+
+	// A response with several code blocks can be saved as one of them or
+	// as separate files
+	files := []fs.GeneratedFile{{Path: filePath, Content: result.Content}}
+	if len(result.Blocks) > 1 {
+		if files, err = fs.ChooseCodeBlocks(filePath, result.Blocks); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if len(files) == 0 {
+			fmt.Println("❌ File not created")
+			return
+		}
+	}
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.Path
+	}
+	confirm, err := fs.ConfirmAction(fmt.Sprintf("❓ Write %s? (y/N): ", strings.Join(paths, ", ")))
+	if err != nil || !confirm {
+		fmt.Println("❌ File not created")
+		return
+	}
+
+	for _, file := range files {
+		// Only the requested path may already exist; other blocks go to new files
+		written, err := client.WriteGeneratedFile(file.Path, file.Content, force && file.Path == filePath)
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if !written.Success {
+			fmt.Printf("❌ Creation failed for %s: %s\n", file.Path, written.Error)
+			return
+		}
+
+		recordRecentFile(file.Path, "created")
+		fmt.Printf("✅ %s\n", written.Message)
+	}
+}
+
+// handleCleanup removes leftovers from older versions after confirmation
+func handleCleanup(args []string) {
+	if len(args) != 1 || args[0] != "backups" {
+		fmt.Println("💡 Usage: /cleanup backups")
+		return
+	}
+
+	backups, size, err := fs.FindStrayBackups(".")
+	if err != nil {
+		fmt.Printf("❌ Error searching for backups: %v\n", err)
+		return
+	}
+	if len(backups) == 0 {
+		fmt.Println("✨ No stray .backup files found")
+		return
+	}
+
+	// List every file, since all of them will be deleted
+	fmt.Printf("🧹 Found %d stray .backup file(s) (%.1f KB) next to the files they back up:\n", len(backups), float64(size)/1024)
+	for _, backup := range backups {
+		fmt.Printf("  • %s\n", backup)
+	}
+	fmt.Printf("💡 Backups made now are kept in %s and aren't touched\n", fs.BackupDir)
+
+	confirm, err := fs.ConfirmAction(fmt.Sprintf("\n❓ Delete all %d? (y/N): ", len(backups)))
+	if err != nil || !confirm {
+		fmt.Println("❌ Cleanup cancelled")
+		return
+	}
+
+	removed := 0
+	var freed int64
+	for _, backup := range backups {
+		info, err := os.Stat(backup)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(backup); err != nil {
+			fmt.Printf("❌ Could not remove %s: %v\n", backup, err)
+			continue
+		}
+		removed++
+		freed += info.Size()
+	}
+	fmt.Printf("✅ Removed %d file(s), freed %.1f KB\n", removed, float64(freed)/1024)
+}
+
+// recordRecentFile notes that the AI changed a file, moving it to the end
+// of the recent list if it was already there
+func recordRecentFile(filePath, action string) {
+	recentFiles = slices.DeleteFunc(recentFiles, func(f touchedFile) bool {
+		return f.Path == filePath
+	})
+	recentFiles = append(recentFiles, touchedFile{Path: filePath, Action: action, At: time.Now()})
+}
+
+// handleRecent lists the files the AI created or edited this session, or
+// opens one of them with "/recent open <n>"
+func handleRecent(args []string) {
+	if len(recentFiles) == 0 {
+		fmt.Println("📭 No files have been created or edited this session")
+		return
+	}
+
+	if len(args) > 0 {
+		if args[0] != "open" || len(args) != 2 {
+			fmt.Println("❌ Usage: /recent [open <n>]")
+			return
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(recentFiles) {
+			fmt.Printf("❌ Pick a file between 1 and %d\n", len(recentFiles))
+			return
+		}
+		handleMCPRead([]string{recentFiles[len(recentFiles)-n].Path})
+		return
+	}
+
+	fmt.Println("🕘 Recently changed files:")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	for i := len(recentFiles) - 1; i >= 0; i-- {
+		file := recentFiles[i]
+		fmt.Printf("  %d. %s  %-7s  %s\n", len(recentFiles)-i, file.At.Format("15:04:05"), file.Action, file.Path)
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println("💡 Use '/recent open <n>' to view a file")
+}
+
+func handleMCPEdit(args []string) {
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: mcp-edit <file> <edit_request>")
+		return
+	}
+
+	if runMCPEdit(args[0], strings.Join(args[1:], " ")) {
+		buildIfEnabled()
+	}
+}
+
+// runMCPEdit asks the MCP server to apply editRequest to filePath and
+// reports whether the file was changed
+func runMCPEdit(filePath, editRequest string) bool {
+	if !confirmFreshContext(filePath) {
+		fmt.Println("❌ Edit aborted")
+		return false
+	}
+
+	client := newMCPClient()
+	result, err := client.EditFile(filePath, editRequest)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return false
+	}
+
+	if !result.Success {
+		fmt.Printf("❌ Edit failed: %s\n", result.Error)
+		return false
+	}
+
+	if result.NoChange {
+		fmt.Printf("⚠️  The model returned %s unchanged, so nothing was edited\n", filePath)
+		fmt.Println("💡 Try rephrasing the request more specifically")
+		return false
+	}
+
+	recordRecentFile(filePath, "edited")
+	fmt.Printf("✅ edited %s (+%d -%d)\n", filepath.Base(filePath), result.LinesAdded, result.LinesRemoved)
+	return true
+}
+
+// maxEditAllFiles caps how many files one /edit-all may change
+const maxEditAllFiles = 50
+
+// handleEditAll applies one edit instruction to every file matching a glob.
+// All files are backed up first so the whole batch can be rolled back if
+// any edit fails.
+func handleEditAll(args []string) {
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: /edit-all <glob> <instruction>")
+		fmt.Println("💡 Example: /edit-all 'internal/**/*.go' rename getUser to fetchUser")
+		return
+	}
+
+	pattern := strings.Trim(args[0], `"'`)
+	instruction := strings.Join(args[1:], " ")
+
+	files, truncated := expandEditGlob(pattern)
+	if truncated {
+		fmt.Println(walkLimitNote)
+	}
+	if len(files) == 0 {
+		fmt.Printf("❌ No files match %s\n", pattern)
+		return
+	}
+	if len(files) > maxEditAllFiles {
+		fmt.Printf("❌ %s matches %d files; narrow the pattern to at most %d\n", pattern, len(files), maxEditAllFiles)
+		return
+	}
+
+	fmt.Printf("📋 %d file(s) match %s:\n", len(files), pattern)
+	for _, file := range files {
+		fmt.Printf("  • %s\n", file)
+	}
+	confirm, err := fs.ConfirmAction(fmt.Sprintf("\n❓ Apply \"%s\" to all of them? (y/N): ", instruction))
+	if err != nil || !confirm {
+		fmt.Println("❌ Edit cancelled")
+		return
+	}
+
+	backups, err := fs.BackupFiles(files)
+	if err != nil {
+		fmt.Printf("❌ %v; no files were changed\n", err)
+		return
+	}
+
+	client := newMCPClient()
+	var summary []string
+	changed, unchanged, failed := 0, 0, 0
+	for i, file := range files {
+		fmt.Printf("✏️  [%d/%d] %s\n", i+1, len(files), file)
+		result, err := client.EditFile(file, instruction)
+		switch {
+		case err != nil:
+			failed++
+			summary = append(summary, fmt.Sprintf("  ❌ %s: %v", file, err))
+		case !result.Success:
+			failed++
+			summary = append(summary, fmt.Sprintf("  ❌ %s: %s", file, result.Error))
+		case result.NoChange:
+			unchanged++
+			summary = append(summary, fmt.Sprintf("  ➖ %s (unchanged)", file))
+		default:
+			changed++
+			recordRecentFile(file, "edited")
+			summary = append(summary, fmt.Sprintf("  ✅ %s (+%d -%d)", file, result.LinesAdded, result.LinesRemoved))
+		}
+	}
+
+	fmt.Println("\n📊 Edit Summary:")
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println(strings.Join(summary, "\n"))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Printf("  %d changed, %d unchanged, %d failed\n", changed, unchanged, failed)
+
+	if failed == 0 {
+		fmt.Println("💡 Use '/rollback-session' to undo these changes")
+		if changed > 0 {
+			buildIfEnabled()
+		}
+		return
+	}
+
+	confirm, err = fs.ConfirmAction(fmt.Sprintf("\n❓ %d edit(s) failed. Roll back all %d files? (y/N): ", failed, len(files)))
+	if err != nil || !confirm {
+		fmt.Println("💡 Kept the successful edits; '/rollback-session' can still undo them")
+		return
+	}
+
+	if notRestored := fs.RestoreBackups(backups); len(notRestored) > 0 {
+		fmt.Printf("❌ Could not restore: %s\n", strings.Join(notRestored, ", "))
+		return
+	}
+	fmt.Printf("↩️  Rolled back all %d files\n", len(files))
+}
+
+// expandEditGlob returns the project files matching pattern, where "**"
+// matches any number of directories. Hidden, ignored, and dependency
+// directories are skipped. It reports whether a walk limit cut the search short.
+func expandEditGlob(pattern string) ([]string, bool) {
+	pattern = filepath.ToSlash(filepath.Clean(pattern))
+	matcher := ignore.Load(".")
+	var files []string
+
+	truncated, _ := matcher.Walk(".", ignore.ConfiguredLimits(), func(path, rel string, d os.DirEntry) error {
+		if matchGlob(strings.Split(pattern, "/"), strings.Split(rel, "/")) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	return files, truncated
+}
+
+// matchGlob matches path segments against pattern segments, letting a "**"
+// segment stand for zero or more path segments
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := filepath.Match(pattern[0], segments[0]); !matched {
+		return false
+	}
+	return matchGlob(pattern[1:], segments[1:])
+}
+
+// Limits that keep /summarize on a directory from running for hours
+const (
+	maxSummarizeFiles    = 40
+	maxSummarizeFileSize = 100 * 1024
+)
+
+// summarizeQuestion is asked of every file /summarize covers. It must stay
+// fixed so analyze_code's cache can answer for unchanged files.
+const summarizeQuestion = "Summarize this file in 2-4 sentences: what it is for and its key functions or types. Be concise and do not repeat the code."
+
+// summarizableExtensions are the source files /summarize covers in a directory
+var summarizableExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".scala": true, ".rs": true, ".c": true, ".h": true,
+	".cpp": true, ".cc": true, ".hpp": true, ".cs": true, ".php": true, ".rb": true,
+	".swift": true, ".m": true, ".dart": true, ".ex": true, ".exs": true, ".lua": true,
+	".sh": true, ".vue": true, ".svelte": true,
+}
+
+// handleSystem shows, overrides, or restores the system prompt. With --save
+// the change is also written to the user config so it outlasts the session.
+func handleSystem(args []string) {
+	save := false
+	var remaining []string
+	for _, arg := range args {
+		if arg == "--save" {
+			save = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	args = remaining
+
+	if len(args) == 0 {
+		fmt.Println("💡 Usage: /system show | /system set <text> | /system set --file <path> | /system reset  (add --save to keep the change)")
+		return
+	}
+
+	switch args[0] {
+	case "show":
+		prompt, overridden := agent.SystemPromptOverride()
+		if !overridden {
+			prompt = agent.DefaultSystemPrompt()
+		}
+		label := "default"
+		if overridden {
+			label = "custom"
+		}
+		fmt.Printf("🧠 System prompt (%s):\n", label)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Println(prompt)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	case "set":
+		var prompt string
+		switch {
+		case len(args) == 3 && args[1] == "--file":
+			content, err := fs.ReadFile(args[2])
+			if err != nil {
+				fmt.Printf("❌ Error reading %s: %v\n", args[2], err)
+				return
+			}
+			prompt = strings.TrimSpace(content)
+		case len(args) >= 2:
+			prompt = strings.Join(args[1:], " ")
+		}
+		if prompt == "" {
+			fmt.Println("❌ Please provide the new prompt. Example: /system set You are a security reviewer")
+			return
+		}
+		agent.SetSystemPrompt(prompt)
+		fmt.Printf("✅ System prompt set (%d characters)\n", len(prompt))
+		if save {
+			saveSystemPrompt(prompt)
+		}
+
+	case "reset":
+		agent.SetSystemPrompt("")
+		fmt.Println("✅ System prompt restored to the default")
+		if save {
+			saveSystemPrompt("")
+		} else if config.Get().SystemPrompt != "" {
+			fmt.Println("💡 Your config still sets a system prompt; use '/system reset --save' to remove it")
+		}
+
+	default:
+		fmt.Println("💡 Usage: /system show | /system set <text> | /system set --file <path> | /system reset  (add --save to keep the change)")
+	}
+}
+
+// saveSystemPrompt writes the system prompt to the user config, or removes
+// it when prompt is empty
+func saveSystemPrompt(prompt string) {
+	var value interface{}
+	if prompt != "" {
+		value = prompt
+	}
+	if err := config.SaveUserSetting("system_prompt", value); err != nil {
+		fmt.Printf("❌ Could not save the system prompt: %v\n", err)
+		return
+	}
+	config.SetSystemPrompt(prompt)
+	fmt.Printf("💾 Saved to %s\n", config.UserConfigPath())
+}
+
+// explainCmdPrompt asks the model to break down a shell command and call out
+// anything it could destroy
+const explainCmdPrompt = `Explain what the following shell command does. Do NOT suggest running it.
+
+COMMAND: %s
+
+1. Describe each part of the command (programs, flags, arguments, pipes, redirections) in plain terms.
+2. Summarize the overall effect in one or two sentences.
+3. Under a "⚠️ Risks" heading, list anything destructive or irreversible: deleting or overwriting files, changing permissions or ownership, running code downloaded from the internet, using sudo, killing processes, rewriting git history, or touching disks and system settings. If there are none, say "No destructive effects."`
+
+// handleExplainCmd asks the AI what a shell command does without running it
+func handleExplainCmd(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Please provide a command. Example: /explain-cmd find . -name '*.tmp' -delete")
+		return
+	}
+
+	command := strings.Join(args, " ")
+	fmt.Printf("🔎 Explaining (not running): %s\n", command)
+	ollama.TalkToOllama(fmt.Sprintf(explainCmdPrompt, command), currentSessionID, historyManager)
+}
+
+// blameContextLines is how many lines around the requested one /blame shows
+const blameContextLines = 5
+
+// blameLogCount is how many commits /blame lists for a whole file
+const blameLogCount = 10
+
+// blameWhyPrompt asks the model to explain code from its git history
+const blameWhyPrompt = `Using the git history below, explain why this code was written the way it is. Point to the commits that introduced or shaped it, and say when the history doesn't explain something rather than guessing.
+
+FILE: %s
+
+CODE:
+%s
+
+COMMITS:
+%s`
+
+// shellQuote quotes an argument for the shell
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// handleBlame shows who last changed a file, or the lines around one line of
+// it, and the commits involved, optionally asking the AI why the code looks
+// the way it does
+func handleBlame(args []string) {
+	why := false
+	var remaining []string
+	for _, arg := range args {
+		if arg == "--why" {
+			why = true
+			continue
+		}
+		remaining = append(remaining, arg)
+	}
+	if len(remaining) == 0 || len(remaining) > 2 {
+		fmt.Println("❌ Usage: /blame <file> [line] [--why]")
+		return
+	}
+
+	filePath := remaining[0]
+	line := 0
+	if len(remaining) == 2 {
+		n, err := strconv.Atoi(remaining[1])
+		if err != nil || n < 1 {
+			fmt.Printf("❌ Invalid line number: %s\n", remaining[1])
+			return
+		}
+		line = n
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+
+	client := newMCPClient()
+	client.ShowProgress = false
+	git := func(args string) (*mcp.ToolResult, error) {
+		return client.ExecuteShell("git " + args)
+	}
+	quoted := shellQuote(filePath)
+
+	if result, err := git("rev-parse --is-inside-work-tree"); err != nil || !result.Success {
+		fmt.Println("❌ Not a git repository, so there is no history to show")
+		return
+	}
+	if result, err := git("ls-files --error-unmatch -- " + quoted); err != nil || !result.Success {
+		fmt.Printf("📝 %s hasn't been committed yet, so it has no history\n", filePath)
+		return
+	}
+	if result, err := git("diff --quiet HEAD -- " + quoted); err == nil && result.ExitCode == 1 {
+		fmt.Printf("⚠️  %s has uncommitted changes; those lines show as \"Not Committed Yet\"\n", filePath)
+	}
+
+	var code, commits string
+	if line > 0 {
+		start := max(1, line-blameContextLines)
+		result, err := git(fmt.Sprintf("blame -L %d,%d -- %s", start, line+blameContextLines, quoted))
+		if err != nil || !result.Success {
+			// The range can run past the end of the file
+			result, err = git(fmt.Sprintf("blame -L %d,+1 -- %s", line, quoted))
+		}
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if !result.Success {
+			fmt.Printf("❌ git blame failed: %s\n", strings.TrimSpace(result.Stderr))
+			return
+		}
+		code = result.Output
+
+		fmt.Printf("\n🔍 Blame for %s around line %d:\n", filePath, line)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Print(code)
+
+		// The commits that last touched these lines, skipping uncommitted ones
+		var hashes []string
+		for _, blameLine := range strings.Split(code, "\n") {
+			hash, _, _ := strings.Cut(blameLine, " ")
+			hash = strings.TrimPrefix(hash, "^")
+			if hash == "" || strings.Trim(hash, "0") == "" || slices.Contains(hashes, hash) {
+				continue
+			}
+			hashes = append(hashes, hash)
+		}
+		if len(hashes) > 0 {
+			result, err = git("show --no-patch --date=short --format='%h %ad %an%n%B' " + strings.Join(hashes, " "))
+			if err == nil && result.Success {
+				commits = result.Output
+			}
+		}
+	} else {
+		result, err := git(fmt.Sprintf("log -n %d --date=short --format='%%h %%ad %%an%%n%%B' -- %s", blameLogCount, quoted))
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if !result.Success {
+			fmt.Printf("❌ git log failed: %s\n", strings.TrimSpace(result.Stderr))
+			return
+		}
+		commits = result.Output
+		if content, err := fs.ReadFile(filePath); err == nil {
+			code = content
+		}
+	}
+
+	fmt.Printf("\n📜 Commits for %s:\n", filePath)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	if strings.TrimSpace(commits) == "" {
+		fmt.Println("No committed history for these lines")
+	} else {
+		fmt.Println(strings.TrimSpace(commits))
+	}
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	if !why {
+		fmt.Println("💡 Add --why to ask the AI why the code was written this way")
+		return
+	}
+	if strings.TrimSpace(commits) == "" {
+		fmt.Println("❌ There's no committed history to explain")
+		return
+	}
+	ollama.TalkToOllama(fmt.Sprintf(blameWhyPrompt, filePath, code, commits), currentSessionID, historyManager)
+}
+
+// handleSummarize summarizes a file, or every source file in a directory
+// followed by an overview of the whole directory
+func handleSummarize(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Usage: /summarize <file or directory>")
+		return
+	}
+	path := args[0]
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+
+	client := newMCPClient()
+
+	if !info.IsDir() {
+		result, err := client.AnalyzeCode(path, summarizeQuestion, mcp.CodeOptions{})
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		if !result.Success {
+			fmt.Printf("❌ Summary failed: %s\n", result.Error)
+			return
+		}
+		fmt.Printf("\n📄 %s:\n", path)
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Println(strings.TrimSpace(result.Content))
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		return
+	}
+
+	files, truncated := summarizableFiles(path)
+	if len(files) == 0 {
+		fmt.Printf("❌ No source files to summarize in %s\n", path)
+		return
+	}
+	if truncated {
+		fmt.Printf("⚠️  Results truncated: only summarizing %d source files\n", len(files))
+	}
+
+	// Each file goes through analyze_code, whose cache skips unchanged files
+	var summaries []string
+	cachedCount := 0
+	client.ShowProgress = false
+	for i, file := range files {
+		fmt.Printf("📄 [%d/%d] %s", i+1, len(files), file)
+		result, err := client.AnalyzeCode(file, summarizeQuestion, mcp.CodeOptions{})
+		switch {
+		case err != nil:
+			fmt.Printf(" ❌ %v\n", err)
+			continue
+		case !result.Success:
+			fmt.Printf(" ❌ %s\n", result.Error)
+			continue
+		case result.Cached:
+			cachedCount++
+			fmt.Println(" ⚡ cached")
+		default:
+			fmt.Println(" ✅")
+		}
+		summaries = append(summaries, fmt.Sprintf("=== %s ===\n%s", file, strings.TrimSpace(result.Content)))
+	}
+
+	if len(summaries) == 0 {
+		fmt.Println("❌ No files could be summarized")
+		return
+	}
+
+	fmt.Printf("\n📚 File Summaries (%d files, %d from cache):\n", len(summaries), cachedCount)
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	fmt.Println(strings.Join(summaries, "\n\n"))
+	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+
+	fmt.Printf("\n🧭 Overview of %s:\n", path)
+	prompt := fmt.Sprintf("Here are summaries of the source files in %s. Write a short overview of what this directory does as a whole, how its files fit together, and where a newcomer should start reading.\n\n%s",
+		path, strings.Join(summaries, "\n\n"))
+	ollama.TalkToOllama(prompt, currentSessionID, historyManager)
+}
+
+// summarizableFiles lists the source files under root that /summarize
+// covers, skipping hidden, ignored, vendored, and oversized files. It reports
+// whether the list was cut at maxSummarizeFiles or by a walk limit.
+func summarizableFiles(root string) ([]string, bool) {
+	matcher := ignore.Load(".")
+	var files []string
+	truncated := false
+
+	// Ignore rules are relative to the project root, not the summarized directory
+	walkTruncated, _ := matcher.Walk(root, ignore.ConfiguredLimits(), func(path, rel string, d os.DirEntry) error {
+		if strings.HasPrefix(d.Name(), ".") || !summarizableExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxSummarizeFileSize {
+			return nil
+		}
+
+		if len(files) >= maxSummarizeFiles {
+			truncated = true
+			return filepath.SkipAll
+		}
+		files = append(files, path)
+		return nil
+	})
+
+	return files, truncated || walkTruncated
+}
+
+// handleRetry drops the last answer and asks the same question again
+func handleRetry(args []string) {
+	temperature := -1.0
+	if len(args) > 0 {
+		if len(args) < 2 || args[0] != "--temp" {
+			fmt.Println("❌ Usage: /retry [--temp <temperature>]")
+			return
+		}
+		t, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || t < 0 || t > 2 {
+			fmt.Printf("❌ Invalid temperature: %s (use a number from 0 to 2)\n", args[1])
+			return
+		}
+		temperature = t
+	}
+
+	messages, err := historyManager.GetSessionHistory(currentSessionID)
+	if err != nil || len(messages) < 2 || messages[len(messages)-1].Role != "assistant" {
+		fmt.Println("❌ Nothing to retry: the last message isn't an AI answer")
+		return
+	}
+	if messages[len(messages)-2].Role != "user" {
+		fmt.Println("❌ Nothing to retry: no question precedes the last answer")
+		return
+	}
+
+	// Drop the answer and its question; asking again records the question anew
+	if _, err := historyManager.PopLastMessage(currentSessionID); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	question, err := historyManager.PopLastMessage(currentSessionID)
+	if err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
 	}
 
-	fmt.Println("  💡 Context is automatically loaded for better AI responses")
+	fmt.Println("🔁 Regenerating the last answer...")
+	if temperature >= 0 {
+		ollama.TalkToOllamaWithTemperature(temperature, question.Content, currentSessionID, historyManager)
+	} else {
+		ollama.TalkToOllama(question.Content, currentSessionID, historyManager)
+	}
 }
 
-func handlePrompt(args []string) {
-	if len(args) == 0 {
-		fmt.Println("❌ Please specify a file to add to context. Example: prompt main.go")
+// handleCache manages the cached explain and analyze answers
+func handleCache(args []string) {
+	if len(args) == 0 || args[0] != "clear" {
+		fmt.Println("💡 Usage: /cache clear")
 		return
 	}
-	file := args[0]
-	fmt.Printf("📄 Adding %s to context...\n", file)
-	fmt.Println("💡 This file will be included in AI responses for better context")
+
+	removed, err := mcp.ClearCache()
+	if err != nil {
+		fmt.Printf("❌ Error clearing cache: %v\n", err)
+		return
+	}
+	fmt.Printf("🗑️  Removed %d cached answers from %s\n", removed, mcp.CacheDir)
 }
 
-func handleReason(args []string) {
-	if len(args) == 0 {
-		fmt.Println("❌ Please specify a problem to reason about. Example: reason 'How to optimize this code?'")
+// handleTemplate lists prompt templates or runs one against a file
+func handleTemplate(args []string) {
+	if len(args) == 0 || args[0] == "list" {
+		fmt.Println("\n📝 Prompt Templates:")
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		for _, template := range agent.ListTemplates(".") {
+			source := "project"
+			if template.Builtin {
+				source = "built-in"
+			}
+			fmt.Printf("  %-20s (%s) %s\n", template.Name, source, truncateForTable(template.Text, 60))
+		}
+		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+		fmt.Printf("💡 Add your own as %s/<name>.txt; use {{file}} for the file path\n", agent.TemplatesDir)
 		return
 	}
-	problem := strings.Join(args, " ")
-	fmt.Printf("🧠 Starting multi-turn reasoning for: %s\n", problem)
-	fmt.Println("💡 Use 'steps' to see reasoning progress")
 
-	// Start reasoning session
-	ollama.InitializeReasoning()
-	ollama.StartReasoning(currentSessionID, problem)
+	if len(args) < 2 {
+		fmt.Println("❌ Usage: /template <name> <file>")
+		return
+	}
 
-	// Add initial step
-	ollama.AddReasoningStep(currentSessionID, "Analyzing the problem", "Breaking down the problem into manageable steps")
+	editRequest, err := agent.RenderTemplate(".", args[0], args[1])
+	if err != nil {
+		fmt.Printf("❌ %v. Run '/template list' to see available templates\n", err)
+		return
+	}
 
-	fmt.Println("🔄 Reasoning session started. The AI will work through this step by step.")
+	fmt.Printf("📝 Applying template '%s' to %s\n", args[0], args[1])
+	if runMCPEdit(args[1], editRequest) {
+		buildIfEnabled()
+	}
 }
 
-func handleSteps() {
-	fmt.Println("🧠 Current Reasoning Steps:")
+// confirmFreshContext warns when a file was changed on disk after it was loaded
+// into the AI's context, and lets the user re-read it, continue, or abort
+func confirmFreshContext(filePath string) bool {
+	if !agent.FileChangedSinceLoad(filePath) {
+		return true
+	}
 
-	// Get reasoning summary
-	summary, err := ollama.GetReasoningSummary(currentSessionID)
+	fmt.Printf("⚠️  %s changed on disk since last read\n", filePath)
+	choice, err := fs.PromptUser("❓ [r]e-read and continue, [c]ontinue anyway, or [a]bort? (r/c/A): ")
 	if err != nil {
-		fmt.Printf("❌ No active reasoning session. Use 'reason <problem>' to start one.\n")
-		return
+		return false
 	}
 
-	fmt.Println(summary)
+	switch strings.ToLower(choice) {
+	case "r", "reread", "re-read":
+		agent.InvalidateProjectContext(".")
+		promptBuilder := agent.NewPromptBuilder()
+		promptBuilder.LoadProjectContext(".")
+		if err := agent.RefreshFileSnapshot(filePath); err != nil {
+			fmt.Printf("❌ Error re-reading %s: %v\n", filePath, err)
+			return false
+		}
+		fmt.Printf("🔄 Re-read %s\n", filePath)
+		return true
+	case "c", "continue":
+		return true
+	default:
+		return false
+	}
 }
 
-func handleGeneralQuestion(input string) {
-	// Use MCP to analyze the project and answer the question
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
+func handleMCPRead(args []string) {
+	if len(args) == 0 {
+		fmt.Println("❌ Please specify a file. Example: mcp-read main.go")
+		return
+	}
 
-	// First, get the current directory contents
-	result, err := client.ExecuteShell("ls -la")
+	full := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "--full" {
+			full = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		fmt.Println("❌ Please specify a file. Example: /read main.go")
+		return
+	}
+
+	filePath := rest[0]
+	client := newMCPClient()
+	result, err := client.ReadFile(filePath)
 	if err != nil {
-		fmt.Printf("❌ Error getting directory contents: %v\n", err)
-		// Fallback to regular AI response
-		ollama.TalkToOllama(input, currentSessionID, historyManager)
+		fmt.Printf("❌ Error: %v\n", err)
 		return
 	}
 
 	if !result.Success {
-		fmt.Printf("❌ Failed to get directory contents: %s\n", result.Error)
-		// Fallback to regular AI response
-		ollama.TalkToOllama(input, currentSessionID, historyManager)
+		fmt.Printf("❌ Read failed: %s\n", result.Error)
 		return
 	}
 
-	// Build enhanced question with directory contents
-	enhancedQuestion := fmt.Sprintf("%s\n\nCurrent directory contents:\n%s", input, result.Output)
+	fs.DisplayContent(fmt.Sprintf("Contents of %s", filePath), result.Content, full)
+}
 
-	// For file-specific questions, try to read relevant files
-	if shouldReadFiles(input) {
-		fileContents := readRelevantFiles()
-		if fileContents != "" {
-			enhancedQuestion += "\n\nFile contents:\n" + fileContents
-		}
+func handleDiff(args []string) {
+	if len(args) == 3 && args[0] == "session" {
+		handleSessionDiff(args[1], args[2])
+		return
 	}
 
-	// Send enhanced question to AI
-	ollama.TalkToOllama(enhancedQuestion, currentSessionID, historyManager)
-}
-
-// shouldReadFiles determines if the question would benefit from file contents
-func shouldReadFiles(question string) bool {
-	questionLower := strings.ToLower(question)
+	if len(args) != 2 {
+		fmt.Println("❌ Usage: diff <file_a> <file_b> or diff session <id1> <id2>")
+		return
+	}
 
-	// Questions that would benefit from file contents
-	fileRelatedKeywords := []string{
-		"what is", "what does", "what's in", "what are",
-		"how does", "how is", "how are",
-		"explain", "describe", "analyze", "review",
-		"code", "function", "class", "method", "variable",
-		"project", "folder", "directory", "files",
-		"main", "app", "script", "program",
+	oldContent, err := fs.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("❌ Error reading %s: %v\n", args[0], err)
+		return
+	}
+	newContent, err := fs.ReadFile(args[1])
+	if err != nil {
+		fmt.Printf("❌ Error reading %s: %v\n", args[1], err)
+		return
 	}
 
-	for _, keyword := range fileRelatedKeywords {
-		if strings.Contains(questionLower, keyword) {
-			return true
-		}
+	diff := fs.GenerateDiff(oldContent, newContent)
+	if diff == "" {
+		fmt.Println("✅ Files are identical")
+		return
 	}
 
-	return false
+	fs.ShowDiff(fmt.Sprintf("Diff %s → %s", args[0], args[1]), diff)
 }
 
-// readRelevantFiles reads the most relevant files in the directory
-func readRelevantFiles() string {
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-
-	// Get list of files
-	result, err := client.ExecuteShell("ls -1")
-	if err != nil || !result.Success {
-		return ""
+// handleSessionDiff compares the transcripts of two conversation sessions
+func handleSessionDiff(firstID, secondID string) {
+	firstHistory, err := historyManager.GetSessionHistory(firstID)
+	if err != nil {
+		fmt.Printf("❌ Error loading session %s: %v\n", firstID, err)
+		return
+	}
+	secondHistory, err := historyManager.GetSessionHistory(secondID)
+	if err != nil {
+		fmt.Printf("❌ Error loading session %s: %v\n", secondID, err)
+		return
 	}
 
-	files := strings.Split(strings.TrimSpace(result.Output), "\n")
-	var fileContents []string
-
-	// Read up to 3 most relevant files
-	fileCount := 0
-	for _, file := range files {
-		if fileCount >= 3 {
-			break
-		}
-
-		// Skip directories and non-source files
-		if strings.Contains(file, "/") ||
-			strings.HasPrefix(file, ".") ||
-			file == "silent-code" ||
-			file == "go.sum" ||
-			file == "LICENSE" {
-			continue
-		}
-
-		// Try to read the file
-		readResult, err := client.ReadFile(file)
-		if err == nil && readResult.Success {
-			fileContents = append(fileContents, fmt.Sprintf("=== %s ===\n%s", file, readResult.Content))
-			fileCount++
-		}
+	diff := fs.GenerateDiff(formatTranscript(firstHistory), formatTranscript(secondHistory))
+	if diff == "" {
+		fmt.Println("✅ Sessions are identical")
+		return
 	}
 
-	return strings.Join(fileContents, "\n\n")
+	fs.ShowDiff(fmt.Sprintf("Diff session %s → %s", firstID, secondID), diff)
 }
 
-func init() {
-	// Add command handlers
+// formatTranscript renders messages as "role: content" lines for diffing
+func formatTranscript(messages []agent.Message) string {
+	var lines []string
+	for _, msg := range messages {
+		lines = append(lines, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
+	}
+	return strings.Join(lines, "\n")
+}
 
-	rootCmd.AddCommand(&cobra.Command{
-		Use:   "explain [file]",
-		Short: "Explain a file or function",
-		Long:  "Get detailed explanations of code files or specific functions",
-		Run: func(cmd *cobra.Command, args []string) {
-			if len(args) == 0 {
-				fmt.Println("❌ Please specify a file or function")
-				return
-			}
-			handleExplain(args)
-		},
-	})
+// handleTool is a hidden debugging command that calls any MCP tool directly:
+// /tool <name> <json-args>. With no name it lists the server's tools.
+func handleTool(input string) {
+	client := newMCPClient()
 
-	rootCmd.AddCommand(&cobra.Command{
-		Use:   "generate [what]",
-		Short: "Generate new code",
-		Long:  "Generate new code based on your specifications",
-		Run: func(cmd *cobra.Command, args []string) {
-			if len(args) == 0 {
-				fmt.Println("❌ Please specify what to generate")
-				return
+	// Split off the command and tool name, keeping the JSON arguments intact
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(input, "/"), "tool"))
+	if rest == "" {
+		tools, err := client.ListTools()
+		if err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Println("🔧 MCP Tools:")
+		for _, tool := range tools {
+			fmt.Printf("  • %s - %s", tool.Name, tool.Description)
+			if params := tool.ParamNames(); len(params) > 0 {
+				fmt.Printf(" (%s)", strings.Join(params, ", "))
 			}
-			handleGenerate(args)
-		},
-	})
-}
-
-// MCP Handler functions
-func handleMCPCreate(args []string) {
-	if len(args) < 2 {
-		fmt.Println("❌ Usage: mcp-create <file> <requirements>")
+			fmt.Println()
+		}
+		fmt.Println("💡 Usage: /tool <name> <json-args> (arguments marked ? are optional)")
 		return
 	}
 
-	filePath := args[0]
-	requirements := strings.Join(args[1:], " ")
+	toolName := rest
+	rawArgs := ""
+	if idx := strings.IndexAny(rest, " \t"); idx != -1 {
+		toolName = rest[:idx]
+		rawArgs = strings.TrimSpace(rest[idx:])
+	}
+
+	arguments := map[string]interface{}{}
+	if rawArgs != "" {
+		if err := json.Unmarshal([]byte(rawArgs), &arguments); err != nil {
+			fmt.Printf("❌ Invalid JSON arguments: %v\n", err)
+			return
+		}
+	}
 
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-	result, err := client.CreateFile(filePath, requirements)
+	result, err := client.CallTool(toolName, arguments)
 	if err != nil {
 		fmt.Printf("❌ Error: %v\n", err)
 		return
 	}
 
-	if !result.Success {
-		fmt.Printf("❌ Creation failed: %s\n", result.Error)
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Printf("❌ Error formatting result: %v\n", err)
 		return
 	}
-
-	fmt.Printf("✅ %s\n", result.Message)
+	fmt.Println(string(data))
 }
 
-func handleMCPEdit(args []string) {
-	if len(args) < 2 {
-		fmt.Println("❌ Usage: mcp-edit <file> <edit_request>")
+// handleRollbackSession restores every file backed up this session to its pre-session state
+func handleRollbackSession() {
+	backups := fs.SessionBackups()
+	if len(backups) == 0 {
+		fmt.Println("📋 No files have been backed up this session")
 		return
 	}
 
-	filePath := args[0]
-	editRequest := strings.Join(args[1:], " ")
+	fmt.Printf("📋 %d file(s) will be restored to their pre-session state:\n", len(backups))
+	for _, backup := range backups {
+		fmt.Printf("  • %s (backed up %s)\n", backup.FilePath, backup.CreatedAt.Format("15:04:05"))
+	}
 
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-	result, err := client.EditFile(filePath, editRequest)
+	confirm, err := fs.ConfirmAction("\n❓ Do you want to restore all of these files? (y/N): ")
 	if err != nil {
 		fmt.Printf("❌ Error: %v\n", err)
 		return
 	}
-
-	if !result.Success {
-		fmt.Printf("❌ Edit failed: %s\n", result.Error)
+	if !confirm {
+		fmt.Println("❌ Rollback cancelled")
 		return
 	}
 
-	fmt.Printf("✅ %s\n", result.Message)
+	restored := 0
+	for _, backup := range backups {
+		if err := fs.RestoreSessionBackup(backup); err != nil {
+			fmt.Printf("  ❌ %s: %v\n", backup.FilePath, err)
+			continue
+		}
+		fmt.Printf("  ↩️  Restored %s\n", backup.FilePath)
+		restored++
+	}
+
+	fmt.Printf("✅ Restored %d of %d file(s)\n", restored, len(backups))
 }
 
-func handleMCPRead(args []string) {
+// handleEnv lists, sets, or unsets the session's environment variables for shell commands
+func handleEnv(args []string) {
 	if len(args) == 0 {
-		fmt.Println("❌ Please specify a file. Example: mcp-read main.go")
+		if len(sessionEnv) == 0 {
+			fmt.Println("🌱 No session environment variables set")
+			fmt.Println("💡 Usage: /env KEY=VALUE, /env -KEY to unset")
+			return
+		}
+
+		keys := make([]string, 0, len(sessionEnv))
+		for key := range sessionEnv {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Println("🌱 Session environment:")
+		for _, key := range keys {
+			fmt.Printf("  %s=%s\n", key, sessionEnv[key])
+		}
 		return
 	}
 
-	filePath := args[0]
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-	result, err := client.ReadFile(filePath)
-	if err != nil {
-		fmt.Printf("❌ Error: %v\n", err)
-		return
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			key := strings.TrimPrefix(arg, "-")
+			delete(sessionEnv, key)
+			fmt.Printf("✅ Unset %s\n", key)
+			continue
+		}
+
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			fmt.Printf("❌ Expected KEY=VALUE, got: %s\n", arg)
+			continue
+		}
+		sessionEnv[key] = value
+		fmt.Printf("✅ %s=%s\n", key, value)
 	}
+}
 
-	if !result.Success {
-		fmt.Printf("❌ Read failed: %s\n", result.Error)
-		return
+// maxShellOutputs is how many recent command outputs are remembered
+const maxShellOutputs = 5
+
+// maxShellOutputChars caps how much of a command's output is attached to a
+// question; the end is kept since that is where errors usually are
+const maxShellOutputChars = 8000
+
+// shellOutput is the combined output of a shell command run from the prompt
+type shellOutput struct {
+	Command  string
+	Output   string
+	ExitCode int
+}
+
+// contextItem returns the output, trimmed to its last maxShellOutputChars
+func (o shellOutput) contextItem() contextItem {
+	content := o.Output
+	if len(content) > maxShellOutputChars {
+		content = "..." + content[len(content)-maxShellOutputChars:]
 	}
+	return contextItem{Name: fmt.Sprintf("output of `%s`", o.Command), Content: content}
+}
 
-	fmt.Printf("\n📄 Contents of %s:\n", filePath)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println(result.Content)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+// shellOutputs is a ring buffer of the most recent command outputs
+var (
+	shellOutputs    [maxShellOutputs]shellOutput
+	shellOutputNext int
+	shellOutputLen  int
+)
+
+// recordShellOutput remembers a command's output for later questions
+func recordShellOutput(output shellOutput) {
+	shellOutputs[shellOutputNext] = output
+	shellOutputNext = (shellOutputNext + 1) % maxShellOutputs
+	shellOutputLen = min(shellOutputLen+1, maxShellOutputs)
+}
+
+// shellOutputAt returns the output of the back-th most recent shell
+// command, 1 being the latest
+func shellOutputAt(back int) (shellOutput, bool) {
+	if back < 1 || back > shellOutputLen {
+		return shellOutput{}, false
+	}
+	return shellOutputs[(shellOutputNext+maxShellOutputs-back)%maxShellOutputs], true
+}
+
+// shellOutputReferences are phrases that point back at a command's output
+var shellOutputReferences = []string{
+	"that output", "this output", "the output above", "output above",
+	"that error", "this error", "the error above", "error above",
+	"above error", "above output",
+}
+
+// referencesShellOutput reports whether a question refers to the output of
+// the previous command, e.g. "why did that error happen?"
+func referencesShellOutput(input string) bool {
+	inputLower := strings.ToLower(input)
+	for _, phrase := range shellOutputReferences {
+		if strings.Contains(inputLower, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleWithOutput asks a question with a recent command's output attached:
+// the last one, or with a leading -N the Nth most recent
+func handleWithOutput(args []string) {
+	back := 1
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		n, err := strconv.Atoi(strings.TrimPrefix(args[0], "-"))
+		if err != nil || n < 1 || n > maxShellOutputs {
+			fmt.Printf("❌ Invalid output number: %s (use -1 to -%d)\n", args[0], maxShellOutputs)
+			return
+		}
+		back = n
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fmt.Println("❌ Please ask a question. Example: /with-output why does this fail?")
+		return
+	}
+	output, ok := shellOutputAt(back)
+	if !ok {
+		if shellOutputLen == 0 {
+			fmt.Println("❌ No shell command has been run yet")
+		} else {
+			fmt.Printf("❌ Only %d command output(s) are remembered\n", shellOutputLen)
+		}
+		return
+	}
+	askQuestion(strings.Join(args, " "), &output)
 }
 
 func handleShellCommand(command string) {
 	fmt.Printf("🔧 Executing: %s\n", command)
 
-	client := mcp.NewMCPClient("http://127.0.0.1:8080")
-	result, err := client.ExecuteShell(command)
+	client := newMCPClient()
+	result, err := client.ExecuteShellWithOptions(command, mcp.ShellOptions{
+		Timeout: shellTimeout,
+		Env:     sessionEnv,
+	})
 	if err != nil {
 		fmt.Printf("❌ Error: %v\n", err)
 		return
 	}
+	recordShellOutput(shellOutput{Command: command, Output: result.Output + result.Stderr, ExitCode: result.ExitCode})
+
+	if result.TimedOut {
+		// Show what the command printed before it was killed
+		if result.Output != "" {
+			fmt.Print(result.Output)
+		}
+		if result.Stderr != "" {
+			fmt.Print(result.Stderr)
+		}
+		fmt.Printf("⏱️  %s\n", result.Error)
+		fmt.Println("💡 Allow more time with: /config shell-timeout <seconds>")
+		return
+	}
 
 	if !result.Success {
-		fmt.Printf("❌ Command failed: %s\n", result.Error)
+		fmt.Printf("❌ Command failed (exit code %d): %s\n", result.ExitCode, result.Error)
+		if result.ExitCode == 127 {
+			fmt.Println("💡 Command not found")
+		}
+		if result.Output != "" {
+			fmt.Print(result.Output)
+		}
 		if result.Stderr != "" {
 			fmt.Printf("Error output: %s\n", result.Stderr)
 		}
@@ -780,15 +4334,16 @@ func getActualFiles(projectPath string) []string {
 		return []string{}
 	}
 
+	matcher := ignore.Load(projectPath)
+
 	var actualFiles []string
 	for _, file := range files {
 		if !file.IsDir() {
-			// Skip hidden files and common non-source files
+			// Skip hidden files, ignored files, and the configured non-source files
 			fileName := file.Name()
 			if !strings.HasPrefix(fileName, ".") &&
-				fileName != "silent-code" &&
-				fileName != "go.sum" &&
-				fileName != "LICENSE" {
+				!matchesAny(config.Get().SkipFiles, fileName) &&
+				!matcher.Match(fileName, false) {
 				actualFiles = append(actualFiles, fileName)
 			}
 		}
@@ -797,6 +4352,16 @@ func getActualFiles(projectPath string) []string {
 	return actualFiles
 }
 
+// matchesAny reports whether name matches one of the file names or globs
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // getMainFiles returns main files for a project type (kept for backward compatibility)
 func getMainFiles(projectType string) []string {
 	mainFilesMap := map[string][]string{
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Preset bundles a model choice with generation options under a name
+type Preset struct {
+	Model       string   `json:"model,omitempty"`        // Exact model to use; empty selects one automatically
+	PreferSmall bool     `json:"prefer_small,omitempty"` // When Model is empty, pick the smallest installed model instead of the best
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+}
+
+// Config holds user and project settings loaded from config.json files
+type Config struct {
+	Presets         map[string]Preset   `json:"presets,omitempty"`
+	AutoApply       bool                `json:"auto_apply,omitempty"`             // Skip confirmation prompts (backups are still made)
+	AutoContext     bool                `json:"auto_context"`                     // Attach directory listings and files to project questions
+	VerboseContext  bool                `json:"verbose_context,omitempty"`        // Show the size of each item attached to a question
+	PreviewLines    int                 `json:"preview_lines,omitempty"`          // Lines shown by file previews before eliding the middle; -1 for no cap
+	ShellTimeout    int                 `json:"shell_timeout,omitempty"`          // Seconds a shell command may run before it is killed; 0 uses the server default
+	EmbedModel      string              `json:"embed_model,omitempty"`            // Model used for semantic search embeddings
+	MaxSessions     int                 `json:"max_sessions_in_memory,omitempty"` // Conversations kept cached in memory; -1 for no cap
+	NoStream        bool                `json:"no_stream,omitempty"`              // Print responses whole instead of streaming them
+	MainFiles       map[string][]string `json:"main_files,omitempty"`             // Per project type, the files (or globs) loaded as project context
+	SkipFiles       []string            `json:"skip_files,omitempty"`             // File names (or globs) left out of the /context file list
+	HistoryDir      string              `json:"history_dir,omitempty"`            // Where conversation sessions are saved; defaults to ~/.silent-code/sessions
+	LocalHistory    bool                `json:"local_history,omitempty"`          // Save sessions in ./history/sessions inside the project instead
+	Temperatures    map[string]float64  `json:"temperatures,omitempty"`           // Default temperature per operation; a preset's temperature takes precedence
+	ModelHint       bool                `json:"model_hint"`                       // Mention a better installed model at startup when another one is in use
+	Aliases         map[string]string   `json:"aliases,omitempty"`                // Command shortcuts, e.g. "x": "explain"; the value may include arguments
+	Warmup          bool                `json:"warmup,omitempty"`                 // Load the model into memory at startup so the first question is fast
+	ModelPriorities map[string]int      `json:"model_priorities,omitempty"`       // Score per exact model name when choosing a model automatically; higher wins
+	FallbackScoring FallbackScoring     `json:"fallback_scoring"`                 // How installed models missing from ModelPriorities are scored
+	ModelCacheTTL   int                 `json:"model_cache_ttl,omitempty"`        // Seconds the installed model list is reused before asking Ollama again; 0 uses the default, -1 disables the cache
+	SystemPrompt    string              `json:"system_prompt,omitempty"`          // Replaces the built-in system prompt
+	MaxDepth        int                 `json:"max_depth"`                        // Deepest directory level searches, indexing, and context loading walk into; 0 for no limit
+	MaxFiles        int                 `json:"max_files"`                        // Files one walk of the project visits before stopping; 0 for no limit
+	HistoryMessages int                 `json:"history_messages"`                 // Most recent messages of a session included in each prompt; 0 for no limit
+	HistoryTokens   int                 `json:"history_tokens,omitempty"`         // Estimated tokens of history included in each prompt; 0 for no budget
+	BuildAfterEdit  bool                `json:"build_after_edit,omitempty"`       // Run /build after every accepted AI edit
+}
+
+// FallbackScoring scores a model that isn't listed in ModelPriorities from
+// its name, size, and age
+type FallbackScoring struct {
+	Base           int            `json:"base"`                      // Starting score for every unlisted model
+	Keywords       map[string]int `json:"keywords,omitempty"`        // Added for each keyword found in the model name
+	ParameterSizes map[string]int `json:"parameter_sizes,omitempty"` // Added for a parameter size such as "13B"
+	RecentDays     map[int]int    `json:"recent_days,omitempty"`     // Added when the model was pulled within that many days; the smallest matching window counts
+}
+
+// Operations that get their own default temperature
+const (
+	OpChat     = "chat"
+	OpEdit     = "edit"
+	OpGenerate = "generate"
+)
+
+// Operations lists every operation with a temperature, in display order
+var Operations = []string{OpChat, OpGenerate, OpEdit}
+
+// ConfigFileName is the name of the config file inside a .silent-code directory
+const ConfigFileName = "config.json"
+
+var (
+	current   = defaultConfig()
+	currentMu sync.RWMutex
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// defaultConfig returns the built-in settings used when no config file exists
+func defaultConfig() *Config {
+	return &Config{
+		AutoContext:     true,
+		ModelHint:       true,
+		PreviewLines:    200,
+		MaxDepth:        20,
+		MaxFiles:        20000,
+		HistoryMessages: 20,
+		MainFiles:       defaultMainFiles(),
+		SkipFiles:       []string{"silent-code", "go.sum", "LICENSE", "*.lock", "package-lock.json"},
+		// Code changes should be repeatable; conversation benefits from some variety
+		Temperatures: map[string]float64{
+			OpChat:     0.7,
+			OpGenerate: 0.1,
+			OpEdit:     0,
+		},
+		ModelPriorities: defaultModelPriorities(),
+		FallbackScoring: FallbackScoring{
+			Base:           30,
+			Keywords:       map[string]int{"code": 30, "coder": 25, "star": 20, "wizard": 15, "magic": 15},
+			ParameterSizes: map[string]int{"7B": 15, "13B": 20, "34B": 25, "70B": 30},
+			RecentDays:     map[int]int{30: 10, 90: 5},
+		},
+		Presets: map[string]Preset{
+			"fast": {
+				PreferSmall: true,
+				Temperature: floatPtr(0.2),
+				NumPredict:  512,
+				NumCtx:      2048,
+			},
+			"quality": {
+				Temperature: floatPtr(0.1),
+				NumPredict:  -1,
+				NumCtx:      8192,
+			},
+		},
+	}
+}
+
+// defaultModelPriorities ranks well-known coding models; entries in a config
+// file are added to these or replace their scores
+func defaultModelPriorities() map[string]int {
+	return map[string]int{
+		"qwen2.5-coder:7b":      100,
+		"codellama:13b":         95,
+		"codellama:34b":         90,
+		"deepseek-coder-v2:16b": 88,
+		"qwen2.5-coder:32b":     85,
+		"qwen2.5-coder:14b":     80,
+		"deepseek-coder:33b":    75,
+		"magicoder:15b":         70,
+		"deepseek-coder:6.7b":   65,
+		"starcoder2:15b":        60,
+		"magicoder:7b":          55,
+		"starcoder2:7b":         50,
+		"starcoder2:3b":         45,
+		"qwen2.5-coder:1.5b":    42,
+		"qwen2.5:32b":           40,
+		"qwen2.5:14b":           35,
+		"llama3.1:8b":           32,
+		"qwen2.5:7b":            30,
+		"gemma2:27b":            25,
+		"gemma2:9b":             20,
+	}
+}
+
+// defaultMainFiles lists the files that usually explain a project of each
+// type. Entries may be globs; settings in a config file replace the list for
+// that project type only.
+func defaultMainFiles() map[string][]string {
+	return map[string][]string{
+		"Go":                 {"main.go", "cmd/main.go", "cmd/*/main.go", "cmd/root.go", "README.md"},
+		"JavaScript/Node.js": {"index.js", "app.js", "server.js", "src/index.js", "src/main.js", "src/app.js", "src/index.ts", "src/main.ts", "src/app.ts", "package.json", "README.md"},
+		"Python":             {"main.py", "app.py", "manage.py", "app/main.py", "src/main.py", "src/*/__main__.py", "requirements.txt", "pyproject.toml", "README.md"},
+		"Java":               {"src/main/java/*/*Application.java", "pom.xml", "README.md"},
+		"Java/Gradle":        {"src/main/java/*/*Application.java", "build.gradle", "README.md"},
+		"Rust":               {"src/main.rs", "src/lib.rs", "Cargo.toml", "README.md"},
+		"PHP":                {"index.php", "public/index.php", "composer.json", "README.md"},
+		"Ruby":               {"main.rb", "app.rb", "config/routes.rb", "Gemfile", "README.md"},
+		"Swift/Objective-C":  {"main.swift", "AppDelegate.swift", "Sources/*/main.swift", "README.md"},
+		"Elixir":             {"lib/*.ex", "mix.exs", "README.md"},
+		"Dart/Flutter":       {"lib/main.dart", "pubspec.yaml", "README.md"},
+		"Unknown":            {"README.md"},
+	}
+}
+
+// UserConfigPath returns the path of the user-level config file
+func UserConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".silent-code", ConfigFileName)
+}
+
+// ProjectConfigPath returns the path of the project-level config file
+func ProjectConfigPath() string {
+	return filepath.Join(".silent-code", ConfigFileName)
+}
+
+// Load reads the user config and then the project config on top of the
+// built-in defaults. Settings in later files override earlier ones.
+func Load() error {
+	cfg := defaultConfig()
+
+	for _, path := range []string{UserConfigPath(), ProjectConfigPath()} {
+		if path == "" {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read config %s: %w", path, err)
+		}
+
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	currentMu.Lock()
+	current = cfg
+	currentMu.Unlock()
+
+	return nil
+}
+
+// SaveUserSetting sets one key in the user config file, keeping every other
+// setting in it as it was. A nil value removes the key.
+func SaveUserSetting(key string, value interface{}) error {
+	path := UserConfigPath()
+	if path == "" {
+		return fmt.Errorf("could not find the home directory")
+	}
+
+	settings := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	if value == nil {
+		delete(settings, key)
+	} else {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		settings[key] = encoded
+	}
+
+	data, err = json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Get returns the active configuration
+func Get() *Config {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	return current
+}
+
+// OperationTemperature returns the default temperature for an operation
+func OperationTemperature(op string) (float64, bool) {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+
+	temperature, ok := current.Temperatures[op]
+	return temperature, ok
+}
+
+// SetOperationTemperature overrides an operation's temperature for this run
+func SetOperationTemperature(op string, temperature float64) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	if current.Temperatures == nil {
+		current.Temperatures = make(map[string]float64)
+	}
+	current.Temperatures[op] = temperature
+}
+
+// SetSystemPrompt changes the configured system prompt for this run, such as
+// after it was saved with SaveUserSetting
+func SetSystemPrompt(prompt string) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	current.SystemPrompt = prompt
+}
@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// GeneratedFile is a file to create from part of a model response
+type GeneratedFile struct {
+	Path    string
+	Content string
+}
+
+// maxLabelDecls is how many declarations a code block's label names
+const maxLabelDecls = 3
+
+// ParseGeneratedBlocks returns the code of every fenced block in a model
+// response, in order, for responses with several blocks such as an
+// implementation and its tests. Unlike ParseGeneratedContent it keeps the
+// code as written and accepts any language.
+func ParseGeneratedBlocks(content string) []string {
+	var blocks []string
+	for _, match := range fencedBlockRegex.FindAllStringSubmatch(content, -1) {
+		if strings.TrimSpace(match[1]) != "" {
+			blocks = append(blocks, match[1])
+		}
+	}
+	return blocks
+}
+
+// CodeBlockLabel describes a generated code block in a few words: for Go,
+// its package and first declarations, otherwise its first line, followed by
+// its length
+func CodeBlockLabel(block string) string {
+	lines := strings.Count(strings.TrimRight(block, "\n"), "\n") + 1
+
+	file, err := parser.ParseFile(token.NewFileSet(), "", block, parser.SkipObjectResolution)
+	if err != nil {
+		// Not valid Go; the first line will have to do
+		first, _, _ := strings.Cut(strings.TrimSpace(block), "\n")
+		return fmt.Sprintf("%s (%d lines)", first, lines)
+	}
+
+	var names []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			names = append(names, "func "+d.Name.Name)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				if typeSpec, ok := spec.(*ast.TypeSpec); ok {
+					names = append(names, "type "+typeSpec.Name.Name)
+				}
+			}
+		}
+	}
+	if len(names) > maxLabelDecls {
+		names = append(names[:maxLabelDecls], "...")
+	}
+
+	label := "package " + file.Name.Name
+	if len(names) > 0 {
+		label += ": " + strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s (%d lines)", label, lines)
+}
+
+// ChooseCodeBlocks asks which of several generated code blocks to save to
+// filePath, or whether to save them all to separate files. In auto-apply
+// mode it takes the first block without asking. It returns no files when
+// the user cancels.
+func ChooseCodeBlocks(filePath string, blocks []string) ([]GeneratedFile, error) {
+	if len(blocks) == 1 || autoApply {
+		return []GeneratedFile{{Path: filePath, Content: blocks[0]}}, nil
+	}
+
+	fmt.Printf("📦 The response has %d code blocks:\n", len(blocks))
+	for i, block := range blocks {
+		fmt.Printf("  %d. %s\n", i+1, CodeBlockLabel(block))
+	}
+
+	for {
+		choice, err := PromptUser(fmt.Sprintf("\n❓ Save which block to %s? (1-%d, (a)ll to separate files, (c)ancel) [1]: ", filePath, len(blocks)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get choice: %w", err)
+		}
+
+		switch strings.ToLower(choice) {
+		case "":
+			return []GeneratedFile{{Path: filePath, Content: blocks[0]}}, nil
+		case "a", "all":
+			files := make([]GeneratedFile, 0, len(blocks))
+			taken := map[string]bool{}
+			for i, block := range blocks {
+				path := filePath
+				if i > 0 {
+					path = blockPath(filePath, block, i, taken)
+				}
+				taken[path] = true
+				files = append(files, GeneratedFile{Path: path, Content: block})
+				fmt.Printf("  %d → %s\n", i+1, path)
+			}
+			return files, nil
+		case "c", "cancel":
+			return nil, nil
+		}
+
+		if n, err := strconv.Atoi(choice); err == nil && n >= 1 && n <= len(blocks) {
+			return []GeneratedFile{{Path: filePath, Content: blocks[n-1]}}, nil
+		}
+		fmt.Printf("⚠️  Enter a number from 1 to %d, a, or c\n", len(blocks))
+	}
+}
+
+// blockPath picks a file next to filePath for the block at index: the
+// matching _test file for tests, otherwise a numbered sibling. Paths that
+// exist or were already picked are skipped.
+func blockPath(filePath, block string, index int, taken map[string]bool) string {
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+
+	available := func(path string) bool {
+		if taken[path] {
+			return false
+		}
+		_, err := os.Stat(path)
+		return os.IsNotExist(err)
+	}
+
+	if strings.Contains(block, "func Test") {
+		if path := base + "_test" + ext; available(path) {
+			return path
+		}
+	}
+	for n := index + 1; ; n++ {
+		if path := fmt.Sprintf("%s_%d%s", base, n, ext); available(path) {
+			return path
+		}
+	}
+}
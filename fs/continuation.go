@@ -0,0 +1,154 @@
+package fs
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// MaxFileContinuations is how many times a cut-off file is sent back to the
+// model to be continued before the partial result is used as it is
+const MaxFileContinuations = 3
+
+// continuationContextLines is how much of the end of a partial file is sent
+// with a continuation request
+const continuationContextLines = 200
+
+// braceLanguages are the extensions whose files are cut off when a brace is
+// left open
+var braceLanguages = map[string]bool{
+	".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".java": true, ".c": true,
+	".h": true, ".cpp": true, ".hpp": true, ".cs": true, ".rs": true, ".swift": true,
+	".kt": true, ".php": true, ".scala": true, ".dart": true, ".css": true,
+	".scss": true, ".less": true, ".json": true,
+}
+
+// LooksTruncated reports whether generated file content was cut off before
+// it was finished: a code fence is left open, a Go file ends in the middle
+// of a declaration, or a brace-delimited file has braces left open
+func LooksTruncated(filePath, content string) bool {
+	if strings.Count(content, "```")%2 == 1 {
+		return true
+	}
+	code := unfence(content)
+	if strings.TrimSpace(code) == "" {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(filePath))
+	if ext == ".go" {
+		_, err := parser.ParseFile(token.NewFileSet(), filePath, code, parser.PackageClauseOnly|parser.AllErrors)
+		if err != nil {
+			return false
+		}
+		_, err = parser.ParseFile(token.NewFileSet(), filePath, code, parser.AllErrors)
+		return err != nil && strings.Contains(err.Error(), "EOF")
+	}
+	if braceLanguages[ext] {
+		return strings.Count(code, "{") > strings.Count(code, "}")
+	}
+	return false
+}
+
+// ContinueFilePrompt asks the model to carry on writing a file from where
+// its previous output stopped
+func ContinueFilePrompt(filePath, partial string) string {
+	lines := strings.Split(partial, "\n")
+	if len(lines) > continuationContextLines {
+		lines = lines[len(lines)-continuationContextLines:]
+	}
+
+	return fmt.Sprintf(`TASK: You were generating the file "%s" but your output was cut off before the file was finished.
+
+END OF THE FILE SO FAR:
+%s
+
+Continue the file exactly where it stops. Return ONLY the remaining content, starting right after the last line above.
+Do NOT repeat any of the content above and do NOT write any explanations.`, filePath, strings.Join(lines, "\n"))
+}
+
+// CompleteTruncatedFile asks continueFile for the rest of a cut-off file
+// until the result no longer looks truncated, nothing more comes back, or
+// MaxFileContinuations is reached. continueFile gets a ContinueFilePrompt.
+// It returns the assembled file without code fences and how many
+// continuations were appended; on an error the file assembled so far is
+// returned with it.
+func CompleteTruncatedFile(filePath, content string, continueFile func(prompt string) (string, error)) (string, int, error) {
+	code := unfence(content)
+	continuations := 0
+	for continuations < MaxFileContinuations && LooksTruncated(filePath, code) {
+		more, err := continueFile(ContinueFilePrompt(filePath, code))
+		if err != nil {
+			return code, continuations, err
+		}
+		if strings.TrimSpace(unfence(more)) == "" {
+			break
+		}
+		code = AppendContinuation(code, more)
+		continuations++
+	}
+	return code, continuations, nil
+}
+
+// maxOverlapLines is how many repeated lines are looked for at the start of
+// a continuation
+const maxOverlapLines = 20
+
+// minOverlapChars is how much text repeated lines must hold before they're
+// treated as overlap, so a lone "}" that really closes another block stays
+const minOverlapChars = 10
+
+// AppendContinuation joins a continuation onto a partial file, dropping its
+// code fences and any lines at its start that repeat the end of the partial
+func AppendContinuation(partial, continuation string) string {
+	continuation = unfence(continuation)
+
+	partialLines := strings.Split(strings.TrimRight(partial, "\n"), "\n")
+	moreLines := strings.Split(continuation, "\n")
+	overlap := 0
+	for n := min(min(len(partialLines), len(moreLines)-1), maxOverlapLines); n > 0; n-- {
+		repeated := moreLines[:n]
+		if sameLines(partialLines[len(partialLines)-n:], repeated) && len(strings.Join(strings.Fields(strings.Join(repeated, " ")), "")) >= minOverlapChars {
+			overlap = n
+			break
+		}
+	}
+	if overlap > 0 {
+		return strings.TrimRight(partial, "\n") + "\n" + strings.Join(moreLines[overlap:], "\n")
+	}
+	return partial + continuation
+}
+
+// sameLines reports whether two runs of lines match, ignoring indentation
+func sameLines(a, b []string) bool {
+	for i := range a {
+		if strings.TrimSpace(a[i]) != strings.TrimSpace(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// unfence returns the code in a model response: the largest fenced block,
+// the text after a fence that was never closed, the text before a closing
+// fence whose opening came in an earlier response, or the whole response
+func unfence(content string) string {
+	if strings.Count(content, "```")%2 == 0 {
+		if !strings.Contains(content, "```") {
+			return content
+		}
+		return extractFullFile(content)
+	}
+
+	idx := strings.LastIndex(content, "```")
+	after := content[idx+3:]
+	if strings.TrimSpace(after) == "" {
+		return content[:idx]
+	}
+	if nl := strings.IndexByte(after, '\n'); nl >= 0 {
+		return after[nl+1:]
+	}
+	return ""
+}
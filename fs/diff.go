@@ -0,0 +1,240 @@
+package fs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+const diffContextLines = 3
+
+// diffOp is a single line of an edit script
+type diffOp struct {
+	Type    LineType
+	Content string
+	OldLine int // 0-based index in the old content, -1 for additions
+	NewLine int // 0-based index in the new content, -1 for deletions
+}
+
+// GenerateDiff returns a unified diff (hunks only, without file headers)
+// that turns oldContent into newContent. It returns an empty string when the
+// contents are identical.
+func GenerateDiff(oldContent, newContent string) string {
+	oldLines := strings.Split(oldContent, "\n")
+	newLines := strings.Split(newContent, "\n")
+
+	ops := diffLines(oldLines, newLines)
+	return formatUnifiedDiff(ops)
+}
+
+// DiffStats counts the lines added and removed between two versions of a
+// file. Changed counts lines replaced in place, i.e. the deletions paired
+// with additions in each run of changes.
+func DiffStats(oldContent, newContent string) (added, removed, changed int) {
+	ops := diffLines(strings.Split(oldContent, "\n"), strings.Split(newContent, "\n"))
+
+	runAdded, runRemoved := 0, 0
+	for _, op := range append(ops, diffOp{Type: Context}) {
+		switch op.Type {
+		case Addition:
+			runAdded++
+		case Deletion:
+			runRemoved++
+		default:
+			added += runAdded
+			removed += runRemoved
+			changed += min(runAdded, runRemoved)
+			runAdded, runRemoved = 0, 0
+		}
+	}
+
+	return added, removed, changed
+}
+
+// diffLines computes a shortest edit script between two line slices using
+// Myers' O(ND) algorithm, which finds a longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds the furthest x reached on diagonals -d-1..d+1 before round d
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		window := make([]int, 2*d+3)
+		copy(window, v[offset-d-1:offset+d+2])
+		trace = append(trace, window)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+
+	return nil
+}
+
+// backtrackDiff walks the Myers trace backwards to build the edit script
+func backtrackDiff(a, b []string, trace [][]int) []diffOp {
+	x, y := len(a), len(b)
+	var ops []diffOp
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		window := trace[d]
+		get := func(k int) int { return window[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{Type: Context, Content: a[x-1], OldLine: x - 1, NewLine: y - 1})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{Type: Addition, Content: b[prevY], OldLine: -1, NewLine: prevY})
+			} else {
+				ops = append(ops, diffOp{Type: Deletion, Content: a[prevX], OldLine: prevX, NewLine: -1})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	// Ops were collected from the end of the files; put them in order
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+
+	return ops
+}
+
+// formatUnifiedDiff groups an edit script into hunks with surrounding context
+func formatUnifiedDiff(ops []diffOp) string {
+	var out strings.Builder
+
+	i := 0
+	for i < len(ops) {
+		// Find the next change
+		for i < len(ops) && ops[i].Type == Context {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		start := i - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are within two context windows of each other
+		end := i
+		for end < len(ops) {
+			if ops[end].Type != Context {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].Type == Context {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContextLines {
+				end += diffContextLines
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = next
+		}
+
+		writeHunk(&out, ops, start, end)
+		i = end
+	}
+
+	return out.String()
+}
+
+// writeHunk writes ops[start:end] as a single unified diff hunk
+func writeHunk(out *strings.Builder, ops []diffOp, start, end int) {
+	oldStart, newStart := -1, -1
+	oldCount, newCount := 0, 0
+
+	for _, op := range ops[start:end] {
+		if op.OldLine >= 0 {
+			if oldStart == -1 {
+				oldStart = op.OldLine
+			}
+			oldCount++
+		}
+		if op.NewLine >= 0 {
+			if newStart == -1 {
+				newStart = op.NewLine
+			}
+			newCount++
+		}
+	}
+
+	// Empty ranges refer to the line before the insertion point
+	oldStart = hunkStart(ops, start, oldStart, oldCount, true)
+	newStart = hunkStart(ops, start, newStart, newCount, false)
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+	for _, op := range ops[start:end] {
+		switch op.Type {
+		case Context:
+			out.WriteString(" " + op.Content + "\n")
+		case Addition:
+			out.WriteString("+" + op.Content + "\n")
+		case Deletion:
+			out.WriteString("-" + op.Content + "\n")
+		}
+	}
+}
+
+// hunkStart converts a 0-based first line into the 1-based start of a hunk range
+func hunkStart(ops []diffOp, start, first, count int, old bool) int {
+	if count > 0 {
+		return first + 1
+	}
+
+	// Count the lines on this side that precede the hunk
+	preceding := 0
+	for _, op := range ops[:start] {
+		if old && op.OldLine >= 0 || !old && op.NewLine >= 0 {
+			preceding++
+		}
+	}
+	return preceding
+}
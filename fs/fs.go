@@ -2,22 +2,60 @@ package fs
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/muratbekj/silent-code/ignore"
 )
 
+// ErrBinaryFile is returned when a file looks binary and can't be used as text
+var ErrBinaryFile = errors.New("binary file skipped")
+
+// utf8BOM is the byte order mark some editors put at the start of UTF-8 files
+const utf8BOM = "\xef\xbb\xbf"
+
+// binarySniffLen is how much of a file is checked for null bytes
+const binarySniffLen = 8000
+
+// IsBinary reports whether data looks like a binary file (contains a null byte)
+func IsBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), binarySniffLen)], 0) >= 0
+}
+
+// DecodeText turns file bytes into prompt-ready text: binary data is
+// refused, a UTF-8 BOM is stripped, and CRLF line endings become LF
+func DecodeText(data []byte) (string, error) {
+	if IsBinary(data) {
+		return "", ErrBinaryFile
+	}
+	text := strings.TrimPrefix(string(data), utf8BOM)
+	return strings.ReplaceAll(text, "\r\n", "\n"), nil
+}
+
+// ReadFile reads a text file, normalized by DecodeText
 func ReadFile(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
-	return string(data), nil
+	text, err := DecodeText(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	return text, nil
 }
 
+// WriteFile writes text to path, keeping the BOM and CRLF line endings of
+// the file it replaces so edits don't rewrite every line
 func WriteFile(path string, data string) error {
 	// Ensure directory exists
 	dir := filepath.Dir(path)
@@ -25,29 +63,188 @@ func WriteFile(path string, data string) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	if existing, err := os.ReadFile(path); err == nil {
+		data = encodeLike(existing, data)
+	}
 	return os.WriteFile(path, []byte(data), 0644)
 }
 
+// encodeLike applies the line endings and BOM of existing to LF text
+func encodeLike(existing []byte, text string) string {
+	if bytes.Contains(existing, []byte("\r\n")) && !strings.Contains(text, "\r\n") {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	if bytes.HasPrefix(existing, []byte(utf8BOM)) && !strings.HasPrefix(text, utf8BOM) {
+		text = utf8BOM + text
+	}
+	return text
+}
+
+// copyFile copies a file byte for byte, used for backups so they stay exact
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
 func FileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
 }
 
-func DisplayFile(path string) error {
+// DisplayFile prints a file with line numbers, capped unless full is set
+func DisplayFile(path string, full bool) error {
 	content, err := ReadFile(path)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("\n📄 Contents of %s:\n", path)
+	DisplayContent(fmt.Sprintf("Contents of %s", path), content, full)
+	return nil
+}
+
+// previewLineLimit caps how many lines previews print; 0 means no cap
+var previewLineLimit = 200
+
+// SetPreviewLineLimit sets the preview line cap; 0 or less prints every line
+func SetPreviewLineLimit(n int) {
+	if n < 0 {
+		n = 0
+	}
+	previewLineLimit = n
+}
+
+// DisplayContent prints content with line numbers under a title. Unless full
+// is set, long content is cut to the head and tail around an omission marker.
+func DisplayContent(title, content string, full bool) {
+	fmt.Printf("\n📄 %s:\n", title)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Printf("%s\n", content)
+	limit := previewLineLimit
+	if full {
+		limit = 0
+	}
+	printNumberedLines(content, limit)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	return nil
 }
 
-func GetEditPrompt(filePath, content, editRequest string) string {
-	return fmt.Sprintf(`TASK: Edit the Go file "%s" by making the requested change.
+// printNumberedLines prints numbered lines, keeping the first and last
+// limit/2 lines when there are more than limit. Numbers stay those of the
+// original content across the omission.
+func printNumberedLines(content string, limit int) {
+	lines := strings.Split(content, "\n")
+	width := len(strconv.Itoa(len(lines)))
+	if width < 3 {
+		width = 3
+	}
+
+	printLine := func(i int) {
+		fmt.Printf("%*d│ %s\n", width, i+1, lines[i])
+	}
+
+	if limit <= 0 || len(lines) <= limit {
+		for i := range lines {
+			printLine(i)
+		}
+		return
+	}
+
+	head := limit / 2
+	tail := limit - head
+	for i := 0; i < head; i++ {
+		printLine(i)
+	}
+	fmt.Printf("%*s│ ... %d lines omitted (use --full to see everything) ...\n", width, "", len(lines)-head-tail)
+	for i := len(lines) - tail; i < len(lines); i++ {
+		printLine(i)
+	}
+}
+
+// DiffStopSequences end generation at the closing fence of a diff so the model
+// doesn't append explanations that trip containsUnwantedContent. Send them with
+// any request built from GetEditPrompt.
+var DiffStopSequences = []string{"\n```\n"}
+
+// editExample is a short, language-appropriate diff shown to the model so the
+// format guidance matches the file being edited
+type editExample struct {
+	Name string // Language name used in the prompt
+	Hunk string // Hunk body after the @@ line
+	At   string // The @@ line
+}
+
+// editExamples are keyed by the language names agent.LanguageForFile returns
+var editExamples = map[string]editExample{
+	"go": {Name: "Go", At: "@@ -7,3 +7,4 @@", Hunk: ` func main() {
+ 	cmd.RootCmd()
++	// Hello world
+ }`},
+	"python": {Name: "Python", At: "@@ -7,2 +7,3 @@", Hunk: ` def main():
++    # Hello world
+     run()`},
+	"javascript": {Name: "JavaScript", At: "@@ -7,3 +7,4 @@", Hunk: ` function main() {
++  // Hello world
+   run();
+ }`},
+	"typescript": {Name: "TypeScript", At: "@@ -7,3 +7,4 @@", Hunk: ` function main(): void {
++  // Hello world
+   run();
+ }`},
+	"java": {Name: "Java", At: "@@ -7,3 +7,4 @@", Hunk: `     public static void main(String[] args) {
++        // Hello world
+         run();
+     }`},
+	"rust": {Name: "Rust", At: "@@ -7,3 +7,4 @@", Hunk: ` fn main() {
++    // Hello world
+     run();
+ }`},
+	"ruby": {Name: "Ruby", At: "@@ -7,3 +7,4 @@", Hunk: ` def main
++  # Hello world
+   run
+ end`},
+	"php": {Name: "PHP", At: "@@ -7,3 +7,4 @@", Hunk: ` function main() {
++    // Hello world
+     run();
+ }`},
+	"c": {Name: "C", At: "@@ -7,3 +7,4 @@", Hunk: ` int main(void) {
++    /* Hello world */
+     return run();
+ }`},
+	"cpp": {Name: "C++", At: "@@ -7,3 +7,4 @@", Hunk: ` int main() {
++    // Hello world
+     return run();
+ }`},
+	"csharp": {Name: "C#", At: "@@ -7,3 +7,4 @@", Hunk: `     static void Main(string[] args) {
++        // Hello world
+         Run();
+     }`},
+	"bash": {Name: "shell", At: "@@ -7,2 +7,3 @@", Hunk: ` main() {
++  # Hello world
+   run`},
+}
+
+// genericEditExample is used for languages without their own example
+var genericEditExample = editExample{At: "@@ -7,2 +7,3 @@", Hunk: ` first unchanged line
++added line
+ second unchanged line`}
+
+// GetEditPrompt asks for a unified diff editing filePath. language is the
+// file's language as returned by agent.LanguageForFile and picks the example
+// diff, so the model isn't shown Go when editing Python.
+func GetEditPrompt(filePath, language, content, editRequest string) string {
+	example, ok := editExamples[language]
+	fileKind := "file"
+	if ok {
+		fileKind = example.Name + " file"
+	} else {
+		example = genericEditExample
+	}
+
+	return fmt.Sprintf(`TASK: Edit the %s "%s" by making the requested change.
 
 CURRENT FILE CONTENT:
 %s
@@ -63,13 +260,10 @@ REQUIREMENTS:
 EXAMPLE FORMAT (replace with actual changes):
 --- %s
 +++ %s
-@@ -7,1 +7,2 @@
- func main() {
- 	cmd.RootCmd()
-+	// HEllo world
- }
+%s
+%s
 
-RESPOND WITH ONLY THE DIFF - NO OTHER TEXT:`, filePath, content, editRequest, filePath, filePath)
+RESPOND WITH ONLY THE DIFF - NO OTHER TEXT:`, fileKind, filePath, content, editRequest, filePath, filePath, example.At, example.Hunk)
 }
 
 func GetGeneratePrompt(filePath, requirements string) string {
@@ -88,32 +282,146 @@ func CreateFileWithContent(filePath, content string) error {
 	return WriteFile(filePath, content)
 }
 
+// BackupDir is where timestamped backups of edited files are kept
+const BackupDir = ".silent-code/backups"
+
+// SessionBackup records the first backup taken of a file during this session,
+// which holds the file's pre-session state
+type SessionBackup struct {
+	FilePath   string
+	BackupPath string
+	CreatedAt  time.Time
+}
+
+// Backups taken during the current session, in the order files were first touched
+var (
+	sessionBackups   []SessionBackup
+	sessionBackupsMu sync.Mutex
+)
+
+// backupBase returns the path inside BackupDir that backups of filePath share
+func backupBase(filePath string) string {
+	rel := filepath.Clean(filePath)
+	if filepath.IsAbs(rel) {
+		if cwd, err := os.Getwd(); err == nil {
+			if r, err := filepath.Rel(cwd, rel); err == nil && !strings.HasPrefix(r, "..") {
+				rel = r
+			}
+		}
+	}
+
+	// Keep backups of files outside the project inside BackupDir
+	rel = strings.TrimPrefix(rel, filepath.VolumeName(rel))
+	rel = strings.TrimLeft(rel, string(filepath.Separator))
+	rel = strings.ReplaceAll(rel, "..", "__")
+
+	return filepath.Join(BackupDir, rel)
+}
+
 func BackupFile(filePath string) error {
+	_, err := backupFile(filePath)
+	return err
+}
+
+// backupFile writes a timestamped backup of filePath and returns it
+func backupFile(filePath string) (SessionBackup, error) {
 	if !FileExists(filePath) {
-		return fmt.Errorf("file %s does not exist", filePath)
+		return SessionBackup{}, fmt.Errorf("file %s does not exist", filePath)
 	}
 
-	backupPath := filePath + ".backup"
-	content, err := ReadFile(filePath)
-	if err != nil {
-		return err
+	now := time.Now()
+	backupPath := fmt.Sprintf("%s.%s.backup", backupBase(filePath), now.Format("20060102-150405.000000"))
+	if err := copyFile(filePath, backupPath); err != nil {
+		return SessionBackup{}, err
 	}
 
-	return WriteFile(backupPath, content)
+	recordSessionBackup(filePath, backupPath, now)
+	return SessionBackup{FilePath: filePath, BackupPath: backupPath, CreatedAt: now}, nil
 }
 
-func RestoreBackup(filePath string) error {
-	backupPath := filePath + ".backup"
-	if !FileExists(backupPath) {
-		return fmt.Errorf("backup file %s does not exist", backupPath)
+// BackupFiles backs up every file before a multi-file change and returns
+// the backups so the whole change can be rolled back with RestoreBackups.
+// Nothing is returned unless every file was backed up.
+func BackupFiles(filePaths []string) ([]SessionBackup, error) {
+	backups := make([]SessionBackup, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		backup, err := backupFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", filePath, err)
+		}
+		backups = append(backups, backup)
+	}
+	return backups, nil
+}
+
+// RestoreBackups restores each file to its backup, returning the files that
+// could not be restored
+func RestoreBackups(backups []SessionBackup) []string {
+	var failed []string
+	for _, backup := range backups {
+		if err := RestoreSessionBackup(backup); err != nil {
+			failed = append(failed, backup.FilePath)
+		}
+	}
+	return failed
+}
+
+// recordSessionBackup remembers the first backup of each file this session
+func recordSessionBackup(filePath, backupPath string, createdAt time.Time) {
+	sessionBackupsMu.Lock()
+	defer sessionBackupsMu.Unlock()
+
+	for _, backup := range sessionBackups {
+		if backup.FilePath == filePath {
+			return
+		}
 	}
 
-	content, err := ReadFile(backupPath)
+	sessionBackups = append(sessionBackups, SessionBackup{
+		FilePath:   filePath,
+		BackupPath: backupPath,
+		CreatedAt:  createdAt,
+	})
+}
+
+// SessionBackups returns the pre-session backups of every file backed up this session
+func SessionBackups() []SessionBackup {
+	sessionBackupsMu.Lock()
+	defer sessionBackupsMu.Unlock()
+
+	return append([]SessionBackup{}, sessionBackups...)
+}
+
+// latestBackup returns the most recent timestamped backup of a file
+func latestBackup(filePath string) (string, error) {
+	matches, err := filepath.Glob(backupBase(filePath) + ".*.backup")
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no backup found for %s", filePath)
+	}
+
+	// Timestamps sort lexically, so the last match is the newest
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+func RestoreBackup(filePath string) error {
+	backupPath, err := latestBackup(filePath)
 	if err != nil {
 		return err
 	}
 
-	return WriteFile(filePath, content)
+	return copyFile(backupPath, filePath)
+}
+
+// RestoreSessionBackup restores a file to the state recorded in a session backup
+func RestoreSessionBackup(backup SessionBackup) error {
+	if err := copyFile(backup.BackupPath, backup.FilePath); err != nil {
+		return fmt.Errorf("failed to restore backup %s: %w", backup.BackupPath, err)
+	}
+	return nil
 }
 
 func PromptUser(prompt string) (string, error) {
@@ -125,7 +433,26 @@ func PromptUser(prompt string) (string, error) {
 	return strings.TrimSpace(scanner.Text()), nil
 }
 
+// autoApply skips confirmation prompts for trusted, scripted workflows.
+// It can be enabled with SILENT_CODE_YES=1, --yes, or the auto_apply config setting.
+var autoApply = os.Getenv("SILENT_CODE_YES") == "1"
+
+// SetAutoApply turns confirmation-free auto-apply mode on or off
+func SetAutoApply(enabled bool) {
+	autoApply = enabled
+}
+
+// AutoApply reports whether confirmation prompts are being skipped
+func AutoApply() bool {
+	return autoApply
+}
+
 func ConfirmAction(prompt string) (bool, error) {
+	if autoApply {
+		fmt.Printf("%sy (auto-apply)\n", prompt)
+		return true, nil
+	}
+
 	response, err := PromptUser(prompt)
 	if err != nil {
 		return false, err
@@ -148,6 +475,23 @@ type Hunk struct {
 	Lines    []Line
 }
 
+// String formats the hunk in unified diff form
+func (h Hunk) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", h.OldStart, h.OldCount, h.NewStart, h.NewCount)
+	for _, line := range h.Lines {
+		prefix := " "
+		switch line.Type {
+		case Addition:
+			prefix = "+"
+		case Deletion:
+			prefix = "-"
+		}
+		out.WriteString(prefix + line.Content + "\n")
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
 type Line struct {
 	Type    LineType
 	Content string
@@ -173,8 +517,12 @@ func ParseDiff(diffContent string) (*Diff, error) {
 	for i, line := range lines {
 		lineNumber = i + 1
 
-		// Skip empty lines
+		// Inside a hunk a blank line is context for a blank line in the file,
+		// whose leading space models often drop; elsewhere it's skipped
 		if strings.TrimSpace(line) == "" {
+			if currentHunk != nil {
+				currentHunk.Lines = append(currentHunk.Lines, Line{Type: Context, Number: lineNumber})
+			}
 			continue
 		}
 
@@ -194,7 +542,7 @@ func ParseDiff(diffContent string) (*Diff, error) {
 		// Parse hunk header
 		if strings.HasPrefix(line, "@@") {
 			if currentHunk != nil {
-				diff.Hunks = append(diff.Hunks, *currentHunk)
+				diff.Hunks = append(diff.Hunks, trimHunkPadding(*currentHunk))
 			}
 
 			hunk, err := parseHunkHeader(line)
@@ -210,19 +558,9 @@ func ParseDiff(diffContent string) (*Diff, error) {
 			continue
 		}
 
-		var lineType LineType
-		var content string
-
-		if strings.HasPrefix(line, "+") {
-			lineType = Addition
-			content = line[1:]
-		} else if strings.HasPrefix(line, "-") {
-			lineType = Deletion
-			content = line[1:]
-		} else {
-			lineType = Context
-			content = line[1:]
-		}
+		// Models often drop the space in front of context lines, so only
+		// a marker that is actually there is removed
+		lineType, content := splitDiffLine(line)
 
 		currentHunk.Lines = append(currentHunk.Lines, Line{
 			Type:    lineType,
@@ -233,12 +571,51 @@ func ParseDiff(diffContent string) (*Diff, error) {
 
 	// Add the last hunk
 	if currentHunk != nil {
-		diff.Hunks = append(diff.Hunks, *currentHunk)
+		diff.Hunks = append(diff.Hunks, trimHunkPadding(*currentHunk))
 	}
 
 	return diff, nil
 }
 
+// trimHunkPadding drops blank context lines at the end of a hunk that go
+// beyond the old line count its header declares, such as the blank lines
+// between hunks or after the diff, which would otherwise repeat file lines
+func trimHunkPadding(hunk Hunk) Hunk {
+	oldLines := 0
+	for _, line := range hunk.Lines {
+		if line.Type != Addition {
+			oldLines++
+		}
+	}
+	for oldLines > hunk.OldCount && len(hunk.Lines) > 0 {
+		last := hunk.Lines[len(hunk.Lines)-1]
+		if last.Type != Context || last.Content != "" {
+			break
+		}
+		hunk.Lines = hunk.Lines[:len(hunk.Lines)-1]
+		oldLines--
+	}
+	return hunk
+}
+
+// splitDiffLine separates a diff body line into its type and content. The
+// leading marker is only removed when there is one: a context line is
+// supposed to start with a space, but one without it keeps every character.
+func splitDiffLine(line string) (LineType, string) {
+	if line == "" {
+		return Context, ""
+	}
+	switch line[0] {
+	case '+':
+		return Addition, line[1:]
+	case '-':
+		return Deletion, line[1:]
+	case ' ':
+		return Context, line[1:]
+	}
+	return Context, line
+}
+
 // parseHunkHeader parses a hunk header like "@@ -5,6 +5,10 @@"
 func parseHunkHeader(header string) (*Hunk, error) {
 	// Remove @@ markers
@@ -389,7 +766,12 @@ func applyHunk(lines []string, hunk Hunk) ([]string, error) {
 
 // ShowDiffPreview displays a formatted preview of the diff
 func ShowDiffPreview(filePath, diffContent string) error {
-	fmt.Printf("\n📋 Changes to be applied to %s:\n", filePath)
+	return ShowDiff(fmt.Sprintf("Changes to be applied to %s", filePath), diffContent)
+}
+
+// ShowDiff displays a formatted diff under the given title
+func ShowDiff(title, diffContent string) error {
+	fmt.Printf("\n📋 %s:\n", title)
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
 	lines := strings.Split(diffContent, "\n")
@@ -401,12 +783,13 @@ func ShowDiffPreview(filePath, diffContent string) error {
 			fmt.Printf("📍 %s\n", line)
 			continue
 		}
-		if strings.HasPrefix(line, "+") {
-			fmt.Printf("➕ %s\n", line[1:])
-		} else if strings.HasPrefix(line, "-") {
-			fmt.Printf("➖ %s\n", line[1:])
-		} else {
-			fmt.Printf("   %s\n", line)
+		switch lineType, content := splitDiffLine(line); lineType {
+		case Addition:
+			fmt.Printf("➕ %s\n", content)
+		case Deletion:
+			fmt.Printf("➖ %s\n", content)
+		default:
+			fmt.Printf("   %s\n", content)
 		}
 	}
 
@@ -414,8 +797,156 @@ func ShowDiffPreview(filePath, diffContent string) error {
 	return nil
 }
 
+// ErrTruncatedDiff is returned when a diff looks like it was cut off mid-response
+var ErrTruncatedDiff = errors.New("response appears truncated")
+
+// diffLooksTruncated reports whether a diff ends in a hunk with no body or
+// with fewer lines than its @@ header declares, which is what a response cut
+// off by the output or context limit looks like
+func diffLooksTruncated(diffContent string) bool {
+	var header *Hunk
+	body, oldSeen, newSeen := 0, 0, 0
+
+	for _, line := range strings.Split(diffContent, "\n") {
+		if strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ") {
+			continue
+		}
+		if strings.HasPrefix(line, "@@") {
+			if header != nil && body == 0 {
+				return true
+			}
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return false
+			}
+			header = hunk
+			body, oldSeen, newSeen = 0, 0, 0
+			continue
+		}
+		if header == nil {
+			continue
+		}
+
+		// Blank lines count as context since models often drop the leading space
+		switch {
+		case strings.HasPrefix(line, "+"):
+			newSeen++
+		case strings.HasPrefix(line, "-"):
+			oldSeen++
+		default:
+			oldSeen++
+			newSeen++
+		}
+		if strings.TrimSpace(line) != "" {
+			body++
+		}
+	}
+
+	if header == nil {
+		return false
+	}
+	return body == 0 || oldSeen < header.OldCount || newSeen < header.NewCount
+}
+
+// ApplyDiffToFileWithRetry applies a diff like ApplyDiffToFile, but when the
+// diff looks truncated it offers to call regenerate for a new response.
+// regenerate should raise num_predict, as ollama.TalkToOllamaWithHigherLimit
+// does, so the retry has room to finish.
+func ApplyDiffToFileWithRetry(filePath, diffContent string, regenerate func() (string, error)) error {
+	err := ApplyDiffToFile(filePath, diffContent)
+	if !errors.Is(err, ErrTruncatedDiff) || regenerate == nil {
+		return err
+	}
+
+	retry, confirmErr := ConfirmAction("❓ Retry the edit with a higher output limit? (y/N): ")
+	if confirmErr != nil {
+		return fmt.Errorf("failed to get confirmation: %w", confirmErr)
+	}
+	if !retry {
+		return err
+	}
+
+	newDiff, genErr := regenerate()
+	if genErr != nil {
+		return fmt.Errorf("failed to regenerate edit: %w", genErr)
+	}
+	return ApplyDiffToFile(filePath, newDiff)
+}
+
+// ErrDiffFailed is returned when neither the diff nor the changes extracted
+// from it could be applied to the file
+var ErrDiffFailed = errors.New("diff could not be applied")
+
+// GetFullFilePrompt asks for the complete modified file rather than a diff,
+// for when a diff from GetEditPrompt could not be applied
+func GetFullFilePrompt(filePath, language, content, editRequest string) string {
+	fileKind := "file"
+	if example, ok := editExamples[language]; ok {
+		fileKind = example.Name + " file"
+	}
+
+	return fmt.Sprintf(`TASK: Edit the %s "%s" by making the requested change.
+
+CURRENT FILE CONTENT:
+%s
+
+CHANGE REQUESTED: %s
+
+Return the COMPLETE modified file, from the first line to the last, in a single code block.
+Do NOT return a diff and do NOT write any explanations.`, fileKind, filePath, content, editRequest)
+}
+
+// fencedBlockRegex matches a fenced code block in any language
+var fencedBlockRegex = regexp.MustCompile("```[\\w+#.-]*[ \\t]*\\n([\\s\\S]*?)```")
+
+// extractFullFile returns the largest fenced code block in a response, or
+// the whole response when it has none
+func extractFullFile(response string) string {
+	longest := ""
+	for _, match := range fencedBlockRegex.FindAllStringSubmatch(response, -1) {
+		if len(match[1]) > len(longest) {
+			longest = match[1]
+		}
+	}
+	if longest == "" {
+		return strings.TrimSpace(response) + "\n"
+	}
+	return longest
+}
+
+// ApplyDiffToFileWithFallback applies a diff like ApplyDiffToFileWithRetry,
+// which calls regenerate for a truncated diff. When neither the diff nor the
+// changes extracted from it can be applied, it calls regenerateFile for the
+// complete modified file (see GetFullFilePrompt) and applies that instead,
+// previewing a locally computed diff first.
+func ApplyDiffToFileWithFallback(filePath, diffContent string, regenerate, regenerateFile func() (string, error)) error {
+	err := ApplyDiffToFileWithRetry(filePath, diffContent, regenerate)
+	if !errors.Is(err, ErrDiffFailed) || regenerateFile == nil {
+		return err
+	}
+
+	fmt.Printf("⚠️  %v\n", err)
+	fmt.Println("🔄 Asking the AI for the complete file instead...")
+	response, genErr := regenerateFile()
+	if genErr != nil {
+		return fmt.Errorf("failed to regenerate file: %w", genErr)
+	}
+
+	content, readErr := ReadFile(filePath)
+	if readErr != nil {
+		return fmt.Errorf("failed to read file: %w", readErr)
+	}
+	return replaceFileContent(filePath, content, extractFullFile(response))
+}
+
 // ApplyDiffToFile is the complete workflow for applying diffs
 func ApplyDiffToFile(filePath, diffContent string) error {
+	// A partial diff parses into hunks that would mangle the file
+	if diffLooksTruncated(diffContent) {
+		fmt.Println("⚠️  Response appears truncated (a hunk is incomplete), changes not applied")
+		return ErrTruncatedDiff
+	}
+
 	// Check if the response contains unwanted content
 	if containsUnwantedContent(diffContent) {
 		fmt.Printf("⚠️  Warning: AI returned unexpected content, attempting to extract changes manually...\n")
@@ -435,15 +966,30 @@ func ApplyDiffToFile(filePath, diffContent string) error {
 		return fmt.Errorf("failed to show preview: %w", err)
 	}
 
-	// Get user confirmation
-	confirm, err := ConfirmAction("\n❓ Do you want to apply these changes? (y/N): ")
-	if err != nil {
-		return fmt.Errorf("failed to get confirmation: %w", err)
-	}
+	// Get user confirmation; with several hunks they can also be picked one by one
+	if len(diff.Hunks) > 1 && !autoApply {
+		answer, err := PromptUser("\n❓ Do you want to apply these changes? (y/N, p to pick hunks): ")
+		if err != nil {
+			return fmt.Errorf("failed to get confirmation: %w", err)
+		}
+		switch strings.ToLower(answer) {
+		case "p":
+			return applyHunksInteractively(filePath, diff)
+		case "y", "yes":
+		default:
+			fmt.Println("❌ Changes not applied")
+			return nil
+		}
+	} else {
+		confirm, err := ConfirmAction("\n❓ Do you want to apply these changes? (y/N): ")
+		if err != nil {
+			return fmt.Errorf("failed to get confirmation: %w", err)
+		}
 
-	if !confirm {
-		fmt.Println("❌ Changes not applied")
-		return nil
+		if !confirm {
+			fmt.Println("❌ Changes not applied")
+			return nil
+		}
 	}
 
 	// Create backup
@@ -457,109 +1003,95 @@ func ApplyDiffToFile(filePath, diffContent string) error {
 		if restoreErr := RestoreBackup(filePath); restoreErr != nil {
 			return fmt.Errorf("failed to apply diff and restore backup: %w, restore error: %v", err, restoreErr)
 		}
-		return fmt.Errorf("failed to apply diff: %w", err)
+		return fmt.Errorf("%w: %v", ErrDiffFailed, err)
 	}
 
 	fmt.Printf("✅ Changes applied successfully to %s\n", filePath)
 	return nil
 }
 
-// applyChangesManually tries to extract and apply changes from malformed diff content
-func applyChangesManually(filePath, diffContent string) error {
-	// Read current file content
-	content, err := ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
-	}
-
-	lines := strings.Split(content, "\n")
+// applyHunksInteractively asks about each hunk in turn and applies the
+// accepted ones. Hunks keep their original line numbers, which stay valid
+// because ApplyDiff works from the last hunk to the first.
+func applyHunksInteractively(filePath string, diff *Diff) error {
+	var accepted []Hunk
+	acceptRest := autoApply
 
-	// Try to extract a complete file from the AI response
-	extractedContent, err := extractCompleteFileFromResponse(diffContent)
-	if err == nil && extractedContent != "" {
-		// Show preview of the complete file
-		fmt.Printf("\n📋 Complete file content from AI:\n")
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		extractedLines := strings.Split(extractedContent, "\n")
-		for i, line := range extractedLines {
-			fmt.Printf("%3d│ %s\n", i+1, line)
+	for i, hunk := range diff.Hunks {
+		if acceptRest {
+			accepted = append(accepted, hunk)
+			continue
 		}
-		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-		// Get user confirmation
-		confirm, err := ConfirmAction("\n❓ Do you want to replace the entire file with this content? (y/N): ")
+		ShowDiff(fmt.Sprintf("Hunk %d of %d in %s", i+1, len(diff.Hunks), filePath), hunk.String())
+		answer, err := PromptUser("❓ Apply this hunk? (y)es, (n)o, (a)ll remaining, (q)uit: ")
 		if err != nil {
 			return fmt.Errorf("failed to get confirmation: %w", err)
 		}
 
-		if !confirm {
-			fmt.Println("❌ Changes not applied")
-			return nil
-		}
-
-		// Create backup
-		if err := BackupFile(filePath); err != nil {
-			return fmt.Errorf("failed to create backup: %w", err)
+		stop := false
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			accepted = append(accepted, hunk)
+		case "a", "all":
+			accepted = append(accepted, hunk)
+			acceptRest = true
+		case "q", "quit":
+			stop = true
 		}
-
-		// Write the new content
-		if err := WriteFile(filePath, extractedContent); err != nil {
-			// Try to restore backup on failure
-			if restoreErr := RestoreBackup(filePath); restoreErr != nil {
-				return fmt.Errorf("failed to apply changes and restore backup: %w, restore error: %v", err, restoreErr)
-			}
-			return fmt.Errorf("failed to apply changes: %w", err)
+		if stop {
+			break
 		}
+	}
 
-		fmt.Printf("✅ File updated successfully: %s\n", filePath)
+	if len(accepted) == 0 {
+		fmt.Println("❌ Changes not applied")
 		return nil
 	}
 
-	// Fallback to line-by-line changes
-	diffLines := strings.Split(diffContent, "\n")
-	var changes []struct {
-		oldLine string
-		newLine string
+	if err := BackupFile(filePath); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
 	}
 
-	for _, line := range diffLines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
-			// This is a deletion line
-			oldLine := line[1:]
-			// Look for the corresponding + line
-			for _, nextLine := range diffLines {
-				nextLine = strings.TrimSpace(nextLine)
-				if strings.HasPrefix(nextLine, "+") && !strings.HasPrefix(nextLine, "+++") {
-					newLine := nextLine[1:]
-					changes = append(changes, struct {
-						oldLine string
-						newLine string
-					}{oldLine, newLine})
-					break
-				}
-			}
+	if err := ApplyDiff(filePath, &Diff{FilePath: diff.FilePath, Hunks: accepted}); err != nil {
+		if restoreErr := RestoreBackup(filePath); restoreErr != nil {
+			return fmt.Errorf("failed to apply diff and restore backup: %w, restore error: %v", err, restoreErr)
 		}
+		return fmt.Errorf("%w: %v", ErrDiffFailed, err)
 	}
 
-	// Apply changes
-	for _, change := range changes {
-		for i, line := range lines {
-			if strings.TrimSpace(line) == strings.TrimSpace(change.oldLine) {
-				lines[i] = change.newLine
-				break
-			}
-		}
+	fmt.Printf("✅ Applied %d of %d hunks to %s\n", len(accepted), len(diff.Hunks), filePath)
+	return nil
+}
+
+// applyChangesManually tries to extract and apply changes from malformed diff content
+func applyChangesManually(filePath, diffContent string) error {
+	// Read current file content
+	content, err := ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	// Try to extract a complete file from the AI response
+	extractedContent, err := extractCompleteFileFromResponse(diffContent)
+	if err == nil && extractedContent != "" {
+		return replaceFileContent(filePath, content, extractedContent)
+	}
+
+	// Fallback to matching the changed blocks against the file
+	newContent, unmatched := applyChangeGroups(content, parseChangeGroups(diffContent))
+	localDiff := GenerateDiff(content, newContent)
+	if localDiff == "" {
+		return fmt.Errorf("%w: could not match any of the AI's changes against %s", ErrDiffFailed, filePath)
+	}
+	if unmatched > 0 {
+		fmt.Printf("⚠️  Warning: %d change(s) could not be located in %s and were skipped\n", unmatched, filePath)
 	}
 
 	// Show preview of changes
-	fmt.Printf("\n📋 Manual changes to be applied to %s:\n", filePath)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	for _, change := range changes {
-		fmt.Printf("➖ %s\n", change.oldLine)
-		fmt.Printf("➕ %s\n", change.newLine)
+	if err := ShowDiffPreview(filePath, localDiff); err != nil {
+		return fmt.Errorf("failed to show preview: %w", err)
 	}
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
 	// Get user confirmation
 	confirm, err := ConfirmAction("\n❓ Do you want to apply these changes? (y/N): ")
@@ -572,13 +1104,46 @@ func applyChangesManually(filePath, diffContent string) error {
 		return nil
 	}
 
+	return writeWithBackup(filePath, newContent)
+}
+
+// replaceFileContent previews the changes between the current and a complete
+// new version of a file as a local diff, and writes it after confirmation
+func replaceFileContent(filePath, content, newContent string) error {
+	// Compute the real changes locally instead of showing the whole file
+	localDiff := GenerateDiff(content, newContent)
+	if localDiff == "" {
+		fmt.Println("⚠️  The AI returned the file without any changes")
+		return nil
+	}
+
+	if err := ShowDiffPreview(filePath, localDiff); err != nil {
+		return fmt.Errorf("failed to show preview: %w", err)
+	}
+
+	// Get user confirmation
+	confirm, err := ConfirmAction("\n❓ Do you want to replace the entire file with this content? (y/N): ")
+	if err != nil {
+		return fmt.Errorf("failed to get confirmation: %w", err)
+	}
+
+	if !confirm {
+		fmt.Println("❌ Changes not applied")
+		return nil
+	}
+
+	return writeWithBackup(filePath, newContent)
+}
+
+// writeWithBackup backs up a file, writes new content, and restores the
+// backup if the write fails
+func writeWithBackup(filePath, newContent string) error {
 	// Create backup
 	if err := BackupFile(filePath); err != nil {
 		return fmt.Errorf("failed to create backup: %w", err)
 	}
 
 	// Write the modified content
-	newContent := strings.Join(lines, "\n")
 	if err := WriteFile(filePath, newContent); err != nil {
 		// Try to restore backup on failure
 		if restoreErr := RestoreBackup(filePath); restoreErr != nil {
@@ -591,6 +1156,117 @@ func applyChangesManually(filePath, diffContent string) error {
 	return nil
 }
 
+// changeGroup is a run of removed lines followed by the lines that replace them.
+// Pure additions are placed after the anchor, the closest preceding context line.
+type changeGroup struct {
+	anchor   string
+	oldLines []string
+	newLines []string
+}
+
+// parseChangeGroups extracts change groups from loosely formatted diff output
+func parseChangeGroups(diffContent string) []changeGroup {
+	var groups []changeGroup
+	var current *changeGroup
+	lastContext := ""
+
+	flush := func() {
+		if current != nil {
+			groups = append(groups, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range strings.Split(diffContent, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "---") || strings.HasPrefix(trimmed, "+++") || strings.HasPrefix(trimmed, "@@") {
+			flush()
+			continue
+		}
+
+		// The marker is the line's first character, so an indented context
+		// line such as "\t-1" or "  ++i" isn't taken for a change
+		lineType, text := splitDiffLine(line)
+		switch lineType {
+		case Deletion:
+			// A removal after additions starts a new group
+			if current == nil || len(current.newLines) > 0 {
+				flush()
+				current = &changeGroup{anchor: lastContext}
+			}
+			current.oldLines = append(current.oldLines, text)
+		case Addition:
+			if current == nil {
+				current = &changeGroup{anchor: lastContext}
+			}
+			current.newLines = append(current.newLines, text)
+		default:
+			flush()
+			if trimmed != "" {
+				lastContext = trimmed
+			}
+		}
+	}
+	flush()
+
+	return groups
+}
+
+// applyChangeGroups applies each group to the content, matching lines while
+// ignoring surrounding whitespace. It returns the new content and the number
+// of groups that could not be located.
+func applyChangeGroups(content string, groups []changeGroup) (string, int) {
+	lines := strings.Split(content, "\n")
+	unmatched := 0
+
+	for _, group := range groups {
+		if len(group.oldLines) > 0 {
+			index := findLineBlock(lines, group.oldLines)
+			if index == -1 {
+				unmatched++
+				continue
+			}
+			updated := append([]string{}, lines[:index]...)
+			updated = append(updated, group.newLines...)
+			lines = append(updated, lines[index+len(group.oldLines):]...)
+			continue
+		}
+
+		// Pure addition: insert after the anchor line
+		index := -1
+		if group.anchor != "" {
+			index = findLineBlock(lines, []string{group.anchor})
+		}
+		if index == -1 {
+			unmatched++
+			continue
+		}
+		updated := append([]string{}, lines[:index+1]...)
+		updated = append(updated, group.newLines...)
+		lines = append(updated, lines[index+1:]...)
+	}
+
+	return strings.Join(lines, "\n"), unmatched
+}
+
+// findLineBlock returns the index where block appears in lines, comparing
+// trimmed lines, or -1 if it is not found
+func findLineBlock(lines, block []string) int {
+	for i := 0; i+len(block) <= len(lines); i++ {
+		match := true
+		for j, blockLine := range block {
+			if strings.TrimSpace(lines[i+j]) != strings.TrimSpace(blockLine) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
 // extractCompleteFileFromResponse tries to extract a complete Go file from AI response
 func extractCompleteFileFromResponse(content string) (string, error) {
 	// Look for code blocks first
@@ -816,47 +1492,56 @@ func min(a, b int) int {
 
 // ShowFilePreview displays a formatted preview of the new file content
 func ShowFilePreview(filePath, content string) error {
-	fmt.Printf("\n📄 New file: %s\n", filePath)
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-
-	lines := strings.Split(content, "\n")
-	for i, line := range lines {
-		fmt.Printf("%3d│ %s\n", i+1, line)
-	}
-
-	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
+	DisplayContent(fmt.Sprintf("New file: %s", filePath), content, false)
 	return nil
 }
 
-// CreateFileFromContent is the complete workflow for creating files from AI-generated content
-func CreateFileFromContent(filePath, content string) error {
-	// Parse and clean the content
-	cleanContent, err := ParseGeneratedContent(content)
-	if err != nil {
-		return fmt.Errorf("failed to parse generated content: %w", err)
-	}
+// StrictFileInstruction is appended to a generation prompt when the model
+// answered with prose instead of code
+const StrictFileInstruction = "Return ONLY the file content, no prose, no explanations, and no markdown outside a single code block."
+
+// FindStrayBackups returns the "<file>.backup" copies that versions before
+// BackupDir left next to the files they backed up, with their total size.
+// Only backups whose original file still sits next to them are returned, so
+// unrelated files that happen to end in .backup are left alone.
+// Hidden, dependency, and ignored directories aren't searched, but ignored
+// files are, since *.backup is often in .gitignore.
+func FindStrayBackups(root string) ([]string, int64, error) {
+	matcher := ignore.Load(root)
+	var backups []string
+	var size int64
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
 
-	// Show preview
-	if err := ShowFilePreview(filePath, cleanContent); err != nil {
-		return fmt.Errorf("failed to show preview: %w", err)
-	}
+		rel, relErr := filepath.Rel(root, path)
+		if relErr != nil || rel == "." {
+			return nil
+		}
 
-	// Get user confirmation
-	confirm, err := ConfirmAction("\n❓ Do you want to create this file? (y/N): ")
-	if err != nil {
-		return fmt.Errorf("failed to get confirmation: %w", err)
-	}
+		if d.IsDir() {
+			if ignore.SkipDir(d.Name()) || matcher.Match(filepath.ToSlash(rel), true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".backup") || !d.Type().IsRegular() {
+			return nil
+		}
+		if original, err := os.Stat(strings.TrimSuffix(path, ".backup")); err != nil || !original.Mode().IsRegular() {
+			return nil
+		}
 
-	if !confirm {
-		fmt.Println("❌ File not created")
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		backups = append(backups, path)
+		size += info.Size()
 		return nil
-	}
+	})
 
-	// Create the file
-	if err := CreateFileWithContent(filePath, cleanContent); err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-
-	fmt.Printf("✅ File created successfully: %s\n", filePath)
-	return nil
+	return backups, size, err
 }
@@ -0,0 +1,74 @@
+package fs
+
+import "testing"
+
+func TestParseDiffContextLines(t *testing.T) {
+	tests := []struct {
+		name string
+		diff string
+		want []Line
+	}{
+		{
+			name: "space-prefixed context",
+			diff: "--- a.go\n+++ a.go\n@@ -1,3 +1,3 @@\n func a() int {\n-\treturn 1\n+\treturn 2\n }\n",
+			want: []Line{
+				{Type: Context, Content: "func a() int {"},
+				{Type: Deletion, Content: "\treturn 1"},
+				{Type: Addition, Content: "\treturn 2"},
+				{Type: Context, Content: "}"},
+			},
+		},
+		{
+			name: "unprefixed context",
+			diff: "--- a.go\n+++ a.go\n@@ -1,3 +1,3 @@\nfunc a() int {\n-\treturn 1\n+\treturn 2\n}\n",
+			want: []Line{
+				{Type: Context, Content: "func a() int {"},
+				{Type: Deletion, Content: "\treturn 1"},
+				{Type: Addition, Content: "\treturn 2"},
+				{Type: Context, Content: "}"},
+			},
+		},
+		{
+			name: "empty context",
+			diff: "@@ -1,4 +1,4 @@\n a := 1\n\n \n-b := 2\n+b := 3\n",
+			want: []Line{
+				{Type: Context, Content: "a := 1"},
+				{Type: Context, Content: ""},
+				{Type: Context, Content: ""},
+				{Type: Deletion, Content: "b := 2"},
+				{Type: Addition, Content: "b := 3"},
+			},
+		},
+		{
+			name: "blank lines after the hunk",
+			diff: "@@ -1,2 +1,2 @@\n a := 1\n-b := 2\n+b := 3\n\n\n",
+			want: []Line{
+				{Type: Context, Content: "a := 1"},
+				{Type: Deletion, Content: "b := 2"},
+				{Type: Addition, Content: "b := 3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := ParseDiff(tt.diff)
+			if err != nil {
+				t.Fatalf("ParseDiff: %v", err)
+			}
+			if len(diff.Hunks) != 1 {
+				t.Fatalf("got %d hunks, want 1", len(diff.Hunks))
+			}
+
+			got := diff.Hunks[0].Lines
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d:\n%s", len(got), len(tt.want), diff.Hunks[0])
+			}
+			for i, want := range tt.want {
+				if got[i].Type != want.Type || got[i].Content != want.Content {
+					t.Errorf("line %d: got (%d, %q), want (%d, %q)", i, got[i].Type, got[i].Content, want.Type, want.Content)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSymbolNotFound is returned when a file has no declaration with the given name
+var ErrSymbolNotFound = errors.New("symbol not found")
+
+// ExtractSymbol returns the source of a named function, method, type, const,
+// or var declaration in a Go file, including its doc comment. A method can be
+// named on its own or as "Type.Method". A name declared in a const or var
+// block returns the whole block, since its members usually only make sense
+// together.
+func ExtractSymbol(filePath, symbolName string) (string, error) {
+	if filepath.Ext(filePath) != ".go" {
+		return "", fmt.Errorf("%s: symbol extraction only supports Go files", filePath)
+	}
+
+	content, err := ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filePath, content, parser.ParseComments)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+
+	receiver, name, isMethod := strings.Cut(symbolName, ".")
+	if !isMethod {
+		name = symbolName
+	}
+
+	for _, decl := range file.Decls {
+		start, end, ok := findSymbol(decl, receiver, name, isMethod)
+		if ok {
+			return content[fset.Position(start).Offset:fset.Position(end).Offset], nil
+		}
+	}
+
+	return "", fmt.Errorf("%s in %s: %w", symbolName, filePath, ErrSymbolNotFound)
+}
+
+// findSymbol returns the source range of decl, or of the part of it that
+// declares name
+func findSymbol(decl ast.Decl, receiver, name string, isMethod bool) (token.Pos, token.Pos, bool) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Name.Name != name {
+			return 0, 0, false
+		}
+		if isMethod && receiverName(d) != receiver {
+			return 0, 0, false
+		}
+		return withDoc(d.Doc, d.Pos()), d.End(), true
+
+	case *ast.GenDecl:
+		if isMethod {
+			return 0, 0, false
+		}
+		for _, spec := range d.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				if s.Name.Name != name {
+					continue
+				}
+				// Only the matching type from a grouped type declaration
+				if d.Lparen.IsValid() {
+					return withDoc(s.Doc, s.Pos()), s.End(), true
+				}
+				return withDoc(d.Doc, d.Pos()), d.End(), true
+			case *ast.ValueSpec:
+				for _, ident := range s.Names {
+					if ident.Name == name {
+						return withDoc(d.Doc, d.Pos()), d.End(), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// withDoc moves the start of a declaration back to its doc comment
+func withDoc(doc *ast.CommentGroup, pos token.Pos) token.Pos {
+	if doc != nil {
+		return doc.Pos()
+	}
+	return pos
+}
+
+// receiverName returns the type name of a method's receiver, without any
+// pointer or type parameters
+func receiverName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return ""
+	}
+	expr := fn.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch t := expr.(type) {
+	case *ast.IndexExpr:
+		expr = t.X
+	case *ast.IndexListExpr:
+		expr = t.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
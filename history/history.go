@@ -5,29 +5,163 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/muratbekj/silent-code/agent"
 )
 
+// LocalDir is the project-relative session directory used before history
+// moved to the user's home directory
+const LocalDir = "./history/sessions"
+
+// DefaultDir returns the user-level session directory shared by every
+// project, falling back to LocalDir when there is no home directory
+func DefaultDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return LocalDir
+	}
+	return filepath.Join(home, ".silent-code", "sessions")
+}
+
+// DefaultMaxSessions is how many conversations stay cached in memory by default
+const DefaultMaxSessions = 20
+
+// HistoryManager keeps conversations in memory and on disk. Its methods are
+// safe to call from several goroutines, such as the REPL and the handler
+// that saves the session on Ctrl+C.
 type HistoryManager struct {
-	HistoryDir string
-	Sessions   map[string]*agent.Conversation
+	HistoryDir  string
+	Sessions    map[string]*agent.Conversation
+	MaxSessions int // Cached conversations beyond this are evicted, least recently used first; 0 means no cap
+
+	recent     []string // Cached session IDs, most recently used last
+	pinned     string   // The active session, which is never evicted
+	memoryOnly bool     // Set once HistoryDir proved unwritable; sessions then live only in memory
+
+	mu sync.Mutex // Guards Sessions, recent, pinned, memoryOnly, and the session files
 }
 
 // NewHistoryManager creates a new history manager
 func NewHistoryManager(historyDir string) *HistoryManager {
 	return &HistoryManager{
-		HistoryDir: historyDir,
-		Sessions:   make(map[string]*agent.Conversation),
+		HistoryDir:  historyDir,
+		Sessions:    make(map[string]*agent.Conversation),
+		MaxSessions: DefaultMaxSessions,
+	}
+}
+
+// MemoryOnly reports whether history has stopped being saved to disk
+func (hm *HistoryManager) MemoryOnly() bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.memoryOnly
+}
+
+// CheckWritable verifies HistoryDir can be written to, switching to
+// memory-only mode with a warning if it can't
+func (hm *HistoryManager) CheckWritable() error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if err := os.MkdirAll(hm.HistoryDir, 0755); err != nil {
+		hm.disablePersistence(err)
+		return err
 	}
+
+	probe, err := os.CreateTemp(hm.HistoryDir, ".write-check-*")
+	if err != nil {
+		hm.disablePersistence(err)
+		return err
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
 }
 
-// SaveSession saves a conversation to disk
+// disablePersistence switches to memory-only mode, warning the first time
+func (hm *HistoryManager) disablePersistence(err error) {
+	if hm.memoryOnly {
+		return
+	}
+	hm.memoryOnly = true
+	fmt.Printf("⚠️  History can't be saved to %s (%v); this session will be kept in memory only\n", hm.HistoryDir, err)
+	fmt.Println("💡 Set SILENT_CODE_HISTORY_DIR or \"history_dir\" in config.json to a writable directory")
+}
+
+// PinSession keeps a session in memory regardless of the cap, replacing any
+// previously pinned session
+func (hm *HistoryManager) PinSession(sessionID string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.pinned = sessionID
+}
+
+// cacheSession stores a conversation in memory as the most recently used
+// and evicts the least recently used ones beyond MaxSessions
+func (hm *HistoryManager) cacheSession(sessionID string, conversation *agent.Conversation) {
+	hm.Sessions[sessionID] = conversation
+	hm.touch(sessionID)
+
+	for hm.MaxSessions > 0 && len(hm.Sessions) > hm.MaxSessions {
+		evicted := false
+		for i, id := range hm.recent {
+			if id == hm.pinned || id == sessionID {
+				continue
+			}
+			delete(hm.Sessions, id)
+			hm.recent = append(hm.recent[:i], hm.recent[i+1:]...)
+			evicted = true
+			break
+		}
+		if !evicted {
+			break
+		}
+	}
+}
+
+// touch marks a cached session as the most recently used
+func (hm *HistoryManager) touch(sessionID string) {
+	hm.forget(sessionID)
+	hm.recent = append(hm.recent, sessionID)
+}
+
+// forget drops a session from the recency order
+func (hm *HistoryManager) forget(sessionID string) {
+	for i, id := range hm.recent {
+		if id == sessionID {
+			hm.recent = append(hm.recent[:i], hm.recent[i+1:]...)
+			return
+		}
+	}
+}
+
+// SaveSession saves a conversation to disk. If the history directory can't
+// be written, it warns once and keeps the conversation in memory instead.
 func (hm *HistoryManager) SaveSession(sessionID string, conversation *agent.Conversation) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.saveSession(sessionID, conversation)
+}
+
+// saveSession is SaveSession for callers holding hm.mu
+func (hm *HistoryManager) saveSession(sessionID string, conversation *agent.Conversation) error {
+	// Update in-memory sessions
+	hm.cacheSession(sessionID, conversation)
+
+	if hm.memoryOnly {
+		return nil
+	}
+
 	// Ensure history directory exists
 	if err := os.MkdirAll(hm.HistoryDir, 0755); err != nil {
-		return fmt.Errorf("failed to create history directory: %w", err)
+		hm.disablePersistence(err)
+		return nil
 	}
 
 	// Create session file path
@@ -39,21 +173,60 @@ func (hm *HistoryManager) SaveSession(sessionID string, conversation *agent.Conv
 		return fmt.Errorf("failed to marshal conversation: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(sessionFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write session file: %w", err)
+	// Write to a temporary file and rename it over the session so a crash
+	// mid-write never leaves a truncated session behind
+	if err := writeFileAtomic(sessionFile, data); err != nil {
+		hm.disablePersistence(err)
 	}
 
-	// Update in-memory sessions
-	hm.Sessions[sessionID] = conversation
-
 	return nil
 }
 
+// writeFileAtomic replaces path with data via a temporary file and a rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// FlushSession writes a cached session to disk again, e.g. before exiting
+func (hm *HistoryManager) FlushSession(sessionID string) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation, exists := hm.Sessions[sessionID]
+	if !exists {
+		return nil
+	}
+	return hm.saveSession(sessionID, conversation)
+}
+
 // LoadSession loads a conversation from disk
 func (hm *HistoryManager) LoadSession(sessionID string) (*agent.Conversation, error) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.loadSession(sessionID)
+}
+
+// loadSession is LoadSession for callers holding hm.mu
+func (hm *HistoryManager) loadSession(sessionID string) (*agent.Conversation, error) {
 	// Check if already in memory
 	if conv, exists := hm.Sessions[sessionID]; exists {
+		hm.touch(sessionID)
 		return conv, nil
 	}
 
@@ -71,32 +244,46 @@ func (hm *HistoryManager) LoadSession(sessionID string) (*agent.Conversation, er
 	}
 
 	// Store in memory
-	hm.Sessions[sessionID] = &conversation
+	hm.cacheSession(sessionID, &conversation)
 
 	return &conversation, nil
 }
 
-// ListSessions returns all available session IDs
+// ListSessions returns all available session IDs, including ones only held
+// in memory
 func (hm *HistoryManager) ListSessions() ([]string, error) {
-	// Ensure history directory exists
-	if err := os.MkdirAll(hm.HistoryDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.listSessions()
+}
+
+// listSessions is ListSessions for callers holding hm.mu
+func (hm *HistoryManager) listSessions() ([]string, error) {
+	var sessions []string
+	if hm.memoryOnly {
+		for sessionID := range hm.Sessions {
+			sessions = append(sessions, sessionID)
+		}
 	}
 
 	// Read directory
 	entries, err := os.ReadDir(hm.HistoryDir)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read history directory: %w", err)
 	}
 
-	var sessions []string
 	for _, entry := range entries {
 		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
 			// Extract session ID from filename
 			name := entry.Name()
 			if len(name) > 8 && name[:8] == "session_" {
 				sessionID := name[8 : len(name)-5] // Remove "session_" prefix and ".json" suffix
-				sessions = append(sessions, sessionID)
+				if !slices.Contains(sessions, sessionID) {
+					sessions = append(sessions, sessionID)
+				}
 			}
 		}
 	}
@@ -106,38 +293,113 @@ func (hm *HistoryManager) ListSessions() ([]string, error) {
 
 // AddMessage adds a message to a session
 func (hm *HistoryManager) AddMessage(sessionID string, message agent.Message) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
 	// Load or create session
-	conversation, err := hm.LoadSession(sessionID)
-	if err != nil {
-		// Create new session if it doesn't exist
-		conversation = &agent.Conversation{
-			SessionID: sessionID,
-			CreatedAt: time.Now(),
-			Messages:  []agent.Message{},
-		}
-	}
+	conversation := hm.loadOrCreateSession(sessionID)
 
 	// Add message
 	conversation.Messages = append(conversation.Messages, message)
 
 	// Save updated session
-	return hm.SaveSession(sessionID, conversation)
+	return hm.saveSession(sessionID, conversation)
+}
+
+// PopLastMessage removes and returns the last message of a session
+func (hm *HistoryManager) PopLastMessage(sessionID string) (agent.Message, error) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation, err := hm.loadSession(sessionID)
+	if err != nil {
+		return agent.Message{}, err
+	}
+	if len(conversation.Messages) == 0 {
+		return agent.Message{}, fmt.Errorf("session %s has no messages", sessionID)
+	}
+
+	last := conversation.Messages[len(conversation.Messages)-1]
+	conversation.Messages = conversation.Messages[:len(conversation.Messages)-1]
+
+	return last, hm.saveSession(sessionID, conversation)
 }
 
 // GetSessionHistory returns all messages for a session
 func (hm *HistoryManager) GetSessionHistory(sessionID string) ([]agent.Message, error) {
-	conversation, err := hm.LoadSession(sessionID)
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation, err := hm.loadSession(sessionID)
 	if err != nil {
 		return nil, err
 	}
 
-	return conversation.Messages, nil
+	return slices.Clone(conversation.Messages), nil
+}
+
+// SetSummary records a summary that replaces a session's first count
+// messages in prompts. The messages themselves are kept.
+func (hm *HistoryManager) SetSummary(sessionID, summary string, count int) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation, err := hm.loadSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	conversation.Summary = summary
+	conversation.SummarizedCount = count
+	return hm.saveSession(sessionID, conversation)
+}
+
+// chatMessage is a message in the chat format Ollama and OpenAI accept
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatRoles are the roles the chat APIs accept
+var chatRoles = []string{"system", "user", "assistant", "tool"}
+
+// ExportMessages writes a session's messages to outPath as a JSON array of
+// {"role", "content"} objects, ready to send to Ollama's /api/chat or to use
+// as few-shot or fine-tuning data. Messages with other roles are left out.
+func (hm *HistoryManager) ExportMessages(sessionID, outPath string) error {
+	messages, err := hm.GetSessionHistory(sessionID)
+	if err != nil {
+		return err
+	}
+
+	chat := make([]chatMessage, 0, len(messages))
+	for _, message := range messages {
+		if !slices.Contains(chatRoles, message.Role) {
+			continue
+		}
+		chat = append(chat, chatMessage{Role: message.Role, Content: message.Content})
+	}
+
+	data, err := json.MarshalIndent(chat, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode messages: %w", err)
+	}
+	if dir := filepath.Dir(outPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(outPath, append(data, '\n'))
 }
 
 // DeleteSession removes a session from disk and memory
 func (hm *HistoryManager) DeleteSession(sessionID string) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
 	// Remove from memory
 	delete(hm.Sessions, sessionID)
+	hm.forget(sessionID)
 
 	// Remove from disk
 	sessionFile := filepath.Join(hm.HistoryDir, fmt.Sprintf("session_%s.json", sessionID))
@@ -148,3 +410,228 @@ func (hm *HistoryManager) DeleteSession(sessionID string) error {
 
 	return nil
 }
+
+// loadOrCreateSession loads a session, starting an empty one if it has no file yet
+func (hm *HistoryManager) loadOrCreateSession(sessionID string) *agent.Conversation {
+	conversation, err := hm.loadSession(sessionID)
+	if err != nil {
+		conversation = &agent.Conversation{
+			SessionID: sessionID,
+			CreatedAt: time.Now(),
+			Messages:  []agent.Message{},
+		}
+	}
+	return conversation
+}
+
+// normalizeTag lowercases and trims a tag so "Bugfix " and "bugfix" match
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// AddTag tags a session, saving it if the tag is new
+func (hm *HistoryManager) AddTag(sessionID, tag string) error {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		return fmt.Errorf("tag cannot be empty")
+	}
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation := hm.loadOrCreateSession(sessionID)
+	if slices.Contains(conversation.Tags, tag) {
+		return nil
+	}
+	conversation.Tags = append(conversation.Tags, tag)
+
+	return hm.saveSession(sessionID, conversation)
+}
+
+// RemoveTag removes a tag from a session
+func (hm *HistoryManager) RemoveTag(sessionID, tag string) error {
+	tag = normalizeTag(tag)
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	conversation, err := hm.loadSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	index := slices.Index(conversation.Tags, tag)
+	if index == -1 {
+		return fmt.Errorf("session %s is not tagged %q", sessionID, tag)
+	}
+	conversation.Tags = slices.Delete(conversation.Tags, index, index+1)
+
+	return hm.saveSession(sessionID, conversation)
+}
+
+// ListSessionsByTag returns the IDs of sessions carrying a tag
+func (hm *HistoryManager) ListSessionsByTag(tag string) ([]string, error) {
+	tag = normalizeTag(tag)
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	sessions, err := hm.listSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	var tagged []string
+	for _, sessionID := range sessions {
+		conversation, err := hm.loadSession(sessionID)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(conversation.Tags, tag) {
+			tagged = append(tagged, sessionID)
+		}
+	}
+
+	return tagged, nil
+}
+
+// BranchSession copies a session's messages and tags into a new session,
+// leaving the original untouched
+func (hm *HistoryManager) BranchSession(sourceID, branchID string) (*agent.Conversation, error) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	source := hm.loadOrCreateSession(sourceID)
+
+	branch := &agent.Conversation{
+		SessionID:    branchID,
+		CreatedAt:    time.Now(),
+		Messages:     append([]agent.Message{}, source.Messages...),
+		Tags:         append([]string(nil), source.Tags...),
+		BranchedFrom: sourceID,
+
+		Summary:         source.Summary,
+		SummarizedCount: source.SummarizedCount,
+	}
+
+	if err := hm.saveSession(branchID, branch); err != nil {
+		return nil, err
+	}
+	return branch, nil
+}
+
+// MigrateSessions copies session files from another directory into
+// HistoryDir, skipping any that already exist there, and returns how many
+// were copied. The source files are left in place.
+func (hm *HistoryManager) MigrateSessions(fromDir string) (int, error) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if filepath.Clean(fromDir) == filepath.Clean(hm.HistoryDir) {
+		return 0, nil
+	}
+
+	sessionFiles, err := filepath.Glob(filepath.Join(fromDir, "session_*.json"))
+	if err != nil || len(sessionFiles) == 0 {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(hm.HistoryDir, 0755); err != nil {
+		return 0, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	copied := 0
+	for _, source := range sessionFiles {
+		target := filepath.Join(hm.HistoryDir, filepath.Base(source))
+		if _, err := os.Stat(target); err == nil {
+			continue
+		}
+
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return copied, fmt.Errorf("failed to read session file: %w", err)
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return copied, fmt.Errorf("failed to write session file: %w", err)
+		}
+		copied++
+	}
+
+	return copied, nil
+}
+
+// lockFile is the marker that a running process has a session open
+func (hm *HistoryManager) lockFile(sessionID string) string {
+	return filepath.Join(hm.HistoryDir, fmt.Sprintf("session_%s.lock", sessionID))
+}
+
+// LockSession marks a session as open by this process. The lock is removed
+// by UnlockSession on a clean exit, so a lock left behind by a process that
+// is no longer running means the session was interrupted.
+func (hm *HistoryManager) LockSession(sessionID string) error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	if hm.memoryOnly {
+		return nil
+	}
+	if err := os.MkdirAll(hm.HistoryDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(hm.lockFile(sessionID), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// UnlockSession removes the lock written by LockSession
+func (hm *HistoryManager) UnlockSession(sessionID string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	os.Remove(hm.lockFile(sessionID))
+}
+
+// InterruptedSessions returns the sessions whose lock was left by a process
+// that is no longer running, newest first. Stale locks on sessions with no
+// saved messages are removed since there is nothing to recover.
+func (hm *HistoryManager) InterruptedSessions() []string {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	lockFiles, err := filepath.Glob(filepath.Join(hm.HistoryDir, "session_*.lock"))
+	if err != nil {
+		return nil
+	}
+
+	var sessions []string
+	for _, lockFile := range lockFiles {
+		data, err := os.ReadFile(lockFile)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && processRunning(pid) {
+			continue
+		}
+
+		sessionID := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(lockFile), "session_"), ".lock")
+		if conversation, err := hm.loadSession(sessionID); err != nil || len(conversation.Messages) == 0 {
+			os.Remove(lockFile)
+			continue
+		}
+		sessions = append(sessions, sessionID)
+	}
+
+	slices.Reverse(sessions)
+	return sessions
+}
+
+// processRunning reports whether a process with the given PID exists
+func processRunning(pid int) bool {
+	if pid == os.Getpid() {
+		return true
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return process.Signal(syscall.Signal(0)) == nil
+}
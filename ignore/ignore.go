@@ -0,0 +1,177 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// FileName is the tool-specific ignore file, read alongside .gitignore
+const FileName = ".silent-codeignore"
+
+// Files lists the ignore files consulted, in the order their rules apply
+var Files = []string{".gitignore", FileName}
+
+// pattern is a single compiled gitignore rule
+type pattern struct {
+	regex   *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher decides whether project paths are excluded by ignore files
+type Matcher struct {
+	root     string
+	patterns []pattern
+}
+
+// Load reads .gitignore and .silent-codeignore from root. Rules from
+// .silent-codeignore are applied after .gitignore, so they can add to or
+// re-include (with !) paths that git ignores.
+func Load(root string) *Matcher {
+	m := &Matcher{root: root}
+
+	for _, name := range Files {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if p, ok := compilePattern(line); ok {
+				m.patterns = append(m.patterns, p)
+			}
+		}
+	}
+
+	return m
+}
+
+// compilePattern converts one gitignore line into a pattern
+func compilePattern(line string) (pattern, bool) {
+	line = strings.TrimRight(line, " \r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	p := pattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+
+	// Patterns containing a slash are relative to the root; others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '*' && strings.HasPrefix(line[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case c == '*' && strings.HasPrefix(line[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i:], ']')
+			if end == -1 {
+				expr.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := line[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return pattern{}, false
+	}
+	p.regex = regex
+	return p, true
+}
+
+// matchPath applies the rules to a single path; the last matching rule wins
+func (m *Matcher) matchPath(relPath string, isDir bool) bool {
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.regex.MatchString(relPath) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// Match reports whether relPath (relative to the matcher's root) is ignored,
+// either directly or because one of its parent directories is
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	if m == nil || len(m.patterns) == 0 {
+		return false
+	}
+
+	relPath = filepath.ToSlash(filepath.Clean(relPath))
+	relPath = strings.TrimPrefix(relPath, "./")
+	if relPath == "." || relPath == "" {
+		return false
+	}
+
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchPath(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+
+	return m.matchPath(relPath, isDir)
+}
+
+// MatchFile reports whether a file path is ignored. Absolute paths and paths
+// relative to the working directory are resolved against the matcher's root.
+func (m *Matcher) MatchFile(path string) bool {
+	if m == nil {
+		return false
+	}
+
+	rel := path
+	if absRoot, err := filepath.Abs(m.root); err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if r, err := filepath.Rel(absRoot, absPath); err == nil && !strings.HasPrefix(r, "..") {
+				rel = r
+			}
+		}
+	}
+
+	info, err := os.Stat(path)
+	isDir := err == nil && info.IsDir()
+	return m.Match(rel, isDir)
+}
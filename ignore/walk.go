@@ -0,0 +1,95 @@
+package ignore
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/muratbekj/silent-code/config"
+)
+
+// HeavyDirs are directories skipped by every walk: they hold version
+// control data, dependencies, or build output, and can be huge
+var HeavyDirs = []string{".git", "node_modules", "vendor", "target", "dist", "__pycache__"}
+
+// Limits cap how much of a tree a walk visits; zero means no limit
+type Limits struct {
+	MaxDepth int // Deepest directory level whose files are visited; files in the root are at depth 1
+	MaxFiles int // Files visited before the walk stops
+}
+
+// ConfiguredLimits returns the walk limits from the config
+func ConfiguredLimits() Limits {
+	cfg := config.Get()
+	return Limits{MaxDepth: cfg.MaxDepth, MaxFiles: cfg.MaxFiles}
+}
+
+// SkipDir reports whether a directory is never worth walking into
+func SkipDir(name string) bool {
+	if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+		return true
+	}
+	for _, heavy := range HeavyDirs {
+		if name == heavy {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk calls fn for every file under root that isn't ignored, skipping
+// hidden, heavy, and ignored directories. fn gets the file's path and its
+// slash-separated path relative to the matcher's root, which ignore rules
+// are matched against; it can return filepath.SkipAll to stop early. Walk
+// reports whether a limit cut the walk short.
+func (m *Matcher) Walk(root string, limits Limits, fn func(path, rel string, d fs.DirEntry) error) (bool, error) {
+	truncated := false
+	visited := 0
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		rel, relErr := filepath.Rel(m.root, path)
+		if relErr != nil {
+			rel = path
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			if SkipDir(d.Name()) || m.Match(rel, true) {
+				return filepath.SkipDir
+			}
+			if limits.MaxDepth > 0 && depth(root, path) >= limits.MaxDepth {
+				truncated = true
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if m.Match(rel, false) {
+			return nil
+		}
+
+		if limits.MaxFiles > 0 && visited >= limits.MaxFiles {
+			truncated = true
+			return filepath.SkipAll
+		}
+		visited++
+		return fn(path, rel, d)
+	})
+
+	return truncated, err
+}
+
+// depth returns how many directory levels path is below root
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(rel), "/") + 1
+}
@@ -0,0 +1,325 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/muratbekj/silent-code/agent"
+	"github.com/muratbekj/silent-code/ignore"
+)
+
+// Path is where the semantic index is stored, relative to the project root
+const Path = ".silent-code/index.json"
+
+// Chunking limits: files are embedded in blocks of chunkLines lines, each
+// cut to maxChunkTokens so it fits an embedding model's context
+const (
+	chunkLines     = 60
+	maxChunkTokens = 1500
+	maxFileSize    = 256 * 1024
+)
+
+// EmbedFunc turns texts into embedding vectors, one per input
+type EmbedFunc func(inputs []string) ([][]float64, error)
+
+// Chunk is an embedded block of lines from a file
+type Chunk struct {
+	StartLine int       `json:"start_line"`
+	EndLine   int       `json:"end_line"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// FileEntry records a file's chunks and the mtime they were embedded at
+type FileEntry struct {
+	ModTime time.Time `json:"mod_time"`
+	Chunks  []Chunk   `json:"chunks"`
+}
+
+// Index is the on-disk semantic index of a project
+type Index struct {
+	EmbedModel string                `json:"embed_model"`
+	BuiltAt    time.Time             `json:"built_at"`
+	Files      map[string]*FileEntry `json:"files"`
+}
+
+// Status describes how an index compares to the files on disk
+type Status struct {
+	Files     int
+	Size      int64
+	BuiltAt   time.Time
+	Model     string
+	Stale     []string // Indexed files modified since they were embedded
+	Deleted   []string // Indexed files that no longer exist
+	Unindexed []string // Files that would be indexed but aren't yet
+}
+
+// BuildStats summarizes an index build
+type BuildStats struct {
+	Embedded  int
+	Unchanged int
+	Removed   int
+	Truncated bool // A walk limit left some project files out
+}
+
+// Load reads the index under root. It returns os.ErrNotExist (wrapped) when
+// no index has been built.
+func Load(root string) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(root, Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index: %w", err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]*FileEntry)
+	}
+	return &idx, nil
+}
+
+// Save writes the index under root
+func (idx *Index) Save(root string) error {
+	path := filepath.Join(root, Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Clean deletes the index under root
+func Clean(root string) error {
+	err := os.Remove(filepath.Join(root, Path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// indexableFiles returns the project files worth embedding with their mtimes,
+// skipping hidden, ignored, binary, and oversized files. It reports whether
+// a walk limit left files out.
+func indexableFiles(root string) (map[string]time.Time, bool, error) {
+	matcher := ignore.Load(root)
+	files := make(map[string]time.Time)
+
+	truncated, err := matcher.Walk(root, ignore.ConfiguredLimits(), func(path, rel string, d fs.DirEntry) error {
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || info.Size() == 0 || info.Size() > maxFileSize {
+			return nil
+		}
+		files[rel] = info.ModTime()
+		return nil
+	})
+
+	return files, truncated, err
+}
+
+// chunkFile splits a text file into line blocks and the text to embed for each
+func chunkFile(root, rel string) ([]Chunk, []string, error) {
+	data, err := os.ReadFile(filepath.Join(root, rel))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.IndexByte(data, 0) != -1 {
+		return nil, nil, nil // Binary file
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var chunks []Chunk
+	var texts []string
+	for start := 0; start < len(lines); start += chunkLines {
+		end := min(start+chunkLines, len(lines))
+		body := strings.Join(lines[start:end], "\n")
+		if strings.TrimSpace(body) == "" {
+			continue
+		}
+
+		// The path helps queries that name a file or package
+		text := fmt.Sprintf("%s (lines %d-%d)\n%s", rel, start+1, end, body)
+		texts = append(texts, agent.TruncateToTokens(text, maxChunkTokens))
+		chunks = append(chunks, Chunk{StartLine: start + 1, EndLine: end})
+	}
+
+	return chunks, texts, nil
+}
+
+// Build creates or updates the index under root. Unless full is set, only
+// files whose mtime changed since the last build are re-embedded. A change
+// of embedding model always forces a full rebuild since vectors from
+// different models can't be compared.
+func Build(root, model string, full bool, embed EmbedFunc, progress func(rel string)) (*Index, *BuildStats, error) {
+	idx, err := Load(root)
+	if err != nil || full || idx.EmbedModel != model {
+		idx = &Index{Files: make(map[string]*FileEntry)}
+	}
+	idx.EmbedModel = model
+
+	files, truncated, err := indexableFiles(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := &BuildStats{Truncated: truncated}
+	for rel := range idx.Files {
+		if _, ok := files[rel]; !ok {
+			delete(idx.Files, rel)
+			stats.Removed++
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for rel := range files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	for _, rel := range paths {
+		modTime := files[rel]
+		if entry, ok := idx.Files[rel]; ok && entry.ModTime.Equal(modTime) {
+			stats.Unchanged++
+			continue
+		}
+
+		if progress != nil {
+			progress(rel)
+		}
+
+		chunks, texts, err := chunkFile(root, rel)
+		if err != nil {
+			continue
+		}
+
+		// Binary and blank files are recorded without chunks so they aren't retried
+		if len(chunks) > 0 {
+			vectors, err := embed(texts)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to embed %s: %w", rel, err)
+			}
+			for i := range chunks {
+				chunks[i].Embedding = vectors[i]
+			}
+		}
+
+		idx.Files[rel] = &FileEntry{ModTime: modTime, Chunks: chunks}
+		stats.Embedded++
+	}
+
+	idx.BuiltAt = time.Now()
+	if err := idx.Save(root); err != nil {
+		return nil, nil, fmt.Errorf("failed to save index: %w", err)
+	}
+
+	return idx, stats, nil
+}
+
+// GetStatus compares the index under root with the files on disk
+func GetStatus(root string) (*Status, error) {
+	idx, err := Load(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filepath.Join(root, Path))
+	if err != nil {
+		return nil, err
+	}
+
+	files, _, err := indexableFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Files:   len(idx.Files),
+		Size:    info.Size(),
+		BuiltAt: idx.BuiltAt,
+		Model:   idx.EmbedModel,
+	}
+	for rel, entry := range idx.Files {
+		modTime, ok := files[rel]
+		switch {
+		case !ok:
+			status.Deleted = append(status.Deleted, rel)
+		case !entry.ModTime.Equal(modTime):
+			status.Stale = append(status.Stale, rel)
+		}
+	}
+	for rel := range files {
+		if _, ok := idx.Files[rel]; !ok {
+			status.Unindexed = append(status.Unindexed, rel)
+		}
+	}
+	sort.Strings(status.Stale)
+	sort.Strings(status.Deleted)
+	sort.Strings(status.Unindexed)
+
+	return status, nil
+}
+
+// Result is a chunk matched by a semantic query
+type Result struct {
+	File      string
+	StartLine int
+	EndLine   int
+	Score     float64
+}
+
+// Query returns the k chunks most similar to the query vector
+func (idx *Index) Query(vector []float64, k int) []Result {
+	var results []Result
+	for rel, entry := range idx.Files {
+		for _, chunk := range entry.Chunks {
+			results = append(results, Result{
+				File:      rel,
+				StartLine: chunk.StartLine,
+				EndLine:   chunk.EndLine,
+				Score:     cosineSimilarity(vector, chunk.Embedding),
+			})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
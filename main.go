@@ -1,22 +1,11 @@
 package main
 
 import (
-	"time"
-
 	"github.com/muratbekj/silent-code/cmd"
-	"github.com/muratbekj/silent-code/mcp"
 )
 
 func main() {
-	// Start MCP server in background
-	go func() {
-		mcp.StartServer()
-	}()
-
-	// Give the server time to start up
-	time.Sleep(2 * time.Second)
-
-	// Start the main application
+	// Start the main application; it runs the MCP server too
 	cmd.RootCmd()
 
 	// Hello world comment at the end of the file
@@ -0,0 +1,79 @@
+package mcp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheDir holds cached explain_code and analyze_code answers
+const CacheDir = ".silent-code/cache"
+
+// cacheEntry is a generated answer stored on disk
+type cacheEntry struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	Response  string    `json:"response"`
+}
+
+// responseCacheKey hashes the model and the full prompt. The prompt embeds
+// the file contents and the question, so editing the file or asking
+// something else naturally misses the cache.
+func responseCacheKey(model, prompt string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + prompt))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateCached returns a cached answer for prompt when one exists and
+// useCache is set, and otherwise generates one and stores it. It reports
+// whether the answer came from the cache.
+func generateCached(ollamaClient *OllamaClient, prompt string, useCache bool) (string, bool, error) {
+	path := filepath.Join(CacheDir, responseCacheKey(ollamaClient.Model, prompt)+".json")
+
+	if useCache {
+		if data, err := os.ReadFile(path); err == nil {
+			var entry cacheEntry
+			if json.Unmarshal(data, &entry) == nil {
+				return entry.Response, true, nil
+			}
+		}
+	}
+
+	response, err := ollamaClient.Generate(prompt)
+	if err != nil {
+		return "", false, err
+	}
+
+	// A failed write only costs a cache miss next time
+	if data, err := json.Marshal(cacheEntry{Model: ollamaClient.Model, CreatedAt: time.Now(), Response: response}); err == nil {
+		if os.MkdirAll(CacheDir, 0755) == nil {
+			os.WriteFile(path, data, 0644)
+		}
+	}
+
+	return response, false, nil
+}
+
+// useCache reads the optional "no_cache" argument; caching is on by default
+func useCache(params map[string]interface{}) bool {
+	noCache, _ := params["no_cache"].(bool)
+	return !noCache
+}
+
+// ClearCache deletes every cached answer and returns how many were removed
+func ClearCache() (int, error) {
+	entries, err := filepath.Glob(filepath.Join(CacheDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if err := os.Remove(entry); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
@@ -1,45 +1,164 @@
 package mcp
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+// lastRequestID numbers JSON-RPC requests so concurrent calls can be told apart
+var lastRequestID atomic.Int64
+
 type MCPClient struct {
-	BaseURL string
-	Client  *http.Client
+	BaseURL      string
+	Client       *http.Client
+	ShowProgress bool   // Show a spinner while a tool call is in flight
+	Model        string // Sent with every tool call so the server generates with it; empty uses the server's model
 }
 
 type ToolResult struct {
-	Success bool   `json:"success"`
-	Content string `json:"content,omitempty"`
-	Message string `json:"message,omitempty"`
-	Error   string `json:"error,omitempty"`
-	Output  string `json:"output,omitempty"`
-	Stderr  string `json:"stderr,omitempty"`
-	Command string `json:"command,omitempty"`
+	Success       bool          `json:"success"`
+	Content       string        `json:"content,omitempty"`
+	Message       string        `json:"message,omitempty"`
+	Error         string        `json:"error,omitempty"`
+	Output        string        `json:"output,omitempty"`
+	Stderr        string        `json:"stderr,omitempty"`
+	Command       string        `json:"command,omitempty"`
+	TimedOut      bool          `json:"timed_out,omitempty"`
+	Signal        string        `json:"signal,omitempty"`
+	ExitCode      int           `json:"exit_code"` // -1 when the command was killed by the timeout
+	LinesAdded    int           `json:"lines_added,omitempty"`
+	LinesRemoved  int           `json:"lines_removed,omitempty"`
+	LinesChanged  int           `json:"lines_changed,omitempty"`
+	NoChange      bool          `json:"no_change,omitempty"`     // The edit left the file as it was
+	NoCode        bool          `json:"no_code,omitempty"`       // The generated file still had no code after a stricter retry
+	Cached        bool          `json:"cached,omitempty"`        // The answer came from the response cache
+	Continuations int           `json:"continuations,omitempty"` // Times a cut-off file was continued
+	Blocks        []string      `json:"blocks,omitempty"`        // Code blocks of a preview whose response had several
+	Report        *TestReport   `json:"report,omitempty"`
+	Matches       []SearchMatch `json:"matches,omitempty"`
+}
+
+// Idle connections a client keeps open to the server between calls
+const (
+	maxIdleConns    = 16
+	idleConnTimeout = 90 * time.Second
+)
+
+// newTransport returns a transport that keeps connections to the server
+// open between calls, enough of them for a call, its progress stream, and
+// background jobs to run at once
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.IdleConnTimeout = idleConnTimeout
+	return transport
 }
 
+// NewMCPClient returns a client with its own connection pool. Copies of the
+// client share the pool, so create one and copy it rather than calling this
+// for every command.
 func NewMCPClient(baseURL string) *MCPClient {
 	return &MCPClient{
-		BaseURL: baseURL,
-		Client:  &http.Client{Timeout: 150 * time.Second}, // Increased to 150 seconds
+		BaseURL:      baseURL,
+		Client:       &http.Client{Transport: newTransport(), Timeout: 150 * time.Second}, // Increased to 150 seconds
+		ShowProgress: true,
 	}
 }
 
-func (c *MCPClient) CallTool(toolName string, params map[string]interface{}) (*ToolResult, error) {
+// SetServerModel changes the model the server generates with when a tool
+// call doesn't name one
+func (c *MCPClient) SetServerModel(model string) error {
+	_, err := c.call("config/set_model", map[string]interface{}{"model": model})
+	return err
+}
+
+// ServerInfo fetches the server's configuration from its /info endpoint
+func (c *MCPClient) ServerInfo() (*ServerInfo, error) {
+	resp, err := c.Client.Get(c.BaseURL + "/info")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach MCP server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MCP server returned status %d", resp.StatusCode)
+	}
+
+	var info ServerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode server info: %w", err)
+	}
+	return &info, nil
+}
+
+// SubscribeProgress streams the server's progress notifications from
+// /events until ctx is cancelled
+func (c *MCPClient) SubscribeProgress(ctx context.Context) (<-chan ProgressParams, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/events", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stream stays open for the whole call, so it can't share the request timeout
+	resp, err := (&http.Client{Transport: c.Client.Transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("MCP server returned status %d", resp.StatusCode)
+	}
+
+	events := make(chan ProgressParams, 16)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var notification ProgressNotification
+			if err := json.Unmarshal([]byte(data), &notification); err != nil || notification.Method != progressMethod {
+				continue
+			}
+			select {
+			case events <- notification.Params:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// call sends a JSON-RPC request to the MCP server and returns its result
+func (c *MCPClient) call(method string, params interface{}) (interface{}, error) {
+	return c.callWithID(int(lastRequestID.Add(1)), method, params)
+}
+
+// callWithID is call with a request ID chosen by the caller, so progress
+// notifications for the request can be matched up before it is sent
+func (c *MCPClient) callWithID(id int, method string, params interface{}) (interface{}, error) {
 	req := MCPRequest{
 		JSONRPC: "2.0",
-		ID:      1,
-		Method:  "tools/call",
-		Params: map[string]interface{}{
-			"name":      toolName,
-			"arguments": params,
-		},
+		ID:      json.RawMessage(strconv.Itoa(id)),
+		Method:  method,
+		Params:  params,
 	}
 
 	jsonData, err := json.Marshal(req)
@@ -63,12 +182,117 @@ func (c *MCPClient) CallTool(toolName string, params map[string]interface{}) (*T
 		return nil, err
 	}
 
+	if !bytes.Equal(mcpResp.ID, req.ID) {
+		return nil, fmt.Errorf("MCP response id %s does not match request id %s", mcpResp.ID, req.ID)
+	}
+
 	if mcpResp.Error != nil {
 		return nil, fmt.Errorf("MCP error: %s", mcpResp.Error.Message)
 	}
 
+	return mcpResp.Result, nil
+}
+
+// ParamNames returns the names of the arguments a tool accepts, sorted, with
+// optional ones marked by a trailing "?"
+func (t ToolInfo) ParamNames() []string {
+	properties, _ := t.InputSchema["properties"].(map[string]interface{})
+	required := map[string]bool{}
+	if names, ok := t.InputSchema["required"].([]interface{}); ok {
+		for _, name := range names {
+			if s, ok := name.(string); ok {
+				required[s] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		if !required[name] {
+			name += "?"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListTools returns the tools the MCP server exposes
+func (c *MCPClient) ListTools() ([]ToolInfo, error) {
+	raw, err := c.call("tools/list", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-trip through JSON to decode the tool list
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Tools []ToolInfo `json:"tools"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid response format")
+	}
+
+	return result.Tools, nil
+}
+
+// addModel sets the model argument of a tool call to the client's model,
+// unless the call already names one
+func (c *MCPClient) addModel(params map[string]interface{}) {
+	if _, ok := params["model"]; !ok && c.Model != "" {
+		params["model"] = c.Model
+	}
+}
+
+func (c *MCPClient) CallTool(toolName string, params map[string]interface{}) (*ToolResult, error) {
+	id := int(lastRequestID.Add(1))
+	c.addModel(params)
+	logToolCall(toolName, params)
+
+	stopSpinner := func() {}
+	if c.ShowProgress {
+		// Show the server's progress for this call in the spinner when it
+		// supports /events; otherwise the spinner runs without it
+		var status atomic.Value
+		status.Store("")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if events, err := c.SubscribeProgress(ctx); err == nil {
+			go func() {
+				for event := range events {
+					if event.ProgressToken == id {
+						status.Store("· " + event.Message)
+					}
+				}
+			}()
+		}
+
+		stopSpinner = startSpinnerWithStatus(fmt.Sprintf("Running %s...", toolName), func() string {
+			return status.Load().(string)
+		})
+	}
+
+	start := time.Now()
+	raw, err := c.callWithID(id, "tools/call", map[string]interface{}{
+		"name":      toolName,
+		"arguments": params,
+	})
+	stopSpinner()
+	logToolResult(toolName, raw, err, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	return toolResultFrom(raw)
+}
+
+// toolResultFrom decodes the result of a tools/call request
+func toolResultFrom(raw interface{}) (*ToolResult, error) {
 	// Parse the result
-	result, ok := mcpResp.Result.(map[string]interface{})
+	result, ok := raw.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid response format")
 	}
@@ -95,15 +319,141 @@ func (c *MCPClient) CallTool(toolName string, params map[string]interface{}) (*T
 	if command, ok := result["command"].(string); ok {
 		toolResult.Command = command
 	}
+	if timedOut, ok := result["timed_out"].(bool); ok {
+		toolResult.TimedOut = timedOut
+	}
+	if signal, ok := result["signal"].(string); ok {
+		toolResult.Signal = signal
+	}
+	if exitCode, ok := result["exit_code"].(float64); ok {
+		toolResult.ExitCode = int(exitCode)
+	}
+	if continuations, ok := result["continuations"].(float64); ok {
+		toolResult.Continuations = int(continuations)
+	}
+	if blocks, ok := result["blocks"].([]interface{}); ok {
+		for _, block := range blocks {
+			if text, ok := block.(string); ok {
+				toolResult.Blocks = append(toolResult.Blocks, text)
+			}
+		}
+	}
+	if added, ok := result["lines_added"].(float64); ok {
+		toolResult.LinesAdded = int(added)
+	}
+	if removed, ok := result["lines_removed"].(float64); ok {
+		toolResult.LinesRemoved = int(removed)
+	}
+	if changed, ok := result["lines_changed"].(float64); ok {
+		toolResult.LinesChanged = int(changed)
+	}
+	if cached, ok := result["cached"].(bool); ok {
+		toolResult.Cached = cached
+	}
+	if noChange, ok := result["no_change"].(bool); ok {
+		toolResult.NoChange = noChange
+	}
+	if noCode, ok := result["no_code"].(bool); ok {
+		toolResult.NoCode = noCode
+	}
+	if report, ok := result["report"]; ok && report != nil {
+		// Round-trip through JSON to decode the nested report
+		if reportJSON, err := json.Marshal(report); err == nil {
+			var testReport TestReport
+			if err := json.Unmarshal(reportJSON, &testReport); err == nil {
+				toolResult.Report = &testReport
+			}
+		}
+	}
+
+	if matches, ok := result["matches"]; ok && matches != nil {
+		if matchesJSON, err := json.Marshal(matches); err == nil {
+			json.Unmarshal(matchesJSON, &toolResult.Matches)
+		}
+	}
 
 	return toolResult, nil
 }
 
 // Convenience methods for each tool
-func (c *MCPClient) CreateFile(filePath, requirements string) (*ToolResult, error) {
+func (c *MCPClient) CreateFile(filePath, requirements string, overwrite bool) (*ToolResult, error) {
 	return c.CallTool("create_file", map[string]interface{}{
 		"file_path":    filePath,
 		"requirements": requirements,
+		"overwrite":    overwrite,
+	})
+}
+
+// lateChunkWait is how long PreviewFile waits for streamed chunks that
+// arrive after the result
+const lateChunkWait = time.Second
+
+// PreviewFile generates a file like CreateFile but doesn't write it; the
+// content is returned in the result for WriteGeneratedFile. With onChunk set,
+// the content is passed to it as it is generated.
+func (c *MCPClient) PreviewFile(filePath, requirements string, overwrite bool, onChunk func(string)) (*ToolResult, error) {
+	params := map[string]interface{}{
+		"file_path":    filePath,
+		"requirements": requirements,
+		"overwrite":    overwrite,
+		"preview":      true,
+	}
+	if onChunk == nil {
+		return c.CallTool("create_file", params)
+	}
+
+	id := int(lastRequestID.Add(1))
+	c.addModel(params)
+	logToolCall("create_file", params)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.SubscribeProgress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			if event.ProgressToken != id {
+				continue
+			}
+			if event.Chunk != "" {
+				onChunk(event.Chunk)
+			}
+			if event.Done {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	raw, err := c.callWithID(id, "tools/call", map[string]interface{}{
+		"name":      "create_file",
+		"arguments": params,
+	})
+	if err != nil {
+		logToolResult("create_file", raw, err, time.Since(start))
+		return nil, err
+	}
+
+	// The last chunks can arrive just after the result
+	select {
+	case <-done:
+	case <-time.After(lateChunkWait):
+	}
+	logToolResult("create_file", raw, err, time.Since(start))
+
+	return toolResultFrom(raw)
+}
+
+// WriteGeneratedFile writes content returned by PreviewFile
+func (c *MCPClient) WriteGeneratedFile(filePath, content string, overwrite bool) (*ToolResult, error) {
+	return c.CallTool("create_file", map[string]interface{}{
+		"file_path": filePath,
+		"content":   content,
+		"overwrite": overwrite,
 	})
 }
 
@@ -120,21 +470,102 @@ func (c *MCPClient) ReadFile(filePath string) (*ToolResult, error) {
 	})
 }
 
-func (c *MCPClient) AnalyzeCode(filePath, question string) (*ToolResult, error) {
+// ReadFileWithLineNumbers reads a file with each line prefixed by its number
+func (c *MCPClient) ReadFileWithLineNumbers(filePath string) (*ToolResult, error) {
+	return c.CallTool("read_file", map[string]interface{}{
+		"file_path":    filePath,
+		"line_numbers": true,
+	})
+}
+
+// CodeOptions are the optional settings for analyze_code and explain_code
+type CodeOptions struct {
+	Depth       int    // Levels of imported project files to include (explain only)
+	LineNumbers bool   // Number the code so the model can cite lines
+	NoCache     bool   // Regenerate instead of reusing a cached answer
+	Symbol      string // Only explain this declaration of a Go file (explain only)
+}
+
+// AnalyzeCode answers a question about a file
+func (c *MCPClient) AnalyzeCode(filePath, question string, opts CodeOptions) (*ToolResult, error) {
 	return c.CallTool("analyze_code", map[string]interface{}{
-		"file_path": filePath,
-		"question":  question,
+		"file_path":    filePath,
+		"question":     question,
+		"line_numbers": opts.LineNumbers,
+		"no_cache":     opts.NoCache,
 	})
 }
 
-func (c *MCPClient) ExplainCode(filePath string) (*ToolResult, error) {
+// ExplainCode explains a file; a depth above zero also includes the project
+// files it imports, up to that many levels deep
+func (c *MCPClient) ExplainCode(filePath string, opts CodeOptions) (*ToolResult, error) {
 	return c.CallTool("explain_code", map[string]interface{}{
-		"file_path": filePath,
+		"file_path":    filePath,
+		"depth":        opts.Depth,
+		"line_numbers": opts.LineNumbers,
+		"no_cache":     opts.NoCache,
+		"symbol":       opts.Symbol,
 	})
 }
 
 func (c *MCPClient) ExecuteShell(command string) (*ToolResult, error) {
-	return c.CallTool("execute_shell", map[string]interface{}{
+	return c.ExecuteShellWithOptions(command, ShellOptions{})
+}
+
+// ExecuteShellWithTimeout runs a shell command that the server kills after
+// timeout; zero uses the server's default
+func (c *MCPClient) ExecuteShellWithTimeout(command string, timeout time.Duration) (*ToolResult, error) {
+	return c.ExecuteShellWithOptions(command, ShellOptions{Timeout: timeout})
+}
+
+// ExecuteShellWithStdin runs a shell command with stdin written to its standard input
+func (c *MCPClient) ExecuteShellWithStdin(command, stdin string) (*ToolResult, error) {
+	return c.ExecuteShellWithOptions(command, ShellOptions{Stdin: stdin})
+}
+
+// ShellOptions are the optional settings for running a shell command
+type ShellOptions struct {
+	Timeout time.Duration     // Zero uses the server's default
+	Env     map[string]string // Set on top of the server's environment
+	Stdin   string            // Written to the command's standard input
+}
+
+// ExecuteShellWithOptions runs a shell command with a custom timeout and environment
+func (c *MCPClient) ExecuteShellWithOptions(command string, opts ShellOptions) (*ToolResult, error) {
+	params := map[string]interface{}{
 		"command": command,
+	}
+	if len(opts.Env) > 0 {
+		params["env"] = opts.Env
+	}
+	if opts.Stdin != "" {
+		params["stdin"] = opts.Stdin
+	}
+	if opts.Timeout > 0 {
+		params["timeout"] = opts.Timeout.Seconds()
+
+		// The HTTP request has to outlive the command
+		if c.Client.Timeout != 0 && c.Client.Timeout < opts.Timeout+30*time.Second {
+			extended := *c
+			client := *c.Client
+			client.Timeout = opts.Timeout + 30*time.Second
+			extended.Client = &client
+			return extended.CallTool("execute_shell", params)
+		}
+	}
+	return c.CallTool("execute_shell", params)
+}
+
+func (c *MCPClient) RunTests(path string) (*ToolResult, error) {
+	return c.CallTool("run_tests", map[string]interface{}{
+		"path": path,
+	})
+}
+
+func (c *MCPClient) SearchCode(query, path string, wholeWord bool) (*ToolResult, error) {
+	return c.CallTool("search_code", map[string]interface{}{
+		"query":      query,
+		"path":       path,
+		"whole_word": wholeWord,
 	})
 }
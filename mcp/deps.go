@@ -0,0 +1,159 @@
+package mcp
+
+import (
+	"bufio"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/muratbekj/silent-code/agent"
+	"github.com/muratbekj/silent-code/ignore"
+)
+
+// maxDependencyTokens caps how much dependency source a deep explanation includes
+const maxDependencyTokens = 6000
+
+// goModulePath returns the module path declared in the nearest go.mod above dir
+// and the directory containing it
+func goModulePath(dir string) (string, string) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", ""
+	}
+
+	for {
+		file, err := os.Open(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			scanner := bufio.NewScanner(file)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if strings.HasPrefix(line, "module ") {
+					file.Close()
+					return strings.TrimSpace(strings.TrimPrefix(line, "module ")), dir
+				}
+			}
+			file.Close()
+			return "", ""
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+// localImportDirs returns the project directories imported by a Go file
+func localImportDirs(filePath, modulePath, moduleRoot string) []string {
+	parsed, err := parser.ParseFile(token.NewFileSet(), filePath, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+
+	var dirs []string
+	for _, imp := range parsed.Imports {
+		importPath, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+		if importPath != modulePath && !strings.HasPrefix(importPath, modulePath+"/") {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(importPath, modulePath), "/")
+		dirs = append(dirs, filepath.Join(moduleRoot, rel))
+	}
+
+	return dirs
+}
+
+// packageSourceFiles lists the non-test Go files in a package directory
+func packageSourceFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files
+}
+
+// collectGoDependencies follows a Go file's in-project imports up to depth
+// levels and returns the dependency files with their (possibly truncated)
+// contents, keeping the total within maxDependencyTokens
+func collectGoDependencies(filePath string, depth int) ([]string, map[string]string) {
+	if depth <= 0 || !strings.HasSuffix(filePath, ".go") {
+		return nil, nil
+	}
+
+	modulePath, moduleRoot := goModulePath(filepath.Dir(filePath))
+	if modulePath == "" {
+		return nil, nil
+	}
+
+	absFile, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, nil
+	}
+
+	matcher := ignore.Load(moduleRoot)
+
+	visitedDirs := map[string]bool{filepath.Dir(absFile): true}
+	var order []string
+	contents := make(map[string]string)
+	budget := maxDependencyTokens
+
+	frontier := []string{absFile}
+	for level := 0; level < depth && len(frontier) > 0 && budget > 0; level++ {
+		var next []string
+		for _, file := range frontier {
+			for _, dir := range localImportDirs(file, modulePath, moduleRoot) {
+				if visitedDirs[dir] {
+					continue
+				}
+				visitedDirs[dir] = true
+
+				for _, depFile := range packageSourceFiles(dir) {
+					if budget <= 0 {
+						break
+					}
+
+					rel, err := filepath.Rel(moduleRoot, depFile)
+					if err != nil {
+						rel = depFile
+					}
+					if matcher.Match(rel, false) {
+						continue
+					}
+
+					data, err := os.ReadFile(depFile)
+					if err != nil {
+						continue
+					}
+
+					content := agent.TruncateToTokens(string(data), budget)
+					budget -= agent.EstimateTokens(content)
+
+					order = append(order, rel)
+					contents[rel] = content
+					next = append(next, depFile)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return order, contents
+}
@@ -0,0 +1,133 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// progressMethod is the MCP notification method for tool call progress
+const progressMethod = "notifications/progress"
+
+// ProgressNotification is a JSON-RPC notification streamed to /events
+// subscribers while a tool call runs
+type ProgressNotification struct {
+	JSONRPC string         `json:"jsonrpc"`
+	Method  string         `json:"method"`
+	Params  ProgressParams `json:"params"`
+}
+
+// ProgressParams reports progress on one request, identified by its ID
+type ProgressParams struct {
+	ProgressToken int    `json:"progressToken"` // The ID of the request making progress
+	Progress      int    `json:"progress"`      // Tokens generated so far
+	Message       string `json:"message"`
+	Chunk         string `json:"chunk,omitempty"` // Generated text since the last notification, for callers that stream it
+	Done          bool   `json:"done,omitempty"`  // Generation has finished
+}
+
+// Open /events streams; each gets every notification
+var (
+	subscribers   = make(map[chan ProgressNotification]struct{})
+	subscribersMu sync.Mutex
+)
+
+// hasSubscribers reports whether anyone is listening, so tool calls can
+// skip the cost of reporting progress otherwise
+func hasSubscribers() bool {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	return len(subscribers) > 0
+}
+
+// subscriberQueueSize is how many notifications wait for a slow subscriber.
+// Plain progress messages only fill half of it, leaving the rest for
+// generated chunks, which would garble the text if they were dropped.
+const subscriberQueueSize = 4096
+
+// emitProgress sends a progress notification to every subscriber. Slow
+// subscribers miss notifications rather than stalling the tool call.
+func emitProgress(token, progress int, message string) {
+	emit(ProgressParams{ProgressToken: token, Progress: progress, Message: message})
+}
+
+// emitChunk sends generated text to every subscriber
+func emitChunk(token, progress int, chunk string) {
+	emit(ProgressParams{ProgressToken: token, Progress: progress, Message: "generating...", Chunk: chunk})
+}
+
+// emit queues a notification for every subscriber without waiting on any;
+// one whose queue is full misses it
+func emit(params ProgressParams) {
+	if params.ProgressToken <= 0 {
+		return
+	}
+
+	notification := ProgressNotification{
+		JSONRPC: "2.0",
+		Method:  progressMethod,
+		Params:  params,
+	}
+	limit := subscriberQueueSize
+	if params.Chunk == "" && !params.Done {
+		limit /= 2
+	}
+
+	subscribersMu.Lock()
+	queues := make([]chan ProgressNotification, 0, len(subscribers))
+	for ch := range subscribers {
+		queues = append(queues, ch)
+	}
+	subscribersMu.Unlock()
+
+	for _, ch := range queues {
+		if len(ch) >= limit {
+			continue
+		}
+		select {
+		case ch <- notification:
+		default:
+		}
+	}
+}
+
+// handleEvents streams progress notifications as Server-Sent Events until
+// the client disconnects
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan ProgressNotification, subscriberQueueSize)
+	subscribersMu.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersMu.Unlock()
+	defer func() {
+		subscribersMu.Lock()
+		delete(subscribers, ch)
+		subscribersMu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case notification := <-ch:
+			data, err := json.Marshal(notification)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mcp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// spinnerDelay keeps fast tool calls from flashing a spinner
+const spinnerDelay = 300 * time.Millisecond
+
+// isTerminal reports whether stdout is an interactive terminal
+func isTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// startSpinner shows an animated spinner with elapsed time until the returned
+// function is called. The spinner is skipped entirely when stdout is not a TTY.
+func startSpinner(label string) func() {
+	return startSpinnerWithStatus(label, nil)
+}
+
+// startSpinnerWithStatus is startSpinner with a status line, polled on every
+// frame, shown after the label when non-empty
+func startSpinnerWithStatus(label string, status func() string) func() {
+	if !isTerminal() {
+		return func() {}
+	}
+
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+
+	go func() {
+		defer close(doneChan)
+
+		select {
+		case <-stopChan:
+			return
+		case <-time.After(spinnerDelay):
+		}
+
+		frames := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+		start := time.Now()
+		lineWidth := 0
+
+		for i := 0; ; i++ {
+			line := fmt.Sprintf("%s %s (%ds)", frames[i%len(frames)], label, int(time.Since(start).Seconds()))
+			if status != nil {
+				if text := status(); text != "" {
+					line += " " + text
+				}
+			}
+			// Clear leftovers when the status got shorter
+			line += strings.Repeat(" ", max(lineWidth-len([]rune(line)), 0))
+			lineWidth = len([]rune(line))
+			fmt.Print("\r" + line)
+
+			select {
+			case <-stopChan:
+				// Clear the spinner line so following output starts clean
+				fmt.Print("\r" + strings.Repeat(" ", lineWidth) + "\r")
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}()
+
+	return func() {
+		close(stopChan)
+		<-doneChan
+	}
+}
@@ -0,0 +1,207 @@
+package mcp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/muratbekj/silent-code/config"
+)
+
+// ToolHandler runs a tool call with its arguments. ollamaClient reports
+// progress for the call and is only needed by tools that generate text.
+type ToolHandler func(arguments map[string]interface{}, ollamaClient *OllamaClient) (interface{}, error)
+
+// ToolParam describes one argument a tool accepts
+type ToolParam struct {
+	Name        string
+	Type        string // JSON schema type: "string", "integer", "number", "boolean", "object", or "array"
+	Description string
+	Required    bool
+}
+
+// ToolSchema describes what a tool does and the arguments it accepts
+type ToolSchema struct {
+	Description string
+	Params      []ToolParam
+}
+
+// inputSchema returns the JSON schema for a tool's arguments, as sent in a
+// tools/list response
+func (s ToolSchema) inputSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, param := range s.Params {
+		properties[param.Name] = map[string]interface{}{
+			"type":        param.Type,
+			"description": param.Description,
+		}
+		if param.Required {
+			required = append(required, param.Name)
+		}
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+type registeredTool struct {
+	schema  ToolSchema
+	handler ToolHandler
+}
+
+var (
+	toolsMu   sync.RWMutex
+	tools     = map[string]registeredTool{}
+	toolOrder []string // Names in registration order, for tools/list
+)
+
+// RegisterTool adds a tool that tools/call can dispatch and tools/list
+// reports. It panics if the name is empty or already registered, like
+// http.Handle, since that's a programming error.
+func RegisterTool(name string, schema ToolSchema, handler ToolHandler) {
+	if name == "" || handler == nil {
+		panic("mcp: RegisterTool needs a name and a handler")
+	}
+
+	toolsMu.Lock()
+	defer toolsMu.Unlock()
+	if _, exists := tools[name]; exists {
+		panic(fmt.Sprintf("mcp: tool %q registered twice", name))
+	}
+	tools[name] = registeredTool{schema: schema, handler: handler}
+	toolOrder = append(toolOrder, name)
+}
+
+// lookupTool returns the handler registered for a tool
+func lookupTool(name string) (ToolHandler, bool) {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	tool, ok := tools[name]
+	return tool.handler, ok
+}
+
+// registeredTools describes every registered tool, in registration order
+func registeredTools() []ToolInfo {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	infos := make([]ToolInfo, 0, len(toolOrder))
+	for _, name := range toolOrder {
+		tool := tools[name]
+		infos = append(infos, ToolInfo{
+			Name:        name,
+			Description: tool.schema.Description,
+			InputSchema: tool.schema.inputSchema(),
+		})
+	}
+	return infos
+}
+
+// registeredToolNames returns the names of every registered tool
+func registeredToolNames() []string {
+	toolsMu.RLock()
+	defer toolsMu.RUnlock()
+	return append([]string(nil), toolOrder...)
+}
+
+// withTemperature wraps a handler so it generates with the temperature
+// configured for an operation such as config.OpEdit
+func withTemperature(op string, handler ToolHandler) ToolHandler {
+	return func(arguments map[string]interface{}, ollamaClient *OllamaClient) (interface{}, error) {
+		return handler(arguments, ollamaClient.withOperation(op))
+	}
+}
+
+// withoutModel adapts a handler that never calls the model
+func withoutModel(handler func(map[string]interface{}) (interface{}, error)) ToolHandler {
+	return func(arguments map[string]interface{}, _ *OllamaClient) (interface{}, error) {
+		return handler(arguments)
+	}
+}
+
+// Parameters shared by several built-in tools
+var (
+	filePathParam    = ToolParam{Name: "file_path", Type: "string", Description: "Path of the file", Required: true}
+	lineNumbersParam = ToolParam{Name: "line_numbers", Type: "boolean", Description: "Prefix each line with its number"}
+	noCacheParam     = ToolParam{Name: "no_cache", Type: "boolean", Description: "Ask the model again instead of using a cached answer"}
+	modelParam       = ToolParam{Name: "model", Type: "string", Description: "Ollama model to generate with instead of the server's"}
+)
+
+func init() {
+	RegisterTool("create_file", ToolSchema{
+		Description: "Generate a new file from requirements, or write given content",
+		Params: []ToolParam{
+			filePathParam,
+			{Name: "requirements", Type: "string", Description: "What the file should contain; required unless content is given"},
+			{Name: "overwrite", Type: "boolean", Description: "Replace the file if it exists, after backing it up"},
+			{Name: "preview", Type: "boolean", Description: "Return the generated content without writing it"},
+			{Name: "content", Type: "string", Description: "Content to write as is instead of generating it"},
+			modelParam,
+		},
+	}, withTemperature(config.OpGenerate, handleCreateFile))
+
+	RegisterTool("edit_file", ToolSchema{
+		Description: "Rewrite a file according to an edit request",
+		Params: []ToolParam{
+			filePathParam,
+			{Name: "edit_request", Type: "string", Description: "The change to make", Required: true},
+			modelParam,
+		},
+	}, withTemperature(config.OpEdit, handleEditFile))
+
+	RegisterTool("read_file", ToolSchema{
+		Description: "Read a file's contents",
+		Params:      []ToolParam{filePathParam, lineNumbersParam},
+	}, withoutModel(handleReadFile))
+
+	RegisterTool("analyze_code", ToolSchema{
+		Description: "Answer a question about a file",
+		Params: []ToolParam{
+			filePathParam,
+			{Name: "question", Type: "string", Description: "The question to answer", Required: true},
+			lineNumbersParam,
+			noCacheParam,
+			modelParam,
+		},
+	}, withTemperature(config.OpChat, handleAnalyzeCode))
+
+	RegisterTool("explain_code", ToolSchema{
+		Description: "Explain a file or one of its symbols, optionally with its imports",
+		Params: []ToolParam{
+			filePathParam,
+			{Name: "symbol", Type: "string", Description: "Explain only this function, method, or type"},
+			{Name: "depth", Type: "integer", Description: "Levels of imported local files to include"},
+			lineNumbersParam,
+			noCacheParam,
+			modelParam,
+		},
+	}, withTemperature(config.OpChat, handleExplainCode))
+
+	RegisterTool("execute_shell", ToolSchema{
+		Description: "Run a shell command",
+		Params: []ToolParam{
+			{Name: "command", Type: "string", Description: "The command to run", Required: true},
+			{Name: "timeout", Type: "integer", Description: "Seconds before the command is killed"},
+			{Name: "env", Type: "object", Description: "Extra environment variables"},
+			{Name: "stdin", Type: "string", Description: "Input written to the command"},
+		},
+	}, withoutModel(handleExecuteShell))
+
+	RegisterTool("run_tests", ToolSchema{
+		Description: "Run the project's tests and parse the results",
+		Params: []ToolParam{
+			{Name: "path", Type: "string", Description: "Directory to run the tests in"},
+		},
+	}, withoutModel(handleRunTests))
+
+	RegisterTool("search_code", ToolSchema{
+		Description: "Find lines matching a symbol or text across the project",
+		Params: []ToolParam{
+			{Name: "query", Type: "string", Description: "The symbol or text to find", Required: true},
+			{Name: "path", Type: "string", Description: "Directory to search"},
+			{Name: "whole_word", Type: "boolean", Description: "Only match whole words (default true)"},
+			{Name: "max_results", Type: "integer", Description: "Most matches to return"},
+		},
+	}, withoutModel(handleSearchCode))
+}
@@ -0,0 +1,193 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/muratbekj/silent-code/ignore"
+)
+
+// Limits that keep a project-wide search fast and its result small
+const (
+	defaultMaxSearchResults = 200
+	maxSearchFileSize       = 1 << 20
+)
+
+// SearchMatch is a single line matching a search_code query
+type SearchMatch struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	Kind string `json:"kind,omitempty"` // "definition" or "usage" for Go files, empty otherwise
+}
+
+// searchCode finds lines matching query under root, skipping ignored, hidden,
+// binary, and oversized files. It reports whether the result was cut at limit.
+func searchCode(root, query string, wholeWord bool, limit int) ([]SearchMatch, bool, error) {
+	pattern := regexp.QuoteMeta(query)
+	if wholeWord {
+		pattern = `\b` + pattern + `\b`
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, false, err
+	}
+
+	matcher := ignore.Load(root)
+	var matches []SearchMatch
+	truncated := false
+
+	walkTruncated, err := matcher.Walk(root, ignore.ConfiguredLimits(), func(path, rel string, d fs.DirEntry) error {
+		if strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		fileMatches := searchFile(path, filepath.FromSlash(rel), re, query)
+		for _, match := range fileMatches {
+			if len(matches) >= limit {
+				truncated = true
+				return filepath.SkipAll
+			}
+			matches = append(matches, match)
+		}
+		return nil
+	})
+
+	return matches, truncated || walkTruncated, err
+}
+
+// searchFile returns the matching lines of one file, labelling Go matches
+// as definitions or usages
+func searchFile(path, rel string, re *regexp.Regexp, symbol string) []SearchMatch {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > maxSearchFileSize {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || bytes.IndexByte(data[:min(len(data), 8000)], 0) != -1 {
+		return nil
+	}
+
+	var definitions map[int]bool
+	isGo := strings.HasSuffix(path, ".go")
+	if isGo {
+		definitions = goDefinitionLines(path, data, symbol)
+	}
+
+	var matches []SearchMatch
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if !re.MatchString(line) {
+			continue
+		}
+
+		match := SearchMatch{File: rel, Line: lineNumber, Text: strings.TrimSpace(line)}
+		if isGo {
+			match.Kind = "usage"
+			if definitions[lineNumber] {
+				match.Kind = "definition"
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	return matches
+}
+
+// goDefinitionLines returns the lines where a Go file declares symbol as a
+// function, method, type, constant, variable, or struct field
+func goDefinitionLines(path string, src []byte, symbol string) map[int]bool {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	lines := make(map[int]bool)
+	mark := func(ident *ast.Ident) {
+		if ident != nil && ident.Name == symbol {
+			lines[fset.Position(ident.Pos()).Line] = true
+		}
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.FuncDecl:
+			mark(node.Name)
+		case *ast.TypeSpec:
+			mark(node.Name)
+		case *ast.ValueSpec:
+			for _, name := range node.Names {
+				mark(name)
+			}
+		case *ast.Field:
+			for _, name := range node.Names {
+				mark(name)
+			}
+		}
+		return true
+	})
+
+	return lines
+}
+
+func handleSearchCode(params map[string]interface{}) (interface{}, error) {
+	query, ok := params["query"].(string)
+	if !ok || query == "" {
+		return nil, fmt.Errorf("query parameter is required")
+	}
+
+	root := "."
+	if path, ok := params["path"].(string); ok && path != "" {
+		root = path
+	}
+
+	wholeWord := true
+	if value, ok := params["whole_word"].(bool); ok {
+		wholeWord = value
+	}
+
+	limit := defaultMaxSearchResults
+	if value, ok := params["max_results"].(float64); ok && value > 0 {
+		limit = int(value)
+	}
+
+	matches, truncated, err := searchCode(root, query, wholeWord, limit)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Search failed: %v", err),
+		}, nil
+	}
+
+	files := make(map[string]bool)
+	for _, match := range matches {
+		files[match.File] = true
+	}
+
+	message := fmt.Sprintf("%d matches in %d files", len(matches), len(files))
+	if truncated {
+		message += " (results truncated)"
+	}
+
+	return map[string]interface{}{
+		"success":   true,
+		"matches":   matches,
+		"truncated": truncated,
+		"message":   message,
+	}, nil
+}
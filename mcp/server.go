@@ -4,45 +4,115 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/muratbekj/silent-code/config"
+	"github.com/muratbekj/silent-code/fs"
 )
 
 type OllamaClient struct {
 	BaseURL string
 	Model   string
 	Client  *http.Client
+
+	progressToken int      // ID of the request this client generates for, if it reports progress
+	streamChunks  bool     // Send the generated text itself with progress notifications
+	temperature   *float64 // Set per operation; nil leaves it to the model's default
+}
+
+// withOperation returns a copy of the client using the temperature
+// configured for an operation such as config.OpEdit
+func (o *OllamaClient) withOperation(op string) *OllamaClient {
+	client := *o
+	if temperature, ok := config.OperationTemperature(op); ok {
+		client.temperature = &temperature
+	}
+	return &client
+}
+
+// withModel returns a copy of the client that generates with another model
+func (o *OllamaClient) withModel(model string) *OllamaClient {
+	client := *o
+	client.Model = model
+	return &client
+}
+
+// withProgress returns a copy of the client that reports generation progress
+// for the given request
+func (o *OllamaClient) withProgress(token int) *OllamaClient {
+	client := *o
+	client.progressToken = token
+	return &client
+}
+
+// withChunks returns a copy of the client that streams generated text to
+// /events subscribers as well as the token count
+func (o *OllamaClient) withChunks() *OllamaClient {
+	client := *o
+	client.streamChunks = true
+	return &client
+}
+
+// progress reports a step of the current tool call to /events subscribers
+func (o *OllamaClient) progress(message string) {
+	emitProgress(o.progressToken, 0, message)
 }
 
 type OllamaRequest struct {
-	Model  string `json:"model"`
-	Prompt string `json:"prompt"`
-	Stream bool   `json:"stream"`
+	Model   string           `json:"model"`
+	Prompt  string           `json:"prompt"`
+	Stream  bool             `json:"stream"`
+	Options *GenerateOptions `json:"options,omitempty"`
+}
+
+// GenerateOptions are the model parameters sent with a generate request
+type GenerateOptions struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 type OllamaResponse struct {
 	Response string `json:"response"`
 	Done     bool   `json:"done"`
+	Error    string `json:"error,omitempty"`
 }
 
+// MCPRequest is a JSON-RPC request. ID is kept raw so string and numeric ids
+// are both accepted and echoed back exactly as sent
 type MCPRequest struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      int         `json:"id"`
-	Method  string      `json:"method"`
-	Params  interface{} `json:"params"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params"`
 }
 
 type MCPResponse struct {
-	JSONRPC string      `json:"jsonrpc"`
-	ID      int         `json:"id"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   *MCPError   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *MCPError       `json:"error,omitempty"`
+}
+
+// progressToken returns the request's ID as a progress token, or 0 when the
+// ID is not a positive integer and progress can't be reported for it
+func (r MCPRequest) progressToken() int {
+	var id int
+	if err := json.Unmarshal(r.ID, &id); err != nil || id <= 0 {
+		return 0
+	}
+	return id
 }
 
 type MCPError struct {
@@ -50,6 +120,64 @@ type MCPError struct {
 	Message string `json:"message"`
 }
 
+// ToolInfo describes a tool in a tools/list response
+type ToolInfo struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema,omitempty"` // JSON schema for the tool's arguments
+}
+
+// Version is the server version reported by the /info endpoint
+const Version = "0.1.0"
+
+// Where the MCP server listens and which model its tools generate with,
+// unless ServerOptions say otherwise
+const (
+	DefaultServerBind  = "127.0.0.1" // Loopback only; other machines need an explicit bind
+	DefaultServerPort  = 8080
+	DefaultServerModel = "codellama:13b"
+)
+
+// serverModel is the model tool calls generate with unless they name one.
+// config/set_model changes it while the server runs.
+var (
+	serverModelMu sync.RWMutex
+	serverModel   string
+)
+
+// currentServerModel returns the model tool calls generate with by default
+func currentServerModel() string {
+	serverModelMu.RLock()
+	defer serverModelMu.RUnlock()
+	return serverModel
+}
+
+// setServerModel changes the model tool calls generate with by default
+func setServerModel(model string) {
+	serverModelMu.Lock()
+	defer serverModelMu.Unlock()
+	serverModel = model
+}
+
+// ServerOptions configure StartServerWithOptions; zero values use the defaults
+type ServerOptions struct {
+	Bind  string // Host or IP to listen on; 0.0.0.0 listens on every interface
+	Port  int
+	Model string
+}
+
+// ServerInfo describes a running MCP server for clients that need to know
+// how it is configured
+type ServerInfo struct {
+	Model         string   `json:"model"`
+	Version       string   `json:"version"`
+	Address       string   `json:"address"`
+	WorkingDir    string   `json:"working_dir"`
+	SafeMode      bool     `json:"safe_mode"` // The server has no tool restrictions yet, so this is always false
+	Tools         []string `json:"tools"`
+	UptimeSeconds int64    `json:"uptime_seconds"`
+}
+
 func NewOllamaClient(baseURL, model string) *OllamaClient {
 	return &OllamaClient{
 		BaseURL: baseURL,
@@ -140,14 +268,25 @@ func detectLanguage(filePath string) string {
 }
 
 func (o *OllamaClient) Generate(prompt string) (string, error) {
+	return o.GenerateWithStop(prompt, nil)
+}
+
+// GenerateWithStop generates a completion that ends at any of the given stop sequences
+func (o *OllamaClient) GenerateWithStop(prompt string, stop []string) (string, error) {
 	// Add timeout context
 	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second) // Increased to 5 minutes
 	defer cancel()
 
+	// Stream only when someone is watching, to report tokens as they arrive
+	streaming := o.progressToken > 0 && hasSubscribers()
+
 	reqBody := OllamaRequest{
 		Model:  o.Model,
 		Prompt: prompt,
-		Stream: false,
+		Stream: streaming,
+	}
+	if len(stop) > 0 || o.temperature != nil {
+		reqBody.Options = &GenerateOptions{Temperature: o.temperature, Stop: stop}
 	}
 
 	jsonData, err := json.Marshal(reqBody)
@@ -168,6 +307,10 @@ func (o *OllamaClient) Generate(prompt string) (string, error) {
 	}
 	defer resp.Body.Close()
 
+	if streaming {
+		return o.readStream(resp.Body)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response: %w", err)
@@ -181,9 +324,74 @@ func (o *OllamaClient) Generate(prompt string) (string, error) {
 	return ollamaResp.Response, nil
 }
 
+// progressInterval is how many generated tokens pass between progress notifications
+const progressInterval = 20
+
+// readStream collects a streamed generation, reporting the token count to
+// /events subscribers as it grows
+func (o *OllamaClient) readStream(body io.Reader) (string, error) {
+	o.progress("generating...")
+
+	var response strings.Builder
+	decoder := json.NewDecoder(body)
+	tokens := 0
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", fmt.Errorf("failed to decode response: %w", err)
+		}
+		if chunk.Error != "" {
+			return "", fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+
+		response.WriteString(chunk.Response)
+		tokens++
+		if o.streamChunks && chunk.Response != "" {
+			emitChunk(o.progressToken, tokens, chunk.Response)
+		} else if tokens%progressInterval == 0 {
+			emitProgress(o.progressToken, tokens, fmt.Sprintf("generating... (%d tokens)", tokens))
+		}
+		if chunk.Done {
+			break
+		}
+	}
+
+	emit(ProgressParams{ProgressToken: o.progressToken, Progress: tokens, Message: fmt.Sprintf("generated %d tokens", tokens), Done: true})
+	return response.String(), nil
+}
+
+// StartServer runs the MCP server with the default options until it fails
 func StartServer() {
+	if err := StartServerWithOptions(ServerOptions{}); err != nil {
+		fmt.Printf("❌ Server error: %v\n", err)
+	}
+}
+
+// StartServerWithOptions runs the MCP server in the foreground, returning
+// only when it can't listen or stops serving
+func StartServerWithOptions(opts ServerOptions) error {
+	if opts.Bind == "" {
+		opts.Bind = DefaultServerBind
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultServerPort
+	}
+	if opts.Model == "" {
+		opts.Model = DefaultServerModel
+	}
+	addr := net.JoinHostPort(opts.Bind, strconv.Itoa(opts.Port))
+	host := opts.Bind
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+		fmt.Println("⚠️  Listening on every interface: anyone who can reach this machine can use the server's tools")
+	}
+
 	// Initialize Ollama client
-	ollamaClient := NewOllamaClient("http://localhost:11434", "codellama:13b")
+	setServerModel(opts.Model)
+	ollamaClient := NewOllamaClient("http://localhost:11434", opts.Model)
 
 	// HTTP server for MCP-like functionality
 	http.HandleFunc("/mcp", func(w http.ResponseWriter, r *http.Request) {
@@ -198,38 +406,76 @@ func StartServer() {
 			return
 		}
 
-		response := processMCPRequest(req, ollamaClient)
+		response := processMCPRequest(req, ollamaClient.withModel(currentServerModel()))
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	})
 
+	// Progress notifications for long tool calls, as Server-Sent Events
+	http.HandleFunc("/events", handleEvents)
+
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
 	})
 
+	startedAt := time.Now()
+	http.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		workingDir, _ := os.Getwd()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ServerInfo{
+			Model:         currentServerModel(),
+			Version:       Version,
+			Address:       addr,
+			WorkingDir:    workingDir,
+			Tools:         registeredToolNames(),
+			UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		})
+	})
+
 	// Add test endpoint
 	http.HandleFunc("/test", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"status": "test successful"})
 	})
 
-	fmt.Println("🚀 Starting Silent Code MCP Server on port 8080...")
+	fmt.Printf("🚀 Starting Silent Code MCP Server on port %d...\n", opts.Port)
 	fmt.Println("💡 Make sure Ollama is running on localhost:11434")
-	fmt.Println("🔧 Available tools: create_file, edit_file, read_file, analyze_code, execute_shell")
-	fmt.Println("📡 Server will start on http://localhost:8080")
+	fmt.Printf("🤖 Model: %s\n", opts.Model)
+	fmt.Printf("🔧 Available tools: %s\n", strings.Join(registeredToolNames(), ", "))
+	fmt.Printf("📡 Server will start on http://%s\n", net.JoinHostPort(host, strconv.Itoa(opts.Port)))
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		fmt.Printf("❌ Server error: %v\n", err)
-	}
+	return http.ListenAndServe(addr, nil)
 }
 
 func processMCPRequest(req MCPRequest, ollamaClient *OllamaClient) MCPResponse {
+	// Notifications (a missing or null ID) are not supported, so every request needs an ID
+	if req.JSONRPC != "2.0" || len(req.ID) == 0 || string(req.ID) == "null" {
+		return MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &MCPError{
+				Code:    -32600,
+				Message: "Invalid request: jsonrpc must be \"2.0\" and id present and not null",
+			},
+		}
+	}
+
 	switch req.Method {
 	case "tools/call":
 		return handleToolCall(req, ollamaClient)
+	case "tools/list":
+		return MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Result: map[string]interface{}{
+				"tools": registeredTools(),
+			},
+		}
+	case "config/set_model":
+		return handleSetModel(req)
 	default:
 		return MCPResponse{
 			JSONRPC: "2.0",
@@ -242,6 +488,33 @@ func processMCPRequest(req MCPRequest, ollamaClient *OllamaClient) MCPResponse {
 	}
 }
 
+// handleSetModel changes the model tool calls generate with by default, so
+// the server follows the model selected in the CLI
+func handleSetModel(req MCPRequest) MCPResponse {
+	params, _ := req.Params.(map[string]interface{})
+	model, _ := params["model"].(string)
+	if model == "" {
+		return MCPResponse{
+			JSONRPC: "2.0",
+			ID:      req.ID,
+			Error: &MCPError{
+				Code:    -32602,
+				Message: "Missing model",
+			},
+		}
+	}
+
+	setServerModel(model)
+	return MCPResponse{
+		JSONRPC: "2.0",
+		ID:      req.ID,
+		Result: map[string]interface{}{
+			"success": true,
+			"message": fmt.Sprintf("Model set to %s", model),
+		},
+	}
+}
+
 func handleToolCall(req MCPRequest, ollamaClient *OllamaClient) MCPResponse {
 	params, ok := req.Params.(map[string]interface{})
 	if !ok {
@@ -279,23 +552,8 @@ func handleToolCall(req MCPRequest, ollamaClient *OllamaClient) MCPResponse {
 		}
 	}
 
-	var result interface{}
-	var err error
-
-	switch toolName {
-	case "create_file":
-		result, err = handleCreateFile(arguments, ollamaClient)
-	case "edit_file":
-		result, err = handleEditFile(arguments, ollamaClient)
-	case "read_file":
-		result, err = handleReadFile(arguments)
-	case "analyze_code":
-		result, err = handleAnalyzeCode(arguments, ollamaClient)
-	case "explain_code":
-		result, err = handleExplainCode(arguments, ollamaClient)
-	case "execute_shell":
-		result, err = handleExecuteShell(arguments)
-	default:
+	handler, ok := lookupTool(toolName)
+	if !ok {
 		return MCPResponse{
 			JSONRPC: "2.0",
 			ID:      req.ID,
@@ -306,6 +564,16 @@ func handleToolCall(req MCPRequest, ollamaClient *OllamaClient) MCPResponse {
 		}
 	}
 
+	// A call can pick the model to generate with, such as the CLI's current one
+	if model, ok := arguments["model"].(string); ok && model != "" {
+		ollamaClient = ollamaClient.withModel(model)
+	}
+
+	// Generation inside the tool reports progress under this request's ID
+	ollamaClient = ollamaClient.withProgress(req.progressToken())
+	ollamaClient.progress(fmt.Sprintf("running %s", toolName))
+
+	result, err := handler(arguments, ollamaClient)
 	if err != nil {
 		return MCPResponse{
 			JSONRPC: "2.0",
@@ -330,41 +598,127 @@ func handleCreateFile(params map[string]interface{}, ollamaClient *OllamaClient)
 		return nil, fmt.Errorf("file_path parameter is required")
 	}
 
+	// Content that was already generated (and previewed) is written as is
+	cleanContent, hasContent := params["content"].(string)
+
 	requirements, ok := params["requirements"].(string)
-	if !ok {
+	if !ok && !hasContent {
 		return nil, fmt.Errorf("requirements parameter is required")
 	}
 
+	// Existing files are only replaced when explicitly requested
+	overwrite, _ := params["overwrite"].(bool)
+
+	// A preview streams the generated content and returns it without writing
+	preview, _ := params["preview"].(bool)
+
 	// Check if file already exists
+	fileExists := false
 	if _, err := os.Stat(filePath); err == nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   "File already exists",
-		}, nil
+		if !overwrite {
+			return map[string]interface{}{
+				"success": false,
+				"error":   "File already exists",
+			}, nil
+		}
+		fileExists = true
 	}
 
-	// Detect the programming language
-	language := detectLanguage(filePath)
+	continuations := 0
+	var blocks []string
+	noCode := false
+	if !hasContent {
+		// Detect the programming language
+		language := detectLanguage(filePath)
 
-	// Generate file content using Ollama
-	prompt := fmt.Sprintf(`Create a new %s file with the following requirements:
+		// Generate file content using Ollama
+		prompt := fmt.Sprintf(`Create a new %s file with the following requirements:
 
 FILE PATH: %s
 REQUIREMENTS: %s
 
 Return ONLY the complete %s file content with proper syntax, imports, and implementation. Do not include explanations or markdown formatting.`, language, filePath, requirements, language)
 
-	response, err := ollamaClient.Generate(prompt)
-	if err != nil {
+		generator := ollamaClient
+		if preview {
+			generator = generator.withChunks()
+		}
+		response, err := generator.Generate(prompt)
+		if err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("AI generation failed: %v", err),
+			}, nil
+		}
+
+		// A model that answers a Go file with prose gets one more try with a
+		// stricter prompt; if that fails too, the raw output is returned
+		if language == "Go" {
+			if _, parseErr := fs.ParseGeneratedContent(response); parseErr != nil {
+				ollamaClient.progress("no code in the response, retrying with a stricter prompt")
+				if retried, err := generator.Generate(prompt + "\n\n" + fs.StrictFileInstruction); err == nil {
+					response = retried
+				}
+				_, parseErr = fs.ParseGeneratedContent(response)
+				noCode = parseErr != nil
+			}
+		}
+
+		// A file cut off by the output limit is continued until it's complete
+		if fs.LooksTruncated(filePath, response) {
+			response, continuations, err = fs.CompleteTruncatedFile(filePath, response, func(prompt string) (string, error) {
+				ollamaClient.progress("output was cut off, continuing the file")
+				return ollamaClient.Generate(prompt)
+			})
+			if err != nil {
+				return map[string]interface{}{
+					"success": false,
+					"error":   fmt.Sprintf("AI generation failed while continuing the file: %v", err),
+				}, nil
+			}
+		}
+
+		// A response with several code blocks, like code and its tests,
+		// is returned block by block so the caller can choose among them
+		if preview {
+			if parsed := fs.ParseGeneratedBlocks(response); len(parsed) > 1 {
+				blocks = parsed
+			}
+		}
+
+		// Clean the response
+		cleanContent = cleanAIResponse(response)
+	}
+
+	if preview {
+		message := fmt.Sprintf("Generated %s", filePath)
+		if continuations > 0 {
+			message = fmt.Sprintf("Generated %s (continued %d time(s) after hitting the output limit)", filePath, continuations)
+		}
+		result := map[string]interface{}{
+			"success":       true,
+			"content":       cleanContent,
+			"message":       message,
+			"continuations": continuations,
+		}
+		if len(blocks) > 0 {
+			result["blocks"] = blocks
+		}
+		if noCode {
+			result["no_code"] = true
+		}
+		return result, nil
+	}
+
+	// Without a preview nobody can look at prose before it's saved as code
+	if noCode {
 		return map[string]interface{}{
 			"success": false,
-			"error":   fmt.Sprintf("AI generation failed: %v", err),
+			"error":   "No code found in the response, even with a stricter prompt",
+			"no_code": true,
 		}, nil
 	}
 
-	// Clean the response
-	cleanContent := cleanAIResponse(response)
-
 	// Ensure directory exists
 	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
@@ -374,18 +728,34 @@ Return ONLY the complete %s file content with proper syntax, imports, and implem
 		}, nil
 	}
 
+	// Back up the file being replaced
+	if fileExists {
+		if err := fs.BackupFile(filePath); err != nil {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("Failed to back up file: %v", err),
+			}, nil
+		}
+	}
+
 	// Write the file
-	if err := os.WriteFile(filePath, []byte(cleanContent), 0644); err != nil {
+	ollamaClient.progress("writing file")
+	if err := fs.WriteFile(filePath, cleanContent); err != nil {
 		return map[string]interface{}{
 			"success": false,
 			"error":   fmt.Sprintf("Failed to write file: %v", err),
 		}, nil
 	}
 
+	message := fmt.Sprintf("File created successfully: %s", filePath)
+	if fileExists {
+		message = fmt.Sprintf("File overwritten successfully: %s", filePath)
+	}
+
 	return map[string]interface{}{
 		"success": true,
 		"content": cleanContent,
-		"message": fmt.Sprintf("File created successfully: %s", filePath),
+		"message": message,
 	}, nil
 }
 
@@ -401,12 +771,9 @@ func handleEditFile(params map[string]interface{}, ollamaClient *OllamaClient) (
 	}
 
 	// Read current file
-	content, err := os.ReadFile(filePath)
+	content, err := fs.ReadFile(filePath)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to read file: %v", err),
-		}, nil
+		return readFailure(filePath, err), nil
 	}
 
 	// Detect the programming language
@@ -421,7 +788,7 @@ CURRENT CONTENT:
 
 REQUESTED CHANGE: %s
 
-Return ONLY the complete modified file content. Do not include explanations or markdown formatting.`, language, filePath, string(content), editRequest)
+Return ONLY the complete modified file content. Do not include explanations or markdown formatting.`, language, filePath, content, editRequest)
 
 	response, err := ollamaClient.Generate(prompt)
 	if err != nil {
@@ -434,38 +801,80 @@ Return ONLY the complete modified file content. Do not include explanations or m
 	// Clean the response
 	cleanContent := cleanAIResponse(response)
 
-	// Write the modified file directly (no backup)
-	if err := os.WriteFile(filePath, []byte(cleanContent), 0644); err != nil {
+	// The model sometimes echoes the file back when it refuses or misreads the
+	// request; leave the file (and its backups) alone in that case. The
+	// cleaned response is trimmed, so the file is too before comparing.
+	if cleanContent == strings.TrimSpace(content) {
+		return map[string]interface{}{
+			"success":   true,
+			"no_change": true,
+			"content":   cleanContent,
+			"message":   "model returned no changes",
+		}, nil
+	}
+
+	// Back up the original so the edit can be rolled back
+	if err := fs.BackupFile(filePath); err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("Failed to back up file: %v", err),
+		}, nil
+	}
+
+	// Write the modified file
+	ollamaClient.progress("writing file")
+	if err := fs.WriteFile(filePath, cleanContent); err != nil {
 		return map[string]interface{}{
 			"success": false,
 			"error":   fmt.Sprintf("Failed to write file: %v", err),
 		}, nil
 	}
 
+	added, removed, changed := fs.DiffStats(content, cleanContent)
+
 	return map[string]interface{}{
-		"success": true,
-		"content": cleanContent,
-		"message": fmt.Sprintf("File edited successfully: %s", filePath),
+		"success":       true,
+		"content":       cleanContent,
+		"message":       fmt.Sprintf("File edited successfully: %s", filePath),
+		"lines_added":   added,
+		"lines_removed": removed,
+		"lines_changed": changed,
 	}, nil
 }
 
+// readFailure is the tool result for a file that couldn't be read as text
+func readFailure(filePath string, err error) map[string]interface{} {
+	if errors.Is(err, fs.ErrBinaryFile) {
+		return map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("%v: %s is not a text file", fs.ErrBinaryFile, filePath),
+		}
+	}
+	return map[string]interface{}{
+		"success": false,
+		"error":   fmt.Sprintf("Failed to read file: %v", err),
+	}
+}
+
 func handleReadFile(params map[string]interface{}) (interface{}, error) {
 	filePath, ok := params["file_path"].(string)
 	if !ok {
 		return nil, fmt.Errorf("file_path parameter is required")
 	}
 
-	content, err := os.ReadFile(filePath)
+	content, err := fs.ReadFile(filePath)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to read file: %v", err),
-		}, nil
+		return readFailure(filePath, err), nil
+	}
+
+	text := content
+	if lineNumbers(params) {
+		text = numberLines(text)
 	}
 
 	return map[string]interface{}{
 		"success": true,
-		"content": string(content),
+		"content": text,
 		"message": fmt.Sprintf("File read successfully: %s", filePath),
 	}, nil
 }
@@ -482,19 +891,18 @@ func handleAnalyzeCode(params map[string]interface{}, ollamaClient *OllamaClient
 	}
 
 	// Read file content
-	content, err := os.ReadFile(filePath)
+	content, err := fs.ReadFile(filePath)
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to read file: %v", err),
-		}, nil
+		return readFailure(filePath, err), nil
 	}
 
 	// Detect the programming language
 	language := detectLanguage(filePath)
 
+	code, note := codeForPrompt(content, lineNumbers(params))
+
 	// Generate analysis using Ollama
-	prompt := fmt.Sprintf(`Analyze this %s code and answer the question.
+	prompt := fmt.Sprintf(`Analyze this %s code and answer the question.%s
 
 FILE: %s
 CODE:
@@ -502,9 +910,9 @@ CODE:
 
 QUESTION: %s
 
-Provide a detailed analysis and answer.`, language, filePath, string(content), question)
+Provide a detailed analysis and answer.`, language, note, filePath, code, question)
 
-	response, err := ollamaClient.Generate(prompt)
+	response, cached, err := generateCached(ollamaClient, prompt, useCache(params))
 	if err != nil {
 		return map[string]interface{}{
 			"success": false,
@@ -515,6 +923,7 @@ Provide a detailed analysis and answer.`, language, filePath, string(content), q
 	return map[string]interface{}{
 		"success": true,
 		"content": response,
+		"cached":  cached,
 		"message": "Analysis completed successfully",
 	}, nil
 }
@@ -525,20 +934,39 @@ func handleExplainCode(params map[string]interface{}, ollamaClient *OllamaClient
 		return nil, fmt.Errorf("file_path parameter is required")
 	}
 
-	// Read file content
-	content, err := os.ReadFile(filePath)
+	// Read file content, or just one declaration of it
+	symbol, _ := params["symbol"].(string)
+	var content string
+	var err error
+	if symbol != "" {
+		content, err = fs.ExtractSymbol(filePath, symbol)
+		if errors.Is(err, fs.ErrSymbolNotFound) {
+			return map[string]interface{}{
+				"success": false,
+				"error":   fmt.Sprintf("%s is not declared in %s", symbol, filePath),
+			}, nil
+		}
+	} else {
+		content, err = fs.ReadFile(filePath)
+	}
 	if err != nil {
-		return map[string]interface{}{
-			"success": false,
-			"error":   fmt.Sprintf("Failed to read file: %v", err),
-		}, nil
+		return readFailure(filePath, err), nil
 	}
 
 	// Detect the programming language
 	language := detectLanguage(filePath)
 
+	// Optionally pull in the project files this one imports
+	depth := 0
+	if d, ok := params["depth"].(float64); ok {
+		depth = int(d)
+	}
+	depFiles, depContents := collectGoDependencies(filePath, depth)
+
+	code, note := codeForPrompt(content, lineNumbers(params))
+
 	// Generate detailed explanation using Ollama
-	prompt := fmt.Sprintf(`Explain this %s code in detail. Provide a comprehensive explanation covering:
+	prompt := fmt.Sprintf(`Explain this %s code in detail.%s Provide a comprehensive explanation covering:
 
 1. What this code does overall
 2. Key functions and their purposes
@@ -551,9 +979,25 @@ FILE: %s
 CODE:
 %s
 
-Provide a clear, detailed explanation that would help someone understand this code.`, language, filePath, string(content))
+Provide a clear, detailed explanation that would help someone understand this code.`, language, note, filePath, code)
+	if symbol != "" {
+		prompt += fmt.Sprintf("\n\nThe code is only the declaration of %s from %s, not the whole file.", symbol, filePath)
+	}
 
-	response, err := ollamaClient.Generate(prompt)
+	if len(depFiles) > 0 {
+		var related []string
+		for _, depFile := range depFiles {
+			related = append(related, fmt.Sprintf("// %s\n%s", depFile, depContents[depFile]))
+		}
+		prompt += fmt.Sprintf(`
+
+RELATED PROJECT FILES (imported by %s):
+%s
+
+Also explain how %s interacts with these related files: which of their functions and types it uses and why.`, filePath, strings.Join(related, "\n\n"), filePath)
+	}
+
+	response, cached, err := generateCached(ollamaClient, prompt, useCache(params))
 	if err != nil {
 		return map[string]interface{}{
 			"success": false,
@@ -564,6 +1008,7 @@ Provide a clear, detailed explanation that would help someone understand this co
 	return map[string]interface{}{
 		"success": true,
 		"content": response,
+		"cached":  cached,
 		"message": "Code explanation completed successfully",
 	}, nil
 }
@@ -615,6 +1060,114 @@ func cleanAIResponse(response string) string {
 	return strings.TrimSpace(response)
 }
 
+// Shell commands run for defaultShellTimeout unless the request asks for
+// longer, up to maxShellTimeout
+const (
+	defaultShellTimeout = 30 * time.Second
+	maxShellTimeout     = 30 * time.Minute
+)
+
+// lineNumbers reads the optional "line_numbers" argument, off by default
+func lineNumbers(params map[string]interface{}) bool {
+	enabled, _ := params["line_numbers"].(bool)
+	return enabled
+}
+
+// numberLines prefixes each line with its 1-based line number
+func numberLines(content string) string {
+	lines := strings.Split(content, "\n")
+	width := len(strconv.Itoa(len(lines)))
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("%*d | %s", width, i+1, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// codeForPrompt returns the code to embed in a prompt and, when it is
+// numbered, a sentence telling the model to cite those numbers
+func codeForPrompt(content string, numbered bool) (string, string) {
+	if !numbered {
+		return content, ""
+	}
+	return numberLines(content), " Each line is prefixed with its line number; refer to lines by these numbers."
+}
+
+// shellTimeout reads the optional "timeout" argument, given in seconds
+func shellTimeout(params map[string]interface{}) time.Duration {
+	seconds, ok := params["timeout"].(float64)
+	if !ok || seconds <= 0 {
+		return defaultShellTimeout
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > maxShellTimeout {
+		return maxShellTimeout
+	}
+	return timeout
+}
+
+// commandEnv returns the server's environment with the optional "env"
+// argument's variables set on top, or nil to inherit it unchanged
+func commandEnv(params map[string]interface{}) []string {
+	extra, ok := params["env"].(map[string]interface{})
+	if !ok || len(extra) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for key, value := range extra {
+		if str, ok := value.(string); ok {
+			env = append(env, key+"="+str)
+		}
+	}
+	return env
+}
+
+// signalNames gives conventional names for the signals a command usually dies from
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGPIPE: "SIGPIPE",
+}
+
+// terminatingSignal returns the name of the signal that ended the process, if any
+func terminatingSignal(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	if name, ok := signalNames[status.Signal()]; ok {
+		return name
+	}
+	return status.Signal().String()
+}
+
+// timeoutExitCode is reported as the exit code of commands killed by the timeout
+const timeoutExitCode = -1
+
+// exitCode extracts a command's exit code from the error cmd.Run returned.
+// A command that could not be found reports 127 like a shell would.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return 127
+	}
+	return -1
+}
+
 func handleExecuteShell(params map[string]interface{}) (interface{}, error) {
 	command, ok := params["command"].(string)
 	if !ok {
@@ -631,7 +1184,8 @@ func handleExecuteShell(params map[string]interface{}) (interface{}, error) {
 	}
 
 	// Create command with context timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	timeout := shellTimeout(params)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
@@ -639,6 +1193,14 @@ func handleExecuteShell(params map[string]interface{}) (interface{}, error) {
 	// Set working directory to current directory
 	cmd.Dir = "."
 
+	// Later entries win, so request variables override inherited ones
+	cmd.Env = commandEnv(params)
+
+	// Feed optional input, e.g. a patch for `git apply`
+	if stdin, ok := params["stdin"].(string); ok && stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+
 	// Capture both stdout and stderr
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -650,29 +1212,46 @@ func handleExecuteShell(params map[string]interface{}) (interface{}, error) {
 	// Get output
 	output := stdout.String()
 	errorOutput := stderr.String()
+	signal := terminatingSignal(cmd.ProcessState)
 
-	// Check for timeout
+	// Check for timeout; the output gathered so far is still returned
 	if ctx.Err() == context.DeadlineExceeded {
+		message := fmt.Sprintf("Command terminated after timeout of %v", timeout)
+		if signal != "" {
+			message += fmt.Sprintf(" (%s)", signal)
+		}
 		return map[string]interface{}{
-			"success": false,
-			"error":   "Command timed out after 30 seconds",
-			"output":  output,
-			"stderr":  errorOutput,
+			"success":   false,
+			"error":     message,
+			"output":    output,
+			"stderr":    errorOutput,
+			"command":   command,
+			"timed_out": true,
+			"signal":    signal,
+			"exit_code": timeoutExitCode,
 		}, nil
 	}
 
 	// Determine success based on exit code
+	code := exitCode(err)
 	success := err == nil
 	message := "Command executed successfully"
 	if !success {
 		message = fmt.Sprintf("Command failed with error: %v", err)
 	}
 
-	return map[string]interface{}{
-		"success": success,
-		"output":  output,
-		"stderr":  errorOutput,
-		"message": message,
-		"command": command,
-	}, nil
+	result := map[string]interface{}{
+		"success":   success,
+		"output":    output,
+		"stderr":    errorOutput,
+		"message":   message,
+		"command":   command,
+		"timed_out": false,
+		"signal":    signal,
+		"exit_code": code,
+	}
+	if !success {
+		result["error"] = message
+	}
+	return result, nil
 }
@@ -0,0 +1,385 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestFailure describes a single failing test and the output it produced
+type TestFailure struct {
+	Name    string `json:"name"`
+	Output  string `json:"output"`
+	Package string `json:"package,omitempty"` // Go import path of the test's package
+	Dir     string `json:"dir,omitempty"`     // Directory the file names in Output are relative to
+}
+
+// TestReport is the structured summary of a test run
+type TestReport struct {
+	Framework    string        `json:"framework"`
+	Passed       int           `json:"passed"`
+	Failed       int           `json:"failed"`
+	Errors       int           `json:"errors"`
+	Skipped      int           `json:"skipped"`
+	FailingTests []TestFailure `json:"failing_tests,omitempty"`
+}
+
+// HasFailures reports whether any test failed or errored
+func (r *TestReport) HasFailures() bool {
+	return r.Failed > 0 || r.Errors > 0
+}
+
+// detectTestCommand picks the test framework and command for the project in dir
+func detectTestCommand(dir string) (string, []string, error) {
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	switch {
+	case exists("go.mod"):
+		return "go", []string{"go", "test", "-v", "./..."}, nil
+	case exists("package.json"):
+		return "jest", []string{"npx", "jest", "--ci"}, nil
+	case exists("pytest.ini"), exists("pyproject.toml"), exists("setup.py"), exists("requirements.txt"):
+		return "pytest", []string{"python", "-m", "pytest"}, nil
+	}
+
+	return "", nil, fmt.Errorf("could not detect a test framework in %s", dir)
+}
+
+// ParseTestOutput converts raw test output into a TestReport for the given framework
+func ParseTestOutput(framework, output string) *TestReport {
+	switch framework {
+	case "go":
+		return parseGoTestOutput(output)
+	case "pytest":
+		return parsePytestOutput(output)
+	case "jest":
+		return parseJestOutput(output)
+	default:
+		return &TestReport{Framework: framework}
+	}
+}
+
+// parseGoTestOutput parses `go test -v` output
+func parseGoTestOutput(output string) *TestReport {
+	report := &TestReport{Framework: "go"}
+	lines := strings.Split(output, "\n")
+
+	// Remember where each test started so its output can be attached to failures
+	runStart := make(map[string]int)
+	failed := make(map[string]bool)
+	// Failures since the last package summary line, which names their package
+	pending := 0
+
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "=== RUN"):
+			name := strings.TrimSpace(strings.TrimPrefix(trimmed, "=== RUN"))
+			runStart[name] = i + 1
+		case strings.HasPrefix(trimmed, "--- PASS:"):
+			report.Passed++
+		case strings.HasPrefix(trimmed, "--- SKIP:"):
+			report.Skipped++
+		case strings.HasPrefix(trimmed, "--- FAIL:"):
+			report.Failed++
+			name := goTestName(strings.TrimPrefix(trimmed, "--- FAIL:"))
+			failed[name] = true
+			start, ok := runStart[name]
+			if !ok {
+				start = i
+			}
+			report.FailingTests = append(report.FailingTests, TestFailure{
+				Name:   name,
+				Output: strings.TrimSpace(strings.Join(lines[start:i], "\n")),
+			})
+		case strings.HasPrefix(trimmed, "FAIL") && (strings.HasSuffix(trimmed, "[build failed]") || strings.HasSuffix(trimmed, "[setup failed]")):
+			report.Errors++
+			fields := strings.Fields(trimmed)
+			pkg := ""
+			if len(fields) > 1 {
+				pkg = fields[1]
+			}
+			report.FailingTests = append(report.FailingTests, TestFailure{
+				Name:   pkg,
+				Output: goBuildErrors(lines, pkg),
+			})
+			pending = len(report.FailingTests)
+		case strings.HasPrefix(trimmed, "FAIL") || strings.HasPrefix(trimmed, "ok "):
+			fields := strings.Fields(trimmed)
+			if len(fields) < 2 {
+				continue
+			}
+			for j := pending; j < len(report.FailingTests); j++ {
+				report.FailingTests[j].Package = fields[1]
+			}
+			pending = len(report.FailingTests)
+		}
+	}
+
+	// A failing subtest fails its parent as well; only the subtest is counted
+	report.FailingTests = slices.DeleteFunc(report.FailingTests, func(failure TestFailure) bool {
+		if !failed[failure.Name] {
+			return false
+		}
+		for name := range failed {
+			if strings.HasPrefix(name, failure.Name+"/") {
+				report.Failed--
+				return true
+			}
+		}
+		return false
+	})
+
+	return report
+}
+
+// goTestName extracts the test name from the remainder of a "--- FAIL:" line
+func goTestName(rest string) string {
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// goBuildErrors collects the compiler output printed under "# <pkg>" for a package
+func goBuildErrors(lines []string, pkg string) string {
+	var errors []string
+	inPackage := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# ") {
+			inPackage = strings.TrimSpace(strings.TrimPrefix(line, "# ")) == pkg
+			continue
+		}
+		if inPackage {
+			if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "FAIL") || strings.HasPrefix(line, "ok") {
+				inPackage = false
+				continue
+			}
+			errors = append(errors, line)
+		}
+	}
+	return strings.Join(errors, "\n")
+}
+
+var pytestCountRegex = regexp.MustCompile(`(\d+) (passed|failed|skipped|errors?)`)
+
+// parsePytestOutput parses pytest's summary line and failure sections
+func parsePytestOutput(output string) *TestReport {
+	report := &TestReport{Framework: "pytest"}
+	lines := strings.Split(output, "\n")
+
+	// The final "=== ... in 0.12s ===" line holds the counts
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "=") || !strings.Contains(line, " in ") {
+			continue
+		}
+		for _, match := range pytestCountRegex.FindAllStringSubmatch(line, -1) {
+			count, _ := strconv.Atoi(match[1])
+			switch match[2] {
+			case "passed":
+				report.Passed = count
+			case "failed":
+				report.Failed = count
+			case "skipped":
+				report.Skipped = count
+			case "error", "errors":
+				report.Errors = count
+			}
+		}
+		break
+	}
+
+	// Failure sections look like "____ test_name ____"
+	sections := make(map[string]string)
+	var current string
+	var body []string
+	flush := func() {
+		if current != "" {
+			sections[current] = strings.TrimSpace(strings.Join(body, "\n"))
+		}
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "___") && strings.HasSuffix(trimmed, "___") {
+			flush()
+			current = strings.TrimSpace(strings.Trim(trimmed, "_"))
+			body = nil
+			continue
+		}
+		if strings.HasPrefix(trimmed, "===") {
+			flush()
+			current = ""
+			body = nil
+			continue
+		}
+		if current != "" {
+			body = append(body, line)
+		}
+	}
+	flush()
+
+	// The short summary lists every failing test by node id
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, "FAILED ") && !strings.HasPrefix(trimmed, "ERROR ") {
+			continue
+		}
+		nodeID := strings.Fields(trimmed)[1]
+		name := nodeID
+		if idx := strings.LastIndex(nodeID, "::"); idx != -1 {
+			name = nodeID[idx+2:]
+		}
+		report.FailingTests = append(report.FailingTests, TestFailure{
+			Name:   nodeID,
+			Output: sections[name],
+		})
+	}
+
+	return report
+}
+
+var jestCountRegex = regexp.MustCompile(`(\d+) (passed|failed|skipped|todo)`)
+
+// parseJestOutput parses jest's "Tests:" summary line and "●" failure blocks
+func parseJestOutput(output string) *TestReport {
+	report := &TestReport{Framework: "jest"}
+	lines := strings.Split(output, "\n")
+
+	var current *TestFailure
+	var body []string
+	flush := func() {
+		if current != nil {
+			current.Output = strings.TrimSpace(strings.Join(body, "\n"))
+			report.FailingTests = append(report.FailingTests, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "Tests:"):
+			flush()
+			for _, match := range jestCountRegex.FindAllStringSubmatch(trimmed, -1) {
+				count, _ := strconv.Atoi(match[1])
+				switch match[2] {
+				case "passed":
+					report.Passed = count
+				case "failed":
+					report.Failed = count
+				case "skipped", "todo":
+					report.Skipped += count
+				}
+			}
+		case strings.HasPrefix(trimmed, "Test Suites:"):
+			flush()
+		case strings.HasPrefix(trimmed, "● "):
+			flush()
+			current = &TestFailure{Name: strings.TrimSpace(strings.TrimPrefix(trimmed, "● "))}
+			body = nil
+		case current != nil:
+			body = append(body, line)
+		}
+	}
+	flush()
+
+	return report
+}
+
+// setGoPackageDirs sets the directory of each failing test's package, since
+// go test names files relative to it
+func setGoPackageDirs(report *TestReport, dir string) {
+	modulePath, moduleDir := goModulePath(dir)
+	if modulePath == "" {
+		return
+	}
+	// Keep the directories relative when dir is, as the file names are shown
+	if absDir, err := filepath.Abs(dir); err == nil {
+		if rel, err := filepath.Rel(absDir, moduleDir); err == nil {
+			moduleDir = filepath.Join(dir, rel)
+		}
+	}
+	for i, failure := range report.FailingTests {
+		if failure.Package != modulePath && !strings.HasPrefix(failure.Package, modulePath+"/") {
+			continue
+		}
+		report.FailingTests[i].Dir = filepath.Join(moduleDir, strings.TrimPrefix(failure.Package, modulePath))
+	}
+}
+
+func handleRunTests(params map[string]interface{}) (interface{}, error) {
+	dir := "."
+	if path, ok := params["path"].(string); ok && path != "" {
+		dir = path
+	}
+
+	framework, command, err := detectTestCommand(dir)
+	if err != nil {
+		return map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	output := stdout.String()
+	errorOutput := stderr.String()
+	commandLine := strings.Join(command, " ")
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return map[string]interface{}{
+			"success": false,
+			"error":   "Tests timed out after 5 minutes",
+			"output":  output,
+			"stderr":  errorOutput,
+			"command": commandLine,
+		}, nil
+	}
+
+	// Frameworks differ on which stream carries the results, so parse both
+	report := ParseTestOutput(framework, output+"\n"+errorOutput)
+	if framework == "go" {
+		setGoPackageDirs(report, dir)
+	}
+
+	success := runErr == nil && !report.HasFailures()
+	message := fmt.Sprintf("%d passed, %d failed, %d errors, %d skipped", report.Passed, report.Failed, report.Errors, report.Skipped)
+	if runErr != nil && !report.HasFailures() {
+		message = fmt.Sprintf("Test command failed with error: %v", runErr)
+	}
+
+	return map[string]interface{}{
+		"success": success,
+		"output":  output,
+		"stderr":  errorOutput,
+		"message": message,
+		"command": commandLine,
+		"report":  report,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// verbose makes the client print every tool call and its raw result
+var verbose atomic.Bool
+
+// maxVerboseArgLen caps how much of a string argument is shown
+const maxVerboseArgLen = 60
+
+// maxVerboseResultLen caps how much of a raw result is shown
+const maxVerboseResultLen = 2000
+
+// SetVerbose turns printing of tool calls and their results on or off
+func SetVerbose(enabled bool) {
+	verbose.Store(enabled)
+}
+
+// Verbose reports whether tool calls are printed
+func Verbose() bool {
+	return verbose.Load()
+}
+
+// logToolCall prints the tool about to be called with a summary of its arguments
+func logToolCall(toolName string, params map[string]interface{}) {
+	if !verbose.Load() {
+		return
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	args := make([]string, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, fmt.Sprintf("%s=%s", key, summarizeArg(params[key])))
+	}
+	fmt.Printf("🔌 %s(%s)\n", toolName, strings.Join(args, ", "))
+}
+
+// summarizeArg formats an argument value, shortening long strings
+func summarizeArg(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		v = strings.ReplaceAll(v, "\n", `\n`)
+		if len(v) > maxVerboseArgLen {
+			return fmt.Sprintf("%q… (%d chars)", v[:maxVerboseArgLen], len(v))
+		}
+		return fmt.Sprintf("%q", v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	}
+}
+
+// logToolResult prints the raw result of a tool call
+func logToolResult(toolName string, raw interface{}, err error, elapsed time.Duration) {
+	if !verbose.Load() {
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("🔌 %s failed after %s: %v\n", toolName, elapsed.Round(time.Millisecond), err)
+		return
+	}
+
+	data, jsonErr := json.MarshalIndent(raw, "", "  ")
+	if jsonErr != nil {
+		data = []byte(fmt.Sprintf("%v", raw))
+	}
+	result := string(data)
+	if len(result) > maxVerboseResultLen {
+		result = fmt.Sprintf("%s\n… (%d more bytes)", result[:maxVerboseResultLen], len(result)-maxVerboseResultLen)
+	}
+	fmt.Printf("🔌 %s returned after %s:\n%s\n", toolName, elapsed.Round(time.Millisecond), result)
+}
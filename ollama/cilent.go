@@ -5,11 +5,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/muratbekj/silent-code/agent"
+	"github.com/muratbekj/silent-code/config"
 	"github.com/muratbekj/silent-code/history"
 )
 
@@ -17,6 +22,15 @@ type Request struct {
 	Model    string          `json:"model"`
 	Messages []agent.Message `json:"messages"`
 	Stream   bool            `json:"stream"`
+	Options  *Options        `json:"options,omitempty"`
+}
+
+// Options are the model parameters Ollama accepts alongside a request
+type Options struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
 }
 
 type Response struct {
@@ -32,8 +46,11 @@ type Response struct {
 	EvalDuration       int64         `json:"eval_duration"`
 }
 type agentStreamResponse struct {
+	Model              string        `json:"model"`
 	Message            agent.Message `json:"message"`
 	Done               bool          `json:"done"`
+	DoneReason         string        `json:"done_reason"`
+	Error              string        `json:"error"`
 	TotalDuration      int64         `json:"total_duration"`
 	LoadDuration       int           `json:"load_duration"`
 	PromptEvalCount    int           `json:"prompt_eval_count"`
@@ -51,6 +68,91 @@ var reasoningManager *agent.ReasoningManager
 // Global model configuration
 var currentModel = ""
 
+// Global generation options and the preset they came from
+var currentOptions Options
+var currentPreset = ""
+
+// StreamStats are the generation statistics Ollama reports when a stream finishes
+type StreamStats struct {
+	EvalCount    int
+	EvalDuration time.Duration
+	LoadDuration time.Duration // Time spent loading the model before generating
+	Truncated    bool          // The response stopped at the output limit
+}
+
+// TokensPerSecond returns the generation speed, or 0 when no tokens were timed
+func (s StreamStats) TokensPerSecond() float64 {
+	if s.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(s.EvalCount) / s.EvalDuration.Seconds()
+}
+
+// ChatResponse is a complete answer together with the usage statistics
+// Ollama reported for it
+type ChatResponse struct {
+	Content         string
+	Model           string
+	DoneReason      string // Why generation stopped, e.g. "stop" or "length"
+	PromptEvalCount int    // Tokens in the prompt
+	EvalCount       int    // Tokens generated
+	EvalDuration    time.Duration
+	LoadDuration    time.Duration
+	TotalDuration   time.Duration
+}
+
+// TokensPerSecond returns the generation speed, or 0 when no tokens were timed
+func (r ChatResponse) TokensPerSecond() float64 {
+	if r.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(r.EvalCount) / r.EvalDuration.Seconds()
+}
+
+// newChatResponse combines streamed content with the final chunk's metadata
+func newChatResponse(content string, final agentStreamResponse) ChatResponse {
+	return ChatResponse{
+		Content:         content,
+		Model:           final.Model,
+		DoneReason:      final.DoneReason,
+		PromptEvalCount: final.PromptEvalCount,
+		EvalCount:       final.EvalCount,
+		EvalDuration:    time.Duration(final.EvalDuration),
+		LoadDuration:    time.Duration(final.LoadDuration),
+		TotalDuration:   time.Duration(final.TotalDuration),
+	}
+}
+
+// Statistics of the most recently finished chat stream
+var lastStats StreamStats
+
+// LastStreamStats returns the statistics of the most recent chat response
+func LastStreamStats() StreamStats {
+	return lastStats
+}
+
+// When quiet, responses are collected without any terminal decoration
+var quietOutput = false
+
+// When streaming is off, responses are requested whole and printed at once
+var streamingEnabled = true
+
+// SetStreaming turns token streaming on or off, for terminals and logs that
+// garble the typing effect
+func SetStreaming(enabled bool) {
+	streamingEnabled = enabled
+}
+
+// StreamingEnabled reports whether output is streamed as it is generated
+func StreamingEnabled() bool {
+	return streamingEnabled
+}
+
+// SetQuiet suppresses the typing animation and streamed output, for machine-readable modes
+func SetQuiet(quiet bool) {
+	quietOutput = quiet
+}
+
 // InitializeReasoning sets up the reasoning manager
 func InitializeReasoning() {
 	reasoningManager = agent.NewReasoningManager()
@@ -69,107 +171,137 @@ func InitializeModelSelection() error {
 
 	// Select the best model based on priority
 	selectedModel := selectBestModel(models)
-	currentModel = selectedModel.Name
+	if selectedModel.Name == "" {
+		return fmt.Errorf("only embedding models are installed. Please install a chat model first: ollama pull codellama:13b")
+	}
+	setCurrentModel(selectedModel.Name)
 
 	return nil
 }
 
-// selectBestModel chooses the best model based on coding capabilities and performance
-func selectBestModel(models []OllamaModel) OllamaModel {
-	// Define model priorities for coding tasks
-	// Higher priority models are better for coding
-	modelPriorities := map[string]int{
-		"qwen2.5-coder:7b":    100,
-		"codellama:13b":       95,
-		"codellama:34b":       90,
-		"qwen2.5-coder:32b":   85,
-		"qwen2.5-coder:14b":   80,
-		"deepseek-coder:33b":  75,
-		"magicoder:15b":       70,
-		"deepseek-coder:6.7b": 65,
-		"starcoder2:15b":      60,
-		"magicoder:7b":        55,
-		"starcoder2:7b":       50,
-		"starcoder2:3b":       45,
-		"qwen2.5:32b":         40,
-		"qwen2.5:14b":         35,
-		"qwen2.5:7b":          30,
-		"gemma2:27b":          25,
-		"gemma2:9b":           20,
-	}
-
-	var bestModel OllamaModel
-	bestScore := -1
+// RankedModel is an installed model with the score used to choose between models
+type RankedModel struct {
+	Model  OllamaModel
+	Score  int
+	Listed bool // The score came from the configured priority table rather than the fallback heuristic
+}
 
-	for _, model := range models {
-		score := 0
+// RankModels scores models for coding tasks, best first. Models with equal
+// scores are ordered largest first. Embedding models can't chat, so they
+// are left out.
+func RankModels(models []OllamaModel) []RankedModel {
+	priorities := config.Get().ModelPriorities
 
-		// Check for exact match in priorities
-		if priority, exists := modelPriorities[model.Name]; exists {
-			score = priority
+	ranked := make([]RankedModel, 0, len(models))
+	for _, model := range models {
+		if IsEmbedModel(model.Name) {
+			continue
+		}
+		if priority, exists := priorities[model.Name]; exists {
+			ranked = append(ranked, RankedModel{Model: model, Score: priority, Listed: true})
 		} else {
-			// Fallback scoring based on model characteristics
-			score = calculateFallbackScore(model)
+			ranked = append(ranked, RankedModel{Model: model, Score: calculateFallbackScore(model)})
 		}
+	}
 
-		// Prefer larger models if scores are close (within 5 points)
-		if score > bestScore || (score == bestScore && model.Size > bestModel.Size) {
-			bestScore = score
-			bestModel = model
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
 		}
+		return ranked[i].Model.Size > ranked[j].Model.Size
+	})
+	return ranked
+}
+
+// selectBestModel chooses the best model based on coding capabilities and performance
+func selectBestModel(models []OllamaModel) OllamaModel {
+	ranked := RankModels(models)
+	if len(ranked) == 0 {
+		return OllamaModel{}
 	}
+	return ranked[0].Model
+}
 
-	return bestModel
+// RecommendedModel returns the model selectBestModel would pick from the given list
+func RecommendedModel(models []OllamaModel) OllamaModel {
+	return selectBestModel(models)
 }
 
-// calculateFallbackScore provides a score for models not in the priority list
+// BetterInstalledModel returns the highest-ranked installed model when it
+// isn't the model currently in use
+func BetterInstalledModel() (string, bool) {
+	models, err := ListOllamaModels()
+	if err != nil || len(models) == 0 {
+		return "", false
+	}
+
+	ranked := RankModels(models)
+	if len(ranked) == 0 {
+		return "", false
+	}
+	best := ranked[0]
+	for _, r := range ranked {
+		if r.Model.Name == currentModel {
+			// A model with the same score is just as good
+			if r.Score >= best.Score {
+				return "", false
+			}
+			break
+		}
+	}
+	return best.Model.Name, true
+}
+
+// calculateFallbackScore provides a score for models not in the priority
+// list, using the configured fallback scoring
 func calculateFallbackScore(model OllamaModel) int {
-	score := 30 // Base score for unknown models
+	scoring := config.Get().FallbackScoring
+	score := scoring.Base
 
 	// Boost score for coding-related keywords in name
 	name := strings.ToLower(model.Name)
-	if strings.Contains(name, "code") {
-		score += 30
-	}
-	if strings.Contains(name, "coder") {
-		score += 25
-	}
-	if strings.Contains(name, "star") {
-		score += 20
-	}
-	if strings.Contains(name, "wizard") {
-		score += 15
-	}
-	if strings.Contains(name, "magic") {
-		score += 15
+	for keyword, bonus := range scoring.Keywords {
+		if strings.Contains(name, strings.ToLower(keyword)) {
+			score += bonus
+		}
 	}
 
-	// Boost score for larger models (more parameters)
-	if model.Details.ParameterSize != "" {
-		if strings.Contains(model.Details.ParameterSize, "13B") || strings.Contains(model.Details.ParameterSize, "13b") {
-			score += 20
-		} else if strings.Contains(model.Details.ParameterSize, "7B") || strings.Contains(model.Details.ParameterSize, "7b") {
-			score += 15
-		} else if strings.Contains(model.Details.ParameterSize, "34B") || strings.Contains(model.Details.ParameterSize, "34b") {
-			score += 25
-		} else if strings.Contains(model.Details.ParameterSize, "70B") || strings.Contains(model.Details.ParameterSize, "70b") {
-			score += 30
+	// Boost score for larger models (more parameters); the longest matching
+	// size wins so "27B" isn't mistaken for "7B"
+	parameterSize := strings.ToUpper(model.Details.ParameterSize)
+	matchedSize := ""
+	for size := range scoring.ParameterSizes {
+		size = strings.ToUpper(size)
+		if strings.Contains(parameterSize, size) && len(size) > len(matchedSize) {
+			matchedSize = size
+		}
+	}
+	for size, bonus := range scoring.ParameterSizes {
+		if matchedSize != "" && strings.ToUpper(size) == matchedSize {
+			score += bonus
+			break
 		}
 	}
 
 	// Boost score for recent models (based on modification date)
 	// This is a simple heuristic - newer models are often better
 	daysSinceModified := time.Since(model.ModifiedAt).Hours() / 24
-	if daysSinceModified < 30 { // Modified within last 30 days
-		score += 10
-	} else if daysSinceModified < 90 { // Modified within last 90 days
-		score += 5
+	window := -1
+	for days := range scoring.RecentDays {
+		if daysSinceModified < float64(days) && (window == -1 || days < window) {
+			window = days
+		}
+	}
+	if window != -1 {
+		score += scoring.RecentDays[window]
 	}
 
 	return score
 }
 
-// SetModel sets the current model for all Ollama requests
+// SetModel sets the current model for all Ollama requests.
+// A name that isn't exact may still select a model: "codellama" picks
+// "codellama:13b" when that is the only match.
 func SetModel(modelName string) error {
 	// Validate that the model exists
 	models, err := ListOllamaModels()
@@ -177,15 +309,65 @@ func SetModel(modelName string) error {
 		return fmt.Errorf("failed to list models: %w", err)
 	}
 
-	// Check if the model exists
+	resolved, err := resolveModelName(modelName, models)
+	if err != nil {
+		return err
+	}
+
+	// The model may have been pulled since the list was cached
+	if resolved == "" {
+		models, err = ListOllamaModelsWithOptions(ListOptions{ForceRefresh: true})
+		if err != nil {
+			return fmt.Errorf("failed to list models: %w", err)
+		}
+		if resolved, err = resolveModelName(modelName, models); err != nil {
+			return err
+		}
+	}
+
+	if resolved == "" {
+		return fmt.Errorf("model '%s' not found. Use '/config' to see available models", modelName)
+	}
+	setCurrentModel(resolved)
+	return nil
+}
+
+// AmbiguousModelError is returned when a partial model name matches more
+// than one installed model
+type AmbiguousModelError struct {
+	Name    string
+	Matches []string
+}
+
+func (e *AmbiguousModelError) Error() string {
+	return fmt.Sprintf("model '%s' matches %s; use the full name", e.Name, strings.Join(e.Matches, ", "))
+}
+
+// resolveModelName returns the installed model a name refers to, or "" when
+// there is none. An exact match always wins; otherwise the name may be a
+// prefix of one installed model, such as its base name without the tag.
+func resolveModelName(name string, models []OllamaModel) (string, error) {
+	for _, model := range models {
+		if model.Name == name {
+			return model.Name, nil
+		}
+	}
+
+	var matches []string
 	for _, model := range models {
-		if model.Name == modelName {
-			currentModel = modelName
-			return nil
+		if strings.HasPrefix(strings.ToLower(model.Name), strings.ToLower(name)) {
+			matches = append(matches, model.Name)
 		}
 	}
 
-	return fmt.Errorf("model '%s' not found. Use '/config' to see available models", modelName)
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", &AmbiguousModelError{Name: name, Matches: matches}
 }
 
 // GetCurrentModel returns the currently configured model
@@ -193,14 +375,214 @@ func GetCurrentModel() string {
 	return currentModel
 }
 
+// requestOptions returns the options to attach to a chat request, or nil when
+// everything is left at Ollama's defaults
+func requestOptions() *Options {
+	return requestOptionsFor(config.OpChat, nil)
+}
+
+// requestOptionsFor returns the current options for an operation with extra
+// stop sequences added. The operation's configured temperature applies
+// unless the active preset sets one.
+func requestOptionsFor(op string, stop []string) *Options {
+	opts := currentOptions
+	opts.Stop = append(append([]string{}, currentOptions.Stop...), stop...)
+	if opts.Temperature == nil {
+		if temperature, ok := config.OperationTemperature(op); ok {
+			opts.Temperature = &temperature
+		}
+	}
+
+	if opts.Temperature == nil && opts.NumPredict == 0 && opts.NumCtx == 0 && len(opts.Stop) == 0 {
+		return nil
+	}
+	return &opts
+}
+
+// ApplyPreset switches the model and generation options to a named preset from config
+func ApplyPreset(name string) error {
+	preset, exists := config.Get().Presets[name]
+	if !exists {
+		return fmt.Errorf("preset '%s' not found", name)
+	}
+
+	modelName := preset.Model
+	if modelName == "" {
+		models, err := ListOllamaModels()
+		if err != nil {
+			return fmt.Errorf("failed to list models: %w", err)
+		}
+		if len(models) == 0 {
+			return fmt.Errorf("no models available")
+		}
+
+		if preset.PreferSmall {
+			var smallest *OllamaModel
+			for i, model := range models {
+				if IsEmbedModel(model.Name) {
+					continue
+				}
+				if smallest == nil || model.Size < smallest.Size {
+					smallest = &models[i]
+				}
+			}
+			if smallest != nil {
+				modelName = smallest.Name
+			}
+		} else {
+			modelName = selectBestModel(models).Name
+		}
+		if modelName == "" {
+			return fmt.Errorf("no chat models available, only embedding models")
+		}
+	}
+
+	if err := SetModel(modelName); err != nil {
+		return err
+	}
+
+	currentOptions = Options{
+		Temperature: preset.Temperature,
+		NumPredict:  preset.NumPredict,
+		NumCtx:      preset.NumCtx,
+	}
+	currentPreset = name
+
+	return nil
+}
+
+// SetMaxTokens caps the number of tokens the model may generate per response.
+// -1 removes the cap and leaves it to Ollama's default.
+func SetMaxTokens(n int) error {
+	if n == 0 || n < -1 {
+		return fmt.Errorf("max tokens must be a positive number or -1 for unlimited")
+	}
+	if n == -1 {
+		n = 0
+	}
+	currentOptions.NumPredict = n
+	return nil
+}
+
+// GetMaxTokens returns the output token cap, or -1 when unlimited
+func GetMaxTokens() int {
+	if currentOptions.NumPredict <= 0 {
+		return -1
+	}
+	return currentOptions.NumPredict
+}
+
+// wasTruncated reports whether a finished stream stopped because it hit the output cap
+func wasTruncated(final agentStreamResponse, numPredict int) bool {
+	if final.DoneReason == "length" {
+		return true
+	}
+	return numPredict > 0 && final.EvalCount >= numPredict
+}
+
+// requestNumPredict returns the output cap a request was sent with
+func requestNumPredict(ollamaReq Request) int {
+	if ollamaReq.Options != nil {
+		return ollamaReq.Options.NumPredict
+	}
+	return 0
+}
+
+// modelLoadNoticeThreshold is the load time above which the model is taken
+// to have been (re)loaded; Ollama reports a few milliseconds otherwise
+const modelLoadNoticeThreshold = 500 * time.Millisecond
+
+// noteModelLoad tells the user when part of the wait was loading the model
+// rather than generating
+func noteModelLoad(final agentStreamResponse) {
+	if quietOutput {
+		return
+	}
+	if load := time.Duration(final.LoadDuration); final.Done && load >= modelLoadNoticeThreshold {
+		fmt.Printf("\n🔁 model loaded in %.1fs", load.Seconds())
+	}
+}
+
+// noteTruncation tells the user when a response was cut off by the output cap
+func noteTruncation(final agentStreamResponse, ollamaReq Request) {
+	if quietOutput {
+		return
+	}
+	if final.Done && wasTruncated(final, requestNumPredict(ollamaReq)) {
+		fmt.Printf("\n⚠️  output truncated at %d tokens", final.EvalCount)
+	}
+}
+
+// maxContinuations limits how many times one answer can be continued
+const maxContinuations = 3
+
+// continueInstruction asks the model to pick up a cut-off answer
+const continueInstruction = "Continue exactly where you left off. Do not repeat anything you already wrote."
+
+// continuePrompt asks whether to continue a truncated response; nil never continues
+var continuePrompt func() bool
+
+// modelListener is told about every change of the current model; nil for none
+var modelListener func(model string)
+
+// SetModelListener sets a function called with the new model whenever the
+// current model changes, such as to keep the MCP server on the same model
+func SetModelListener(listener func(model string)) {
+	modelListener = listener
+}
+
+// setCurrentModel changes the current model and tells the model listener
+func setCurrentModel(model string) {
+	currentModel = model
+	if modelListener != nil {
+		modelListener(model)
+	}
+}
+
+// SetContinuePrompt sets how TalkToOllama asks to continue a truncated response
+func SetContinuePrompt(prompt func() bool) {
+	continuePrompt = prompt
+}
+
+// GetActivePreset returns the name of the last applied preset, if any
+func GetActivePreset() string {
+	return currentPreset
+}
+
 func TalkToOllama(userInput string, sessionID string, historyManager *history.HistoryManager) {
-	start := time.Now()
+	TalkToOllamaFor(config.OpChat, userInput, sessionID, historyManager)
+}
 
-	// Initialize prompt builder
-	promptBuilder := agent.NewPromptBuilder()
+// TalkToOllamaFor is TalkToOllama using the temperature configured for an
+// operation such as config.OpGenerate
+func TalkToOllamaFor(op string, userInput string, sessionID string, historyManager *history.HistoryManager) {
+	talkToOllama(requestOptionsFor(op, nil), userInput, "", sessionID, historyManager)
+}
 
-	// Load project context
-	promptBuilder.LoadProjectContext(".")
+// TalkToOllamaWithAttachment is TalkToOllama with context such as command
+// output added to this request's prompt; history keeps only userInput
+func TalkToOllamaWithAttachment(userInput string, attachment string, sessionID string, historyManager *history.HistoryManager) {
+	talkToOllama(requestOptionsFor(config.OpChat, nil), userInput, attachment, sessionID, historyManager)
+}
+
+// TalkToOllamaWithTemperature is TalkToOllama with the temperature set for
+// this request only, e.g. to vary a regenerated answer
+func TalkToOllamaWithTemperature(temperature float64, userInput string, sessionID string, historyManager *history.HistoryManager) {
+	opts := requestOptionsFor(config.OpChat, nil)
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.Temperature = &temperature
+	talkToOllama(opts, userInput, "", sessionID, historyManager)
+}
+
+// talkToOllama streams an answer to userInput, followed in the prompt by
+// attachment, with the given options and records both sides of the exchange
+// in history
+func talkToOllama(opts *Options, userInput string, attachment string, sessionID string, historyManager *history.HistoryManager) {
+	start := time.Now()
+	prompt := userInput + attachment
+	lastUserInput = prompt
 
 	// Add user message to history
 	userMessage := agent.Message{
@@ -211,49 +593,31 @@ func TalkToOllama(userInput string, sessionID string, historyManager *history.Hi
 	if historyManager != nil {
 		historyManager.AddMessage(sessionID, userMessage)
 	}
+	beginPendingResponse(sessionID, historyManager)
+	defer endPendingResponse()
 
-	// Get conversation history for context
-	var conversationHistory []string
-	if historyManager != nil {
-		history, err := historyManager.GetSessionHistory(sessionID)
-		if err == nil {
-			// Convert history to conversation format
-			for _, msg := range history {
-				conversationHistory = append(conversationHistory, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
-			}
-		}
-	}
-
-	// Build enhanced prompt with context
-	enhancedPrompt := promptBuilder.BuildPrompt(userInput, conversationHistory)
-
-	// Create messages with system prompt
-	messages := []agent.Message{
-		{
-			Role:    "system",
-			Content: promptBuilder.SystemPrompt,
-		},
-		{
-			Role:    "user",
-			Content: enhancedPrompt,
-		},
-	}
+	// Create messages with system prompt and project context
+	messages := buildChatMessages(prompt, sessionID, historyManager)
 
 	req := Request{
 		Model:    currentModel,
 		Stream:   true, // Enable streaming
 		Messages: messages,
+		Options:  opts,
 	}
 
 	// Show typing indicator
-	fmt.Print("🤖 AI: ")
+	if !quietOutput {
+		fmt.Print("🤖 AI: ")
+	}
 	stopTyping := showTypingIndicator()
 
 	// Store AI response
 	var aiResponse string
 
-	err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
+	_, err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
 		aiResponse += content
+		updatePendingResponse(aiResponse)
 	}, stopTyping)
 
 	if err != nil {
@@ -261,7 +625,32 @@ func TalkToOllama(userInput string, sessionID string, historyManager *history.Hi
 		return
 	}
 
+	// Keep going on request when the answer hit the output limit. The
+	// continuation is appended to the same answer so history stays whole.
+	for i := 0; i < maxContinuations && lastStats.Truncated && continuePrompt != nil; i++ {
+		fmt.Println()
+		if !continuePrompt() {
+			break
+		}
+
+		req.Messages = append(append([]agent.Message{}, messages...),
+			agent.Message{Role: "assistant", Content: aiResponse},
+			agent.Message{Role: "user", Content: continueInstruction},
+		)
+
+		fmt.Print("🤖 AI: ")
+		_, err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
+			aiResponse += content
+			updatePendingResponse(aiResponse)
+		}, showTypingIndicator())
+		if err != nil {
+			fmt.Printf("❌ Error continuing response: %v\n", err)
+			break
+		}
+	}
+
 	// Add AI response to history
+	endPendingResponse()
 	if historyManager != nil && aiResponse != "" {
 		aiMessage := agent.Message{
 			Role:    "assistant",
@@ -273,75 +662,246 @@ func TalkToOllama(userInput string, sessionID string, historyManager *history.Hi
 	fmt.Printf("\n⏱️  Completed in %v\n", time.Since(start))
 }
 
-// TalkToOllamaWithResponse returns the AI response as a string
-func TalkToOllamaWithResponse(userInput string, sessionID string, historyManager *history.HistoryManager) (string, error) {
-	start := time.Now()
-
+// buildChatMessages assembles the system prompt, project context, and
+// conversation history into the messages sent to the chat endpoint
+func buildChatMessages(userInput string, sessionID string, historyManager *history.HistoryManager) []agent.Message {
 	// Initialize prompt builder
 	promptBuilder := agent.NewPromptBuilder()
 
 	// Load project context
 	promptBuilder.LoadProjectContext(".")
 
-	// Add user message to history
-	userMessage := agent.Message{
-		Role:    "user",
-		Content: userInput,
-	}
+	// Build enhanced prompt with context
+	enhancedPrompt := promptBuilder.BuildPrompt(userInput, conversationHistory(sessionID, historyManager))
 
-	if historyManager != nil {
-		historyManager.AddMessage(sessionID, userMessage)
+	return []agent.Message{
+		{
+			Role:    "system",
+			Content: promptBuilder.SystemPrompt,
+		},
+		{
+			Role:    "user",
+			Content: enhancedPrompt,
+		},
 	}
+}
 
-	// Get conversation history for context
+// conversationHistory returns a session's messages formatted for the prompt,
+// limited by promptHistory
+func conversationHistory(sessionID string, historyManager *history.HistoryManager) []string {
 	var conversationHistory []string
 	if historyManager != nil {
-		history, err := historyManager.GetSessionHistory(sessionID)
+		conversation, err := historyManager.LoadSession(sessionID)
 		if err == nil {
+			summary, messages, omitted := promptHistory(conversation)
+			if summary != "" {
+				conversationHistory = append(conversationHistory, fmt.Sprintf("Summary of the earlier conversation:\n%s", summary))
+			}
+			if omitted > 0 {
+				conversationHistory = append(conversationHistory, fmt.Sprintf("[%d earlier messages not shown]", omitted))
+			}
 			// Convert history to conversation format
-			for _, msg := range history {
-				conversationHistory = append(conversationHistory, fmt.Sprintf("%s: %s", msg.Role, msg.Content))
+			for _, msg := range messages {
+				conversationHistory = append(conversationHistory, formatHistoryMessage(msg))
 			}
 		}
 	}
+	return conversationHistory
+}
 
-	// Build enhanced prompt with context
-	enhancedPrompt := promptBuilder.BuildPrompt(userInput, conversationHistory)
+// DefaultContextWindow is the num_ctx Ollama uses when a request doesn't set one
+const DefaultContextWindow = 2048
 
-	// Create messages with system prompt
-	messages := []agent.Message{
-		{
-			Role:    "system",
-			Content: promptBuilder.SystemPrompt,
-		},
-		{
-			Role:    "user",
-			Content: enhancedPrompt,
-		},
+// EffectiveContextWindow returns the context window requests run with
+func EffectiveContextWindow() int {
+	if numCtx := GetContextWindow(); numCtx > 0 {
+		return numCtx
+	}
+	return DefaultContextWindow
+}
+
+// ContextPart is one component of the prompt with its estimated size
+type ContextPart struct {
+	Name   string
+	Tokens int
+}
+
+// ContextUsage estimates how many tokens each part of the next prompt takes,
+// not counting the question itself
+func ContextUsage(sessionID string, historyManager *history.HistoryManager) []ContextPart {
+	promptBuilder := agent.NewPromptBuilder()
+	promptBuilder.LoadProjectContext(".")
+
+	// BuildPrompt repeats the system prompt in the user message
+	return []ContextPart{
+		{Name: "System prompt", Tokens: 2 * agent.EstimateTokens(promptBuilder.SystemPrompt)},
+		{Name: "Project instructions", Tokens: agent.EstimateTokens(promptBuilder.Instructions)},
+		{Name: "Project info", Tokens: agent.EstimateTokens(promptBuilder.ProjectInfo)},
+		{Name: "Code context", Tokens: agent.EstimateTokens(promptBuilder.CodeContext)},
+		{Name: "Conversation history", Tokens: agent.EstimateTokens(strings.Join(conversationHistory(sessionID, historyManager), "\n"))},
+	}
+}
+
+// The most recent input sent to the model, for inspecting its prompt later
+var lastUserInput = ""
+
+// pendingResponse is the answer currently being streamed, kept so it can be
+// saved if the process is interrupted before the answer completes
+var pendingResponse struct {
+	sync.Mutex
+	sessionID      string
+	historyManager *history.HistoryManager
+	content        string
+}
+
+// interruptedMarker is appended to an answer saved by FlushPendingResponse
+const interruptedMarker = "\n\n[response interrupted]"
+
+func beginPendingResponse(sessionID string, historyManager *history.HistoryManager) {
+	pendingResponse.Lock()
+	defer pendingResponse.Unlock()
+	pendingResponse.sessionID = sessionID
+	pendingResponse.historyManager = historyManager
+	pendingResponse.content = ""
+}
+
+func updatePendingResponse(content string) {
+	pendingResponse.Lock()
+	defer pendingResponse.Unlock()
+	pendingResponse.content = content
+}
+
+func endPendingResponse() {
+	pendingResponse.Lock()
+	defer pendingResponse.Unlock()
+	pendingResponse.historyManager = nil
+	pendingResponse.content = ""
+}
+
+// FlushPendingResponse saves the part of an answer streamed so far, marked
+// as interrupted, so shutting down mid-response doesn't lose it
+func FlushPendingResponse() {
+	pendingResponse.Lock()
+	defer pendingResponse.Unlock()
+
+	if pendingResponse.historyManager == nil || pendingResponse.content == "" {
+		return
+	}
+	pendingResponse.historyManager.AddMessage(pendingResponse.sessionID, agent.Message{
+		Role:    "assistant",
+		Content: pendingResponse.content + interruptedMarker,
+	})
+	pendingResponse.historyManager = nil
+	pendingResponse.content = ""
+}
+
+// LastUserInput returns the input of the most recent chat request
+func LastUserInput() string {
+	return lastUserInput
+}
+
+// PreviewPrompt returns the messages that would be sent for userInput,
+// without contacting Ollama
+func PreviewPrompt(userInput string, sessionID string, historyManager *history.HistoryManager) []agent.Message {
+	return buildChatMessages(userInput, sessionID, historyManager)
+}
+
+// GetContextWindow returns the num_ctx sent with requests, or 0 for the model's default
+func GetContextWindow() int {
+	return currentOptions.NumCtx
+}
+
+// EstimatePromptTokens estimates how many tokens the next request would use
+// for the given input, including system prompt, project context, and history
+func EstimatePromptTokens(userInput string, sessionID string, historyManager *history.HistoryManager) int {
+	total := 0
+	for _, msg := range buildChatMessages(userInput, sessionID, historyManager) {
+		total += agent.EstimateTokens(msg.Content)
+	}
+	return total
+}
+
+// TalkToOllamaWithResponse returns the AI response with its usage statistics
+func TalkToOllamaWithResponse(userInput string, sessionID string, historyManager *history.HistoryManager) (ChatResponse, error) {
+	return TalkToOllamaWithStop(config.OpChat, userInput, sessionID, historyManager, nil)
+}
+
+// TalkToOllamaWithStop returns the AI response with its usage statistics,
+// using the temperature configured for an operation such as config.OpEdit
+// and ending generation as soon as the model emits any of the given stop
+// sequences
+func TalkToOllamaWithStop(op string, userInput string, sessionID string, historyManager *history.HistoryManager, stop []string) (ChatResponse, error) {
+	return talkToOllamaWithResponse(requestOptionsFor(op, stop), userInput, sessionID, historyManager)
+}
+
+// minRetryNumPredict is the least output cap a retried response gets
+const minRetryNumPredict = 4096
+
+// TalkToOllamaWithHigherLimit is TalkToOllamaWithStop with a higher output
+// cap, for retrying a response that was cut off: twice the configured cap
+// and at least minRetryNumPredict, or no cap at all when none is configured
+func TalkToOllamaWithHigherLimit(op string, userInput string, sessionID string, historyManager *history.HistoryManager, stop []string) (ChatResponse, error) {
+	opts := requestOptionsFor(op, stop)
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.NumPredict > 0 {
+		opts.NumPredict = max(opts.NumPredict*2, minRetryNumPredict)
+	} else {
+		// -1 also lifts a num_predict set in the model's Modelfile
+		opts.NumPredict = -1
+	}
+	return talkToOllamaWithResponse(opts, userInput, sessionID, historyManager)
+}
+
+// talkToOllamaWithResponse streams an answer to userInput with the given
+// options, records both sides of the exchange in history, and returns it
+func talkToOllamaWithResponse(opts *Options, userInput string, sessionID string, historyManager *history.HistoryManager) (ChatResponse, error) {
+	start := time.Now()
+	lastUserInput = userInput
+
+	// Add user message to history
+	userMessage := agent.Message{
+		Role:    "user",
+		Content: userInput,
 	}
 
+	if historyManager != nil {
+		historyManager.AddMessage(sessionID, userMessage)
+	}
+	beginPendingResponse(sessionID, historyManager)
+	defer endPendingResponse()
+
+	// Create messages with system prompt and project context
+	messages := buildChatMessages(userInput, sessionID, historyManager)
+
 	req := Request{
 		Model:    currentModel,
 		Stream:   true, // Enable streaming
 		Messages: messages,
+		Options:  opts,
 	}
 
 	// Show typing indicator
-	fmt.Print("🤖 AI: ")
+	if !quietOutput {
+		fmt.Print("🤖 AI: ")
+	}
 	stopTyping := showTypingIndicator()
 
 	// Store AI response
 	var aiResponse string
 
-	err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
+	final, err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
 		aiResponse += content
+		updatePendingResponse(aiResponse)
 	}, stopTyping)
 
 	if err != nil {
-		return "", fmt.Errorf("error talking to Ollama: %w", err)
+		return ChatResponse{}, fmt.Errorf("error talking to Ollama: %w", err)
 	}
 
 	// Add AI response to history
+	endPendingResponse()
 	if historyManager != nil && aiResponse != "" {
 		aiMessage := agent.Message{
 			Role:    "assistant",
@@ -350,13 +910,18 @@ func TalkToOllamaWithResponse(userInput string, sessionID string, historyManager
 		historyManager.AddMessage(sessionID, aiMessage)
 	}
 
-	fmt.Printf("\n⏱️  Completed in %v\n", time.Since(start))
-	return aiResponse, nil
+	if !quietOutput {
+		fmt.Printf("\n⏱️  Completed in %v\n", time.Since(start))
+	}
+	return newChatResponse(aiResponse, final), nil
 }
 
 // showTypingIndicator displays an "AI is thinking" animation
 func showTypingIndicator() chan bool {
 	stopChan := make(chan bool, 1)
+	if quietOutput || !streamingEnabled {
+		return stopChan
+	}
 
 	// Start thinking indicator in background
 	go func() {
@@ -380,28 +945,113 @@ func showTypingIndicator() chan bool {
 	return stopChan
 }
 
-// talkToOllamaStream handles streaming responses with enhanced typing effect
-func talkToOllamaStream(url string, ollamaReq Request, onContent func(string), stopTyping chan bool) error {
+// statusError returns a descriptive error for a non-200 chat response,
+// including the message Ollama puts in the response body
+func statusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		message = errResp.Error
+	}
+	if message == "" {
+		return fmt.Errorf("ollama API returned %s", resp.Status)
+	}
+	return fmt.Errorf("ollama API returned %s: %s", resp.Status, message)
+}
+
+// talkToOllamaOnce requests a complete, non-streamed response and prints it
+// in one go, without carriage returns or typing delays
+func talkToOllamaOnce(url string, ollamaReq Request, onContent func(string)) (agentStreamResponse, error) {
+	ollamaReq.Stream = false
+
 	js, err := json.Marshal(&ollamaReq)
 	if err != nil {
-		return err
+		return agentStreamResponse{}, err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return agentStreamResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(resp); err != nil {
+		return agentStreamResponse{}, err
+	}
+
+	var final agentStreamResponse
+	if err := json.NewDecoder(resp.Body).Decode(&final); err != nil {
+		return agentStreamResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if final.Error != "" {
+		return agentStreamResponse{}, fmt.Errorf("ollama error: %s", final.Error)
+	}
+
+	if !quietOutput {
+		fmt.Print(final.Message.Content)
+	}
+	if onContent != nil {
+		onContent(final.Message.Content)
+	}
+
+	lastStats = StreamStats{
+		EvalCount:    final.EvalCount,
+		EvalDuration: time.Duration(final.EvalDuration),
+		LoadDuration: time.Duration(final.LoadDuration),
+		Truncated:    wasTruncated(final, requestNumPredict(ollamaReq)),
+	}
+	noteTruncation(final, ollamaReq)
+	noteModelLoad(final)
+	return final, nil
+}
+
+// talkToOllamaStream handles streaming responses with enhanced typing effect.
+// It returns the final chunk, which carries the model and usage statistics.
+func talkToOllamaStream(url string, ollamaReq Request, onContent func(string), stopTyping chan bool) (agentStreamResponse, error) {
+	if !streamingEnabled {
+		return talkToOllamaOnce(url, ollamaReq, onContent)
+	}
+
+	js, err := json.Marshal(&ollamaReq)
+	if err != nil {
+		return agentStreamResponse{}, err
 	}
 
 	client := http.Client{}
 	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(js))
 	if err != nil {
-		return err
+		return agentStreamResponse{}, err
 	}
 
 	httpResp, err := client.Do(httpReq)
 	if err != nil {
-		return err
+		return agentStreamResponse{}, err
 	}
 	defer httpResp.Body.Close()
 
+	if err := statusError(httpResp); err != nil {
+		select {
+		case stopTyping <- true:
+		default:
+		}
+		if !quietOutput {
+			fmt.Print("\r")
+		}
+		return agentStreamResponse{}, err
+	}
+
 	// Read streaming response line by line
+	lastStats = StreamStats{}
 	scanner := bufio.NewScanner(httpResp.Body)
 	firstToken := true
+	var final agentStreamResponse
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -415,6 +1065,18 @@ func talkToOllamaStream(url string, ollamaReq Request, onContent func(string), s
 			continue // Skip malformed JSON lines
 		}
 
+		// Ollama reports failures such as a missing model as an error object
+		if streamResp.Error != "" {
+			select {
+			case stopTyping <- true:
+			default:
+			}
+			if !quietOutput {
+				fmt.Print("\r")
+			}
+			return agentStreamResponse{}, fmt.Errorf("ollama error: %s", streamResp.Error)
+		}
+
 		// Print the content as it streams
 		if streamResp.Message.Content != "" {
 			// Clear thinking indicator on first token
@@ -424,13 +1086,17 @@ func talkToOllamaStream(url string, ollamaReq Request, onContent func(string), s
 				case stopTyping <- true:
 				default:
 				}
-				fmt.Print("\r🤖 AI: ") // Clear thinking indicator and reset to AI prompt
+				if !quietOutput {
+					fmt.Print("\r🤖 AI: ") // Clear thinking indicator and reset to AI prompt
+				}
 				firstToken = false
 			}
 
-			// Add small delay to simulate typing speed
-			time.Sleep(10 * time.Millisecond)
-			fmt.Print(streamResp.Message.Content)
+			if !quietOutput {
+				// Add small delay to simulate typing speed
+				time.Sleep(10 * time.Millisecond)
+				fmt.Print(streamResp.Message.Content)
+			}
 
 			// Call the callback to store content
 			if onContent != nil {
@@ -440,11 +1106,20 @@ func talkToOllamaStream(url string, ollamaReq Request, onContent func(string), s
 
 		// Check if streaming is done
 		if streamResp.Done {
+			lastStats = StreamStats{
+				EvalCount:    streamResp.EvalCount,
+				EvalDuration: time.Duration(streamResp.EvalDuration),
+				LoadDuration: time.Duration(streamResp.LoadDuration),
+				Truncated:    wasTruncated(streamResp, requestNumPredict(ollamaReq)),
+			}
+			noteTruncation(streamResp, ollamaReq)
+			noteModelLoad(streamResp)
+			final = streamResp
 			break
 		}
 	}
 
-	return scanner.Err()
+	return final, scanner.Err()
 }
 
 // OllamaModel represents a model from Ollama
@@ -467,8 +1142,61 @@ type OllamaModelsResponse struct {
 	Models []OllamaModel `json:"models"`
 }
 
-// ListOllamaModels fetches and returns the list of installed Ollama models
+// defaultModelCacheTTL is how long the installed model list is reused, so
+// commands that look at it several times don't each ask Ollama
+const defaultModelCacheTTL = 30 * time.Second
+
+// modelCache holds the last installed model list; the MCP server runs in the
+// same process, so it is guarded by a mutex
+var modelCache struct {
+	sync.Mutex
+	models    []OllamaModel
+	fetchedAt time.Time
+}
+
+// ListOptions control how the installed model list is fetched
+type ListOptions struct {
+	ForceRefresh bool // Ask Ollama even when the cached list is still fresh
+}
+
+// modelCacheTTL returns the configured lifetime of the cached model list
+func modelCacheTTL() time.Duration {
+	ttl := config.Get().ModelCacheTTL
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl == 0:
+		return defaultModelCacheTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// ListOllamaModels returns the installed models, reusing a recently fetched list
 func ListOllamaModels() ([]OllamaModel, error) {
+	return ListOllamaModelsWithOptions(ListOptions{})
+}
+
+// ListOllamaModelsWithOptions returns the installed models
+func ListOllamaModelsWithOptions(opts ListOptions) ([]OllamaModel, error) {
+	modelCache.Lock()
+	defer modelCache.Unlock()
+
+	ttl := modelCacheTTL()
+	if !opts.ForceRefresh && ttl > 0 && modelCache.models != nil && time.Since(modelCache.fetchedAt) < ttl {
+		return slices.Clone(modelCache.models), nil
+	}
+
+	models, err := fetchOllamaModels()
+	if err != nil {
+		return nil, err
+	}
+	modelCache.models = models
+	modelCache.fetchedAt = time.Now()
+	return slices.Clone(models), nil
+}
+
+// fetchOllamaModels asks Ollama for the installed models
+func fetchOllamaModels() ([]OllamaModel, error) {
 	client := http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Get(ollamaListURL)
 	if err != nil {
@@ -501,6 +1229,7 @@ func TalkToOllamaWithTyping(userInput string) {
 		Model:    currentModel,
 		Stream:   true,
 		Messages: []agent.Message{msg},
+		Options:  requestOptions(),
 	}
 
 	fmt.Print("🤖 AI: ")
@@ -525,6 +1254,11 @@ func TalkToOllamaWithTyping(userInput string) {
 }
 
 func talkToOllamaStreamEnhanced(url string, ollamaReq Request) error {
+	if !streamingEnabled {
+		_, err := talkToOllamaOnce(url, ollamaReq, nil)
+		return err
+	}
+
 	js, err := json.Marshal(&ollamaReq)
 	if err != nil {
 		return err
@@ -542,6 +1276,11 @@ func talkToOllamaStreamEnhanced(url string, ollamaReq Request) error {
 	}
 	defer httpResp.Body.Close()
 
+	if err := statusError(httpResp); err != nil {
+		return err
+	}
+
+	lastStats = StreamStats{}
 	scanner := bufio.NewScanner(httpResp.Body)
 	firstToken := true
 
@@ -556,6 +1295,10 @@ func talkToOllamaStreamEnhanced(url string, ollamaReq Request) error {
 			continue
 		}
 
+		if streamResp.Error != "" {
+			return fmt.Errorf("ollama error: %s", streamResp.Error)
+		}
+
 		if streamResp.Message.Content != "" {
 			// Clear typing indicator on first token
 			if firstToken {
@@ -569,6 +1312,14 @@ func talkToOllamaStreamEnhanced(url string, ollamaReq Request) error {
 		}
 
 		if streamResp.Done {
+			lastStats = StreamStats{
+				EvalCount:    streamResp.EvalCount,
+				EvalDuration: time.Duration(streamResp.EvalDuration),
+				LoadDuration: time.Duration(streamResp.LoadDuration),
+				Truncated:    wasTruncated(streamResp, requestNumPredict(ollamaReq)),
+			}
+			noteTruncation(streamResp, ollamaReq)
+			noteModelLoad(streamResp)
 			break
 		}
 	}
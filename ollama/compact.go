@@ -0,0 +1,145 @@
+package ollama
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/muratbekj/silent-code/agent"
+	"github.com/muratbekj/silent-code/config"
+	"github.com/muratbekj/silent-code/history"
+)
+
+// compactKeepMessages is how many of the latest messages /compact leaves
+// out of the summary, so the current thread stays word for word
+const compactKeepMessages = 4
+
+// compactPrompt asks the model to fold older conversation into a summary
+const compactPrompt = `Summarize the conversation below so it can replace the original messages as context for the rest of the conversation. Keep the facts, decisions, file names, code identifiers, and open questions; drop greetings and repetition. Write it as concise notes, not as a dialogue.
+%s
+CONVERSATION:
+%s`
+
+// promptHistory picks the part of a conversation that goes into the prompt:
+// the /compact summary, if any, then the latest messages that fit the
+// configured message count and token budget. omitted counts the messages
+// left out that the summary doesn't cover.
+func promptHistory(conversation *agent.Conversation) (summary string, messages []agent.Message, omitted int) {
+	all := conversation.Messages
+	covered := min(conversation.SummarizedCount, len(all))
+	if covered > 0 {
+		summary = conversation.Summary
+	}
+
+	start := covered
+	cfg := config.Get()
+	if cfg.HistoryMessages > 0 && len(all)-start > cfg.HistoryMessages {
+		start = len(all) - cfg.HistoryMessages
+	}
+	if cfg.HistoryTokens > 0 {
+		// Always keep the latest message, however long
+		budget := cfg.HistoryTokens
+		i := len(all) - 1
+		for ; i >= start; i-- {
+			budget -= agent.EstimateTokens(formatHistoryMessage(all[i]))
+			if budget < 0 {
+				break
+			}
+		}
+		if budget < 0 {
+			start = min(i+1, len(all)-1)
+		}
+	}
+
+	return summary, all[start:], start - covered
+}
+
+// formatHistoryMessage formats a message as a line of the prompt's history
+func formatHistoryMessage(msg agent.Message) string {
+	return fmt.Sprintf("%s: %s", msg.Role, msg.Content)
+}
+
+// compactSummaryShare is the part of the context window kept free for the
+// previous summary and the new one; a chunk of transcript gets the rest
+const compactSummaryShare = 2
+
+// CompactSession summarizes all but the latest few messages of a session
+// into its summary, which then stands in for them in prompts. The messages
+// stay in the session. Messages that don't fit the context window at once
+// are summarized in chunks, each folded into the summary of the ones
+// before. It returns how many messages were newly summarized, which is
+// nonzero along with an error if a later chunk failed.
+func CompactSession(sessionID string, historyManager *history.HistoryManager) (int, error) {
+	if historyManager == nil {
+		return 0, fmt.Errorf("no conversation history")
+	}
+	conversation, err := historyManager.LoadSession(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	covered := min(conversation.SummarizedCount, len(conversation.Messages))
+	end := len(conversation.Messages) - compactKeepMessages
+	if end <= covered {
+		return 0, nil
+	}
+	summary := ""
+	if covered > 0 {
+		summary = conversation.Summary
+	}
+
+	budget := EffectiveContextWindow() - EffectiveContextWindow()/compactSummaryShare - agent.EstimateTokens(compactPrompt)
+	start := covered
+	for start < end {
+		// Always take at least one message, cut down if it's too long alone
+		var transcript []string
+		chunkBudget := budget
+		next := start
+		for ; next < end; next++ {
+			line := formatHistoryMessage(conversation.Messages[next])
+			tokens := agent.EstimateTokens(line)
+			if next > start && tokens > chunkBudget {
+				break
+			}
+			transcript = append(transcript, agent.TruncateToTokens(line, chunkBudget))
+			chunkBudget -= tokens
+		}
+
+		chunkSummary, err := summarizeTranscript(summary, transcript)
+		if err != nil {
+			return start - covered, err
+		}
+		if err := historyManager.SetSummary(sessionID, chunkSummary, next); err != nil {
+			return start - covered, err
+		}
+		summary = chunkSummary
+		start = next
+	}
+	return end - covered, nil
+}
+
+// summarizeTranscript asks the model to summarize transcript lines, folding
+// in the summary of the conversation before them, if any
+func summarizeTranscript(previous string, transcript []string) (string, error) {
+	if previous != "" {
+		previous = fmt.Sprintf("\nSUMMARY OF THE CONVERSATION BEFORE THIS:\n%s\n", previous)
+	}
+
+	req := Request{
+		Model:  currentModel,
+		Stream: true,
+		Messages: []agent.Message{
+			{Role: "user", Content: fmt.Sprintf(compactPrompt, previous, strings.Join(transcript, "\n"))},
+		},
+		Options: requestOptionsFor(config.OpChat, nil),
+	}
+	var summary strings.Builder
+	if _, err := talkToOllamaStream(defaultOllamaURL, req, func(content string) {
+		summary.WriteString(content)
+	}, showTypingIndicator()); err != nil {
+		return "", fmt.Errorf("error talking to Ollama: %w", err)
+	}
+	if strings.TrimSpace(summary.String()) == "" {
+		return "", fmt.Errorf("the model returned an empty summary")
+	}
+	return strings.TrimSpace(summary.String()), nil
+}
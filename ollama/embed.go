@@ -0,0 +1,107 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ollamaEmbedURL = "http://localhost:11434/api/embed"
+
+// DefaultEmbedModel is used for embeddings until another model is configured
+const DefaultEmbedModel = "nomic-embed-text"
+
+// Embedding model, kept separate from currentModel since chat models make poor embedders
+var currentEmbedModel = DefaultEmbedModel
+
+type embedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embedResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+	Error      string      `json:"error"`
+}
+
+// SetEmbedModel sets the model used for embeddings after checking it is installed
+func SetEmbedModel(modelName string) error {
+	installed, err := findInstalledModel(modelName)
+	if err != nil {
+		return err
+	}
+	currentEmbedModel = installed
+	return nil
+}
+
+// GetEmbedModel returns the model used for embeddings
+func GetEmbedModel() string {
+	return currentEmbedModel
+}
+
+// EnsureEmbedModel checks that the embedding model is installed, so an index
+// build fails up front rather than on the first file
+func EnsureEmbedModel() error {
+	installed, err := findInstalledModel(currentEmbedModel)
+	if err != nil {
+		return fmt.Errorf("%w. Install it with: ollama pull %s", err, currentEmbedModel)
+	}
+	currentEmbedModel = installed
+	return nil
+}
+
+// findInstalledModel returns the installed model matching name, which may
+// omit the ":latest" tag
+func findInstalledModel(name string) (string, error) {
+	models, err := ListOllamaModels()
+	if err != nil {
+		return "", fmt.Errorf("failed to list models: %w", err)
+	}
+
+	for _, model := range models {
+		if model.Name == name || model.Name == name+":latest" {
+			return model.Name, nil
+		}
+	}
+	return "", fmt.Errorf("embedding model '%s' is not installed", name)
+}
+
+// Embed returns one embedding vector per input using the embedding model
+func Embed(inputs []string) ([][]float64, error) {
+	js, err := json.Marshal(embedRequest{Model: currentEmbedModel, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.Client{Timeout: 120 * time.Second}
+	resp, err := client.Post(ollamaEmbedURL, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := statusError(resp); err != nil {
+		return nil, err
+	}
+
+	var embedResp embedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if embedResp.Error != "" {
+		return nil, fmt.Errorf("ollama error: %s", embedResp.Error)
+	}
+	if len(embedResp.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(embedResp.Embeddings))
+	}
+
+	return embedResp.Embeddings, nil
+}
+
+// IsEmbedModel reports whether a model name looks like a dedicated embedding model
+func IsEmbedModel(name string) bool {
+	return strings.Contains(strings.ToLower(name), "embed")
+}
@@ -22,6 +22,14 @@ func GetReasoningSummary(sessionID string) (string, error) {
 	return reasoningManager.GetReasoningSummary(sessionID)
 }
 
+// AbortReasoning cancels the reasoning session for sessionID
+func AbortReasoning(sessionID string) (*agent.MultiTurnReasoning, error) {
+	if reasoningManager == nil {
+		return nil, fmt.Errorf("reasoning manager not initialized")
+	}
+	return reasoningManager.AbortReasoning(sessionID)
+}
+
 // AddReasoningStep adds a new step to the reasoning process
 func AddReasoningStep(sessionID, thought, action string) error {
 	if reasoningManager == nil {
@@ -0,0 +1,45 @@
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const ollamaGenerateURL = "http://localhost:11434/api/generate"
+
+// warmupKeepAlive is how long Ollama keeps a warmed-up model loaded waiting
+// for the first question
+const warmupKeepAlive = "10m"
+
+type warmupRequest struct {
+	Model     string `json:"model"`
+	Prompt    string `json:"prompt"`
+	KeepAlive string `json:"keep_alive"`
+}
+
+// WarmUp loads a model into memory with an empty generate request, so the
+// first real query doesn't pay the load time. Nothing is generated.
+func WarmUp(model string) error {
+	js, err := json.Marshal(warmupRequest{Model: model, KeepAlive: warmupKeepAlive})
+	if err != nil {
+		return err
+	}
+
+	// Loading a large model from disk can take a while
+	client := http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Post(ollamaGenerateURL, "application/json", bytes.NewReader(js))
+	if err != nil {
+		return fmt.Errorf("failed to connect to ollama: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama API returned status %d", resp.StatusCode)
+	}
+	return nil
+}